@@ -19,6 +19,7 @@ import (
 	"github.com/golang/sync/errgroup"
 	"github.com/velour/chat"
 	"github.com/velour/chat/bridge"
+	"github.com/velour/chat/cache"
 	"github.com/velour/chat/discord"
 	"github.com/velour/chat/irc"
 	"github.com/velour/chat/slack"
@@ -27,23 +28,34 @@ import (
 
 var (
 	telegramToken        = flag.String("telegram-token", "", "The bot's Telegram token")
-	telegramGroup        = flag.String("telegram-group", "", "The bot's Telegram group ID")
+	telegramGroup        = flag.String("telegram-group", "", "The bot's Telegram group ID, or <group ID>/<topic thread ID> for a forum topic")
 	telegramNoWebPreview = flag.String("telegram-no-web-preview", "", "A regexp that prevents webpreview for sends if the text matches.")
+	telegramAPIURL       = flag.String("telegram-api-url", "", "The Telegram Bot API base URL (default https://api.telegram.org)")
+	telegramWebhook      = flag.Bool("telegram-webhook", false, "Whether to receive Telegram updates by webhook at -http-public instead of long polling")
+	telegramSecret       = flag.String("telegram-webhook-secret", "", "The Telegram webhook secret token")
+	telegramBatchDeletes = flag.Duration("telegram-batch-deletes", 0, "If non-zero, the time that deletes are batched before deleting them from Telegram in bulk")
 
 	ircNick    = flag.String("irc-nick", "", "The bot's IRC nickname")
 	ircPass    = flag.String("irc-password", "", "The bot's IRC password")
 	ircServer  = flag.String("irc-server", "irc.freenode.net:6697", "The IRC server")
 	ircChannel = flag.String("irc-channel", "#velour-test", "The IRC channel")
 
-	slackToken = flag.String("slack-token", "", "The bot's Slack token")
-	slackRoom  = flag.String("slack-room", "", "The bot's slack room name (not ID)")
+	slackToken          = flag.String("slack-token", "", "The bot's Slack token")
+	slackRoom           = flag.String("slack-room", "", "The bot's slack room name (not ID)")
+	slackAllowBroadcast = flag.Bool("slack-allow-broadcast", false, "Whether @here, @channel, and @everyone sent to Slack notify the room")
 
 	discordToken        = flag.String("discord-token", "", "The bot's Discord token")
-	discordChannel      = flag.String("discord-channel", "", "Discord server_name:channel_name")
+	discordChannel      = flag.String("discord-channel", "", "Discord channel ID, server_name/channel_name, server_name/channel_name/thread_name for a thread, or user ID for direct messages")
 	discordRewriteNames = flag.String("discord-rewrite-names", "", "A comma-delimited list of <name>:<newname> pairs used to rewrite Discord display names.")
+	discordMembers      = flag.Bool("discord-members", false, "Whether to receive Discord member joins, leaves, and renames (requires the privileged Server Members intent)")
+	discordCompress     = flag.Bool("discord-compress", false, "Whether to compress the Discord gateway connection")
+	discordWebhooks     = flag.Bool("discord-webhooks", false, "Whether to send messages to Discord through a channel webhook, with the name and avatar of their sender")
 
 	httpPublic = flag.String("http-public", "http://localhost:8888", "The bridge's public base URL")
 	httpServe  = flag.String("http-serve", "localhost:8888", "The bridge's HTTP server host")
+
+	mediaCacheDir  = flag.String("media-cache-dir", "", "If set, the directory in which to cache media served by the bridge")
+	mediaCacheSize = flag.Int64("media-cache-size", 1<<30, "The maximum size in bytes of the media cache, or 0 for no limit")
 )
 
 func main() {
@@ -52,6 +64,16 @@ func main() {
 
 	channels := []chat.Channel{}
 
+	// mediaHandler wraps the media handlers of the clients.
+	mediaHandler := func(h http.Handler) http.Handler { return h }
+	if *mediaCacheDir != "" {
+		mediaCache, err := cache.NewDisk(*mediaCacheDir, *mediaCacheSize)
+		if err != nil {
+			panic(err)
+		}
+		mediaHandler = mediaCache.Handler
+	}
+
 	if *ircNick != "" {
 		ircClient, err := irc.DialSSL(ctx, *ircServer, *ircNick, *ircNick, *ircPass, false)
 		if err != nil {
@@ -71,7 +93,11 @@ func main() {
 	}
 
 	if *telegramToken != "" {
-		telegramClient, err := telegram.Dial(ctx, *telegramToken)
+		telegramClient, err := telegram.DialOptions(ctx, *telegramToken, telegram.Options{
+			APIURL:        *telegramAPIURL,
+			Webhook:       *telegramWebhook,
+			WebhookSecret: *telegramSecret,
+		})
 		if err != nil {
 			panic(err)
 		}
@@ -95,8 +121,12 @@ func main() {
 			}).NoWebPreview(re)
 		}
 
+		telegramChannel.(interface {
+			BatchDeletes(time.Duration)
+		}).BatchDeletes(*telegramBatchDeletes)
+
 		const telegramMediaPath = "/telegram/media/"
-		http.Handle(telegramMediaPath, telegramClient)
+		http.Handle(telegramMediaPath, mediaHandler(telegramClient))
 		baseURL, err := url.Parse(*httpPublic)
 		if err != nil {
 			panic(err)
@@ -104,6 +134,19 @@ func main() {
 		baseURL.Path = path.Join(baseURL.Path, telegramMediaPath)
 		telegramClient.SetLocalURL(*baseURL)
 
+		if *telegramWebhook {
+			const telegramWebhookPath = "/telegram/webhook"
+			http.Handle(telegramWebhookPath, telegramClient.WebhookHandler())
+			hookURL, err := url.Parse(*httpPublic)
+			if err != nil {
+				panic(err)
+			}
+			hookURL.Path = path.Join(hookURL.Path, telegramWebhookPath)
+			if err := telegramClient.SetWebhook(ctx, hookURL.String()); err != nil {
+				panic(err)
+			}
+		}
+
 		channels = append(channels, telegramChannel)
 	}
 
@@ -121,15 +164,31 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
+		slackChannel.(interface {
+			AllowBroadcast(bool)
+		}).AllowBroadcast(*slackAllowBroadcast)
+
+		const slackMediaPath = "/slack/media/"
+		http.Handle(slackMediaPath, mediaHandler(slackClient))
+		baseURL, err := url.Parse(*httpPublic)
+		if err != nil {
+			panic(err)
+		}
+		baseURL.Path = path.Join(baseURL.Path, slackMediaPath)
+		slackClient.SetLocalURL(*baseURL)
+
 		channels = append(channels, slackChannel)
 	}
 
 	if *discordToken != "" {
-		serverChan := strings.Split(*discordChannel, ":")
-		if *discordChannel != "" && len(serverChan) != 2 {
-			panic("malformed -discord-channel")
+		discordIntents := discord.DefaultIntents
+		if *discordMembers {
+			discordIntents |= discord.IntentGuildMembers
 		}
-		discordClient, err := discord.Dial(ctx, *discordToken)
+		discordClient, err := discord.DialOptions(ctx, *discordToken, discord.Options{
+			Intents:  discordIntents,
+			Compress: *discordCompress,
+		})
 		if err != nil {
 			panic(err)
 		}
@@ -147,7 +206,8 @@ func main() {
 			discordClient.RewriteName(r[0], r[1])
 		}
 
-		discordChan, err := discordClient.Join(ctx, serverChan[0], serverChan[1])
+		discordClient.UseWebhooks(*discordWebhooks)
+		discordChan, err := discordClient.Join(ctx, *discordChannel)
 		if err != nil {
 			panic(err)
 		}
@@ -193,6 +253,9 @@ loop:
 				}
 				break loop
 			}
+		case telegram.Migrate:
+			log.Printf("Telegram group %d was upgraded to supergroup %d; update -telegram-group to %d\n",
+				m.FromID, m.ToID, m.ToID)
 		}
 	}
 	if err := b.Close(ctx); err != nil {
@@ -0,0 +1,170 @@
+// Package cache implements a bounded, expiring, in-memory cache.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// A Cache is a least-recently-used cache with a maximum number of entries.
+// Entries expire a fixed duration after they are added.
+//
+// A Cache is safe for concurrent use.
+type Cache struct {
+	maxEntries int
+	ttl        time.Duration
+	// now returns the current time; it is replaced in tests.
+	now func() time.Time
+
+	sync.Mutex
+	// lru is the list of entries, most recently used first.
+	lru     *list.List
+	entries map[interface{}]*list.Element
+	// fetches are the in-progress fetches, keyed by the entry key.
+	fetches map[interface{}]*fetch
+}
+
+type entry struct {
+	key, value interface{}
+	expires    time.Time
+}
+
+// A fetch is an in-progress call to a fetch function.
+type fetch struct {
+	// done is closed when the fetch is complete.
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// New returns a new Cache.
+// If maxEntries is greater than 0, the least recently used entry is evicted
+// when adding an entry would exceed maxEntries.
+// If ttl is greater than 0, entries expire ttl after they are added.
+func New(maxEntries int, ttl time.Duration) *Cache {
+	return &Cache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		now:        time.Now,
+		lru:        list.New(),
+		entries:    make(map[interface{}]*list.Element),
+		fetches:    make(map[interface{}]*fetch),
+	}
+}
+
+// Get returns the value for the key and true,
+// or nil and false if the key is not cached or has expired.
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+	return c.get(key)
+}
+
+func (c *Cache) get(key interface{}) (interface{}, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if c.ttl > 0 && !c.now().Before(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.value, true
+}
+
+// Put adds the value for the key,
+// replacing any existing value and resetting its expiry.
+func (c *Cache) Put(key, value interface{}) {
+	c.Lock()
+	defer c.Unlock()
+	c.put(key, value)
+}
+
+func (c *Cache) put(key, value interface{}) {
+	expires := c.now().Add(c.ttl)
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expires = value, expires
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key: key, value: value, expires: expires})
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Remove removes the key from the Cache.
+func (c *Cache) Remove(key interface{}) {
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
+
+// Len returns the number of entries in the Cache,
+// including any expired entries not yet removed.
+func (c *Cache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.lru.Len()
+}
+
+// Fetch returns the value for the key.
+// If the key is not cached or has expired,
+// the value is fetched by calling f, and added to the Cache.
+//
+// Concurrent calls to Fetch for the same key share a single call to f.
+// Errors returned by f are returned to all waiting callers, but are not cached.
+// If the context is done before the value is fetched,
+// Fetch returns the context error.
+func (c *Cache) Fetch(ctx context.Context, key interface{}, f func(context.Context) (interface{}, error)) (interface{}, error) {
+	for {
+		c.Lock()
+		if v, ok := c.get(key); ok {
+			c.Unlock()
+			return v, nil
+		}
+		fe, ok := c.fetches[key]
+		if !ok {
+			fe = &fetch{done: make(chan struct{})}
+			c.fetches[key] = fe
+			c.Unlock()
+
+			fe.value, fe.err = f(ctx)
+
+			c.Lock()
+			delete(c.fetches, key)
+			if fe.err == nil {
+				c.put(key, fe.value)
+			}
+			c.Unlock()
+			close(fe.done)
+			return fe.value, fe.err
+		}
+		c.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-fe.done:
+		}
+		// If the fetching caller's context was done,
+		// the fetch failed through no fault of this caller; try again.
+		if errors.Is(fe.err, context.Canceled) || errors.Is(fe.err, context.DeadlineExceeded) {
+			continue
+		}
+		return fe.value, fe.err
+	}
+}
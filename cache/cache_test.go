@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEvict(t *testing.T) {
+	c := New(2, 0)
+	c.Put("a", 1)
+	c.Put("b", 2)
+	// Using a makes b the least recently used.
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("c.Get(a)=%v,%v, want 1,true", v, ok)
+	}
+	c.Put("c", 3)
+	if v, ok := c.Get("b"); ok {
+		t.Errorf("c.Get(b)=%v,%v, want evicted", v, ok)
+	}
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if v, ok := c.Get(key); !ok || v != want {
+			t.Errorf("c.Get(%s)=%v,%v, want %d,true", key, v, ok, want)
+		}
+	}
+	if n := c.Len(); n != 2 {
+		t.Errorf("c.Len()=%d, want 2", n)
+	}
+	c.Remove("a")
+	if v, ok := c.Get("a"); ok {
+		t.Errorf("c.Get(a)=%v,%v, want removed", v, ok)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	now := time.Now()
+	c := New(0, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.Put("a", 1)
+	now = now.Add(59 * time.Second)
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Errorf("c.Get(a)=%v,%v before expiry, want 1,true", v, ok)
+	}
+	now = now.Add(time.Second)
+	if v, ok := c.Get("a"); ok {
+		t.Errorf("c.Get(a)=%v,%v after expiry, want expired", v, ok)
+	}
+	if n := c.Len(); n != 0 {
+		t.Errorf("c.Len()=%d, want 0", n)
+	}
+
+	var fetches int
+	fetch := func(context.Context) (interface{}, error) {
+		fetches++
+		return fetches, nil
+	}
+	ctx := context.Background()
+	if v, err := c.Fetch(ctx, "b", fetch); err != nil || v != 1 {
+		t.Errorf("c.Fetch(_, b, _)=%v,%v, want 1,nil", v, err)
+	}
+	if v, err := c.Fetch(ctx, "b", fetch); err != nil || v != 1 {
+		t.Errorf("c.Fetch(_, b, _)=%v,%v before expiry, want 1,nil", v, err)
+	}
+	now = now.Add(time.Minute)
+	if v, err := c.Fetch(ctx, "b", fetch); err != nil || v != 2 {
+		t.Errorf("c.Fetch(_, b, _)=%v,%v after expiry, want 2,nil", v, err)
+	}
+}
+
+func TestFetchError(t *testing.T) {
+	c := New(0, 0)
+	ctx := context.Background()
+	errFetch := errors.New("fetch failed")
+	if _, err := c.Fetch(ctx, "a", func(context.Context) (interface{}, error) { return nil, errFetch }); err != errFetch {
+		t.Errorf("c.Fetch(_, a, _)=_,%v, want %v", err, errFetch)
+	}
+	// Errors are not cached.
+	if v, err := c.Fetch(ctx, "a", func(context.Context) (interface{}, error) { return 1, nil }); err != nil || v != 1 {
+		t.Errorf("c.Fetch(_, a, _)=%v,%v, want 1,nil", v, err)
+	}
+}
+
+// TestFetchConcurrent tests that concurrent fetches of the same key
+// share a single call to the fetch function.
+func TestFetchConcurrent(t *testing.T) {
+	c := New(0, 0)
+	var calls int32
+	release := make(chan struct{})
+	fetch := func(context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "value", nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if v, err := c.Fetch(ctx, "a", fetch); err != nil || v != "value" {
+				t.Errorf("c.Fetch(_, a, _)=%v,%v, want value,nil", v, err)
+			}
+		}()
+	}
+	// Wait for all goroutines to be waiting on the fetch.
+	for {
+		c.Lock()
+		fe := c.fetches["a"]
+		c.Unlock()
+		if fe != nil && atomic.LoadInt32(&calls) == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("fetch called %d times, want 1", calls)
+	}
+}
+
+// TestFetchCanceled tests that a waiting fetch is not failed
+// by the cancellation of the context of the fetching caller,
+// even if the context error is wrapped, as by an HTTP request.
+func TestFetchCanceled(t *testing.T) {
+	tests := []struct {
+		name string
+		err  func(context.Context) error
+		want func(error) bool
+	}{
+		{
+			name: "context error",
+			err:  func(ctx context.Context) error { return ctx.Err() },
+			want: func(err error) bool { return err == context.Canceled },
+		},
+		{
+			name: "wrapped context error",
+			err: func(ctx context.Context) error {
+				return &url.Error{Op: "Get", URL: "http://example.com", Err: ctx.Err()}
+			},
+			want: func(err error) bool { _, ok := err.(*url.Error); return ok },
+		},
+	}
+	for _, test := range tests {
+		c := New(0, 0)
+		started := make(chan struct{})
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		errc := make(chan error)
+		go func() {
+			_, err := c.Fetch(ctx1, "a", func(ctx context.Context) (interface{}, error) {
+				close(started)
+				<-ctx.Done()
+				return nil, test.err(ctx)
+			})
+			errc <- err
+		}()
+		<-started
+
+		ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Second)
+		vc := make(chan interface{})
+		go func() {
+			v, err := c.Fetch(ctx2, "a", func(context.Context) (interface{}, error) { return 2, nil })
+			if err != nil {
+				t.Errorf("%s: c.Fetch(_, a, _)=_,%v, want nil", test.name, err)
+			}
+			vc <- v
+		}()
+		// Give the second Fetch time to wait on the first.
+		time.Sleep(10 * time.Millisecond)
+		cancel1()
+		if err := <-errc; !test.want(err) {
+			t.Errorf("%s: c.Fetch(canceled, a, _)=_,%v", test.name, err)
+		}
+		if v := <-vc; v != 2 {
+			t.Errorf("%s: c.Fetch(_, a, _)=%v, want 2", test.name, v)
+		}
+		cancel2()
+	}
+}
@@ -0,0 +1,387 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	indexFile = "index.json"
+	tmpPrefix = "tmp-"
+)
+
+// A Disk is a content-addressed cache of HTTP responses, stored on disk.
+//
+// Responses are cached by request path,
+// and stored in files named by the SHA-256 hash of their content.
+// Responses with the same content share a single file.
+// The index of cached paths is saved in the cache directory,
+// so cached responses remain available after a restart.
+type Disk struct {
+	dir      string
+	maxBytes int64
+
+	sync.Mutex
+	// entries are the cached responses, keyed by request path.
+	entries map[string]*diskEntry
+	// refs is the number of entries referencing each content hash.
+	refs map[string]int
+	// size is the total size in bytes of all cached content.
+	size int64
+	// fills are closed when the in-progress fill of a path is complete.
+	fills map[string]chan struct{}
+}
+
+type diskEntry struct {
+	Hash        string
+	ContentType string
+	Size        int64
+	Modified    time.Time
+	// Used is the last time the entry was served.
+	Used time.Time
+}
+
+// NewDisk returns a new Disk cache in the directory,
+// creating the directory if it does not exist,
+// and loading the index of any responses already cached there.
+//
+// If maxBytes is greater than 0, the least recently used responses
+// are evicted when the total size of the cached content exceeds maxBytes.
+func NewDisk(dir string, maxBytes int64) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	d := &Disk{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*diskEntry),
+		refs:     make(map[string]int),
+		fills:    make(map[string]chan struct{}),
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, indexFile))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &d.entries); err != nil {
+			return nil, err
+		}
+	}
+	for key, e := range d.entries {
+		if _, err := os.Stat(d.contentPath(e.Hash)); err != nil {
+			delete(d.entries, key)
+			continue
+		}
+		if d.refs[e.Hash] == 0 {
+			d.size += e.Size
+		}
+		d.refs[e.Hash]++
+	}
+
+	// Remove leftover temporary files and unreferenced content.
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasPrefix(name, tmpPrefix) || isHash(name) && d.refs[name] == 0 {
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	d.evict()
+	return d, d.saveIndex()
+}
+
+// Handler returns an http.Handler that serves GET and HEAD requests
+// from the Disk cache, filling the cache from h on a miss.
+// All other requests are passed to h.
+//
+// Only successful responses are cached.
+// Cached responses are served with an ETag of their content hash,
+// and support conditional and Range requests.
+// If h does not set a Content-Type, it is detected from the content.
+func (d *Disk) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			h.ServeHTTP(w, req)
+			return
+		}
+		key := req.URL.Path
+		for {
+			d.Lock()
+			if e, ok := d.entries[key]; ok {
+				e.Used = time.Now()
+				entry := *e
+				d.Unlock()
+				if d.serve(w, req, &entry) {
+					return
+				}
+				// The content was evicted after the entry was found.
+				continue
+			}
+			if done, ok := d.fills[key]; ok {
+				d.Unlock()
+				select {
+				case <-req.Context().Done():
+					http.Error(w, req.Context().Err().Error(), http.StatusServiceUnavailable)
+					return
+				case <-done:
+				}
+				continue
+			}
+			done := make(chan struct{})
+			d.fills[key] = done
+			d.Unlock()
+
+			served := d.fill(w, req, h, key)
+
+			d.Lock()
+			delete(d.fills, key)
+			d.Unlock()
+			close(done)
+			if served {
+				return
+			}
+		}
+	})
+}
+
+// serve serves the cached content of an entry.
+// It returns false if the content could not be opened.
+func (d *Disk) serve(w http.ResponseWriter, req *http.Request, e *diskEntry) bool {
+	f, err := os.Open(d.contentPath(e.Hash))
+	if err != nil {
+		d.Lock()
+		if cur, ok := d.entries[req.URL.Path]; ok && cur.Hash == e.Hash {
+			d.remove(req.URL.Path)
+		}
+		d.Unlock()
+		return false
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", e.ContentType)
+	w.Header().Set("ETag", `"`+e.Hash+`"`)
+	http.ServeContent(w, req, "", e.Modified, f)
+	return true
+}
+
+// fill fills the cache for the key with the response of h to req,
+// and serves the response.
+// It returns false if the response was cached, but evicted before it was served.
+func (d *Disk) fill(w http.ResponseWriter, req *http.Request, h http.Handler, key string) bool {
+	tmp, err := ioutil.TempFile(d.dir, tmpPrefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// The full content is always requested from h;
+	// conditional and Range requests are handled when serving from the cache.
+	origReq := new(http.Request)
+	*origReq = *req
+	origReq.Method = http.MethodGet
+	origReq.Header = make(http.Header)
+	for k, vs := range req.Header {
+		if k != "Range" && !strings.HasPrefix(k, "If-") {
+			origReq.Header[k] = vs
+		}
+	}
+	rec := &recorder{header: make(http.Header), file: tmp, hash: sha256.New()}
+	h.ServeHTTP(rec, origReq)
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+	if rec.err != nil {
+		http.Error(w, rec.err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	if rec.code != http.StatusOK {
+		for k, vs := range rec.header {
+			w.Header()[k] = vs
+		}
+		w.WriteHeader(rec.code)
+		io.Copy(w, tmp)
+		return true
+	}
+
+	e := &diskEntry{
+		Hash:        hex.EncodeToString(rec.hash.Sum(nil)),
+		ContentType: rec.header.Get("Content-Type"),
+		Size:        rec.size,
+		Modified:    time.Now(),
+		Used:        time.Now(),
+	}
+	if t, err := http.ParseTime(rec.header.Get("Last-Modified")); err == nil {
+		e.Modified = t
+	}
+	if e.ContentType == "" {
+		var buf [512]byte
+		n, _ := io.ReadFull(tmp, buf[:])
+		e.ContentType = http.DetectContentType(buf[:n])
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return true
+		}
+	}
+	if d.maxBytes > 0 && e.Size > d.maxBytes {
+		// Too big to cache; serve it from the temporary file.
+		w.Header().Set("Content-Type", e.ContentType)
+		w.Header().Set("ETag", `"`+e.Hash+`"`)
+		http.ServeContent(w, req, "", e.Modified, tmp)
+		return true
+	}
+	if err := d.add(key, e, tmp.Name()); err != nil {
+		log.Printf("Failed to cache %s: %s\n", key, err)
+		w.Header().Set("Content-Type", e.ContentType)
+		http.ServeContent(w, req, "", e.Modified, tmp)
+		return true
+	}
+	return d.serve(w, req, e)
+}
+
+// add adds the entry for the key, with the content in the file at path.
+func (d *Disk) add(key string, e *diskEntry, path string) error {
+	d.Lock()
+	defer d.Unlock()
+	if d.refs[e.Hash] == 0 {
+		if err := os.Rename(path, d.contentPath(e.Hash)); err != nil {
+			return err
+		}
+		d.size += e.Size
+	}
+	if _, ok := d.entries[key]; ok {
+		d.remove(key)
+	}
+	d.entries[key] = e
+	d.refs[e.Hash]++
+	d.evict()
+	if err := d.saveIndex(); err != nil {
+		// The entry is still served, but is lost on restart.
+		log.Printf("Failed to save cache index: %s\n", err)
+	}
+	return nil
+}
+
+// evict removes the least recently used entries
+// until the size of the cached content is within the quota.
+// The caller must hold the lock.
+func (d *Disk) evict() {
+	for d.maxBytes > 0 && d.size > d.maxBytes {
+		var oldest string
+		for key, e := range d.entries {
+			if oldest == "" || e.Used.Before(d.entries[oldest].Used) {
+				oldest = key
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		d.remove(oldest)
+	}
+}
+
+// remove removes the entry for the key,
+// and its content if no other entry references it.
+// The caller must hold the lock.
+func (d *Disk) remove(key string) {
+	e := d.entries[key]
+	delete(d.entries, key)
+	if d.refs[e.Hash]--; d.refs[e.Hash] > 0 {
+		return
+	}
+	delete(d.refs, e.Hash)
+	d.size -= e.Size
+	if err := os.Remove(d.contentPath(e.Hash)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove cached content %s: %s\n", e.Hash, err)
+	}
+}
+
+// saveIndex writes the index of entries to the cache directory.
+// The caller must hold the lock.
+func (d *Disk) saveIndex() error {
+	data, err := json.Marshal(d.entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(d.dir, tmpPrefix)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(d.dir, indexFile))
+}
+
+func (d *Disk) contentPath(hash string) string {
+	return filepath.Join(d.dir, hash)
+}
+
+// isHash returns whether a file name is a hex-encoded SHA-256 hash.
+func isHash(name string) bool {
+	if len(name) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// A recorder is an http.ResponseWriter that records the response to a file.
+type recorder struct {
+	header http.Header
+	code   int
+	file   *os.File
+	hash   hash.Hash
+	size   int64
+	err    error
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.file.Write(p)
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
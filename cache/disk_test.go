@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// origin is a test http.Handler that serves the final path element
+// as the content, and counts the requests for each path.
+type origin struct {
+	sync.Mutex
+	requests map[string]int
+}
+
+func newOrigin() *origin {
+	return &origin{requests: make(map[string]int)}
+}
+
+func (o *origin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	o.Lock()
+	o.requests[req.URL.Path]++
+	o.Unlock()
+	if req.Header.Get("Range") != "" {
+		http.Error(w, "unexpected Range", http.StatusBadRequest)
+		return
+	}
+	name := path.Base(req.URL.Path)
+	switch {
+	case name == "missing":
+		http.Error(w, "not found", http.StatusNotFound)
+	case strings.HasSuffix(name, ".txt"):
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(name))
+	default:
+		// No Content-Type; it must be detected.
+		w.Write([]byte("<html>" + name + "</html>"))
+	}
+}
+
+func (o *origin) count(path string) int {
+	o.Lock()
+	defer o.Unlock()
+	return o.requests[path]
+}
+
+func get(t *testing.T, h http.Handler, path string, header map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "cache_test")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir(…)=_,%v", err)
+	}
+	return dir
+}
+
+func TestDisk(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	d, err := NewDisk(dir, 0)
+	if err != nil {
+		t.Fatalf("NewDisk(%q, 0)=_,%v", dir, err)
+	}
+	o := newOrigin()
+	h := d.Handler(o)
+
+	var etag string
+	for i := 0; i < 2; i++ {
+		w := get(t, h, "/media/a.txt", nil)
+		if w.Code != http.StatusOK || w.Body.String() != "a.txt" {
+			t.Errorf("GET /media/a.txt=%d %q, want 200 a.txt", w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/plain" {
+			t.Errorf("GET /media/a.txt Content-Type=%q, want text/plain", ct)
+		}
+		etag = w.Header().Get("ETag")
+	}
+	if n := o.count("/media/a.txt"); n != 1 {
+		t.Errorf("origin got %d requests, want 1", n)
+	}
+
+	if w := get(t, h, "/media/a.txt", map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+		t.Errorf("GET /media/a.txt If-None-Match: %s=%d, want 304", etag, w.Code)
+	}
+	w := get(t, h, "/media/a.txt", map[string]string{"Range": "bytes=2-"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "txt" {
+		t.Errorf("GET /media/a.txt Range: bytes=2-=%d %q, want 206 txt", w.Code, w.Body.String())
+	}
+
+	// Range requests are filled from the full content.
+	w = get(t, h, "/media/b", map[string]string{"Range": "bytes=0-5"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "<html>" {
+		t.Errorf("GET /media/b Range: bytes=0-5=%d %q, want 206 <html>", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("GET /media/b Content-Type=%q, want text/html; charset=utf-8", ct)
+	}
+
+	// Errors are not cached.
+	for i := 0; i < 2; i++ {
+		if w := get(t, h, "/media/missing", nil); w.Code != http.StatusNotFound {
+			t.Errorf("GET /media/missing=%d, want 404", w.Code)
+		}
+	}
+	if n := o.count("/media/missing"); n != 2 {
+		t.Errorf("origin got %d requests, want 2", n)
+	}
+
+	// Cached content is served after a restart.
+	d, err = NewDisk(dir, 0)
+	if err != nil {
+		t.Fatalf("NewDisk(%q, 0)=_,%v", dir, err)
+	}
+	h = d.Handler(o)
+	if w := get(t, h, "/media/a.txt", nil); w.Code != http.StatusOK || w.Body.String() != "a.txt" {
+		t.Errorf("GET /media/a.txt after restart=%d %q, want 200 a.txt", w.Code, w.Body.String())
+	}
+	if n := o.count("/media/a.txt"); n != 1 {
+		t.Errorf("origin got %d requests after restart, want 1", n)
+	}
+}
+
+func TestDiskEvict(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// Room for two of the 5-byte responses.
+	d, err := NewDisk(dir, 10)
+	if err != nil {
+		t.Fatalf("NewDisk(%q, 10)=_,%v", dir, err)
+	}
+	o := newOrigin()
+	h := d.Handler(o)
+
+	get(t, h, "/a.txt", nil)
+	get(t, h, "/b.txt", nil)
+	// The same content under another path is stored once.
+	get(t, h, "/x/a.txt", nil)
+	if d.size != 10 {
+		t.Errorf("d.size=%d, want 10", d.size)
+	}
+	// Using /a.txt makes /b.txt the least recently used.
+	get(t, h, "/a.txt", nil)
+	get(t, h, "/c.txt", nil)
+	if d.size != 10 {
+		t.Errorf("d.size=%d, want 10", d.size)
+	}
+	get(t, h, "/b.txt", nil)
+	if n := o.count("/b.txt"); n != 2 {
+		t.Errorf("origin got %d requests for evicted /b.txt, want 2", n)
+	}
+
+	// Responses bigger than the quota are served, but not cached.
+	if w := get(t, h, "/too-big.txt", nil); w.Code != http.StatusOK || w.Body.String() != "too-big.txt" {
+		t.Errorf("GET /too-big.txt=%d %q, want 200 too-big.txt", w.Code, w.Body.String())
+	}
+	if _, ok := d.entries["/too-big.txt"]; ok {
+		t.Errorf("/too-big.txt is cached")
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ioutil.ReadDir(%q)=_,%v", dir, err)
+	}
+	var files []string
+	for _, info := range infos {
+		files = append(files, info.Name())
+	}
+	// The index and two content files.
+	if len(files) != 3 {
+		t.Errorf("cache directory contains %v, want 3 files", files)
+	}
+}
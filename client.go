@@ -102,10 +102,35 @@ type Message struct {
 
 	// Text is the text of the Message.
 	Text string
+
+	// Attachments are files attached to the Message.
+	//
+	// The Text of a Message with Attachments
+	// should still make sense to services that ignore Attachments,
+	// for example, by including the Attachment URLs.
+	Attachments []Attachment
 }
 
 func (e Message) Origin() Channel { return e.From.Channel }
 
+// An Attachment is a file attached to a Message.
+type Attachment struct {
+	// URL is the URL from which the file can be downloaded.
+	URL string
+
+	// Name is the file name.
+	// If Name is empty, the name is unknown.
+	Name string
+
+	// MimeType is the MIME type of the file.
+	// If MimeType is empty, the type is unknown.
+	MimeType string
+
+	// Size is the size of the file in bytes.
+	// If Size is 0, the size is unknown.
+	Size int64
+}
+
 // A Delete is an event describing a message deleted by a user.
 type Delete struct {
 	// ID is the ID of the deleted message.
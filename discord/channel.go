@@ -3,6 +3,8 @@ package discord
 import (
 	"context"
 	"io"
+	"log"
+	"net/url"
 	"strings"
 
 	"github.com/velour/chat"
@@ -14,6 +16,12 @@ type Channel struct {
 	name      string
 	guildID   string
 	guildName string
+	// parentID is the ID of the parent channel if the Channel is a thread,
+	// and otherwise is empty.
+	parentID string
+
+	// webhook, if non-nil, is used to send messages from other users.
+	webhook *webhook
 
 	// in handles incoming events from the client.
 	// It supports non-blocking sends.
@@ -51,6 +59,10 @@ func stop(ch *Channel) {
 func (ch *Channel) Name() string { return ch.name }
 
 func (ch *Channel) ServiceName() string {
+	if ch.guildID == "" {
+		// A DM channel.
+		return "Discord " + ch.name
+	}
 	return "Discord " + ch.guildName + " " + ch.name
 }
 
@@ -66,14 +78,52 @@ func (ch *Channel) Receive(ctx context.Context) (chat.Event, error) {
 	}
 }
 
+// A messageRequest is the request to create a message.
+type messageRequest struct {
+	Content          string            `json:"content"`
+	Username         string            `json:"username,omitempty"`
+	AvatarURL        string            `json:"avatar_url,omitempty"`
+	MessageReference *messageReference `json:"message_reference,omitempty"`
+	Attachments      []attachment      `json:"attachments,omitempty"`
+}
+
+// Send sends a message to the Channel.
+// If the message has Attachments, they are uploaded with the message;
+// if they cannot be uploaded, the message is sent with their links.
 func (ch *Channel) Send(ctx context.Context, m chat.Message) (chat.Message, error) {
-	req := struct {
-		Content string `json:"content"`
-	}{
-		Content: content(ch, &m),
+	var req messageRequest
+	method := "channels/" + ch.id + "/messages"
+	if ch.viaWebhook(m.From) {
+		// wait=true makes Discord return the created message.
+		method = ch.webhookMethod("", url.Values{"wait": {"true"}})
+		req.Username = webhookName(m.From)
+		req.AvatarURL = m.From.PhotoURL
+	}
+	if ch.nativeReply(&m) {
+		req.MessageReference = &messageReference{
+			MessageID: string(m.ReplyTo.ID),
+			ChannelID: ch.id,
+			// If the message was deleted, send the message without the reply.
+			FailIfNotExists: new(bool),
+		}
 	}
 	var ev event // Message type
-	if err := ch.cl.post(ctx, "channels/"+ch.id+"/messages", req, &ev); err != nil {
+	if len(m.Attachments) > 0 {
+		files, err := downloadAttachments(ctx, m.Attachments)
+		if err == nil {
+			withFiles := m
+			withFiles.Text = uploadText(m)
+			req.Content = content(ch, &withFiles)
+			if err = ch.cl.postFiles(ctx, method, &req, files, &ev); err == nil {
+				m.ID = chat.MessageID(ev.ID)
+				return m, nil
+			}
+		}
+		log.Printf("Discord failed to upload attachments, sending links: %s\n", err)
+	}
+	req.Content = content(ch, &m)
+	req.Attachments = nil
+	if err := ch.cl.post(ctx, method, req, &ev); err != nil {
 		return chat.Message{}, err
 	}
 	m.ID = chat.MessageID(ev.ID)
@@ -81,6 +131,9 @@ func (ch *Channel) Send(ctx context.Context, m chat.Message) (chat.Message, erro
 }
 
 func (ch *Channel) Delete(ctx context.Context, m chat.Message) error {
+	if ch.viaWebhook(m.From) {
+		return deleteWebhook(ctx, ch, m)
+	}
 	ch.cl.mu.Lock()
 	ch.cl.deletes[string(m.ID)] = true
 	ch.cl.mu.Unlock()
@@ -92,10 +145,13 @@ func (ch *Channel) Delete(ctx context.Context, m chat.Message) error {
 }
 
 func (ch *Channel) Edit(ctx context.Context, m chat.Message) (chat.Message, error) {
+	if ch.viaWebhook(m.From) {
+		return editWebhook(ctx, ch, m)
+	}
 	req := struct {
 		Content string `json:"content"`
 	}{
-		Content: content(ch, &m),
+		Content: editContent(ch, m),
 	}
 	var ev event // Message type
 	err := ch.cl.patch(ctx, "channels/"+ch.id+"/messages/"+string(m.ID), req, &ev)
@@ -111,9 +167,13 @@ func (ch *Channel) Edit(ctx context.Context, m chat.Message) (chat.Message, erro
 
 func content(ch *Channel, m *chat.Message) string {
 	from, emFrom := from(ch, m.From), emFrom(ch, m.From)
+	if ch.viaWebhook(m.From) {
+		// The webhook message has the sender's name.
+		from, emFrom = "", ""
+	}
 
 	var replyTo string
-	if m.ReplyTo != nil {
+	if m.ReplyTo != nil && !ch.nativeReply(m) {
 		var who string
 		if m.ReplyTo.From == nil {
 			who = ch.cl.userName
@@ -138,6 +198,19 @@ func content(ch *Channel, m *chat.Message) string {
 	return replyTo + s.String()
 }
 
+// nativeReply returns whether a message is sent as a Discord reply
+// to the message it replies to.
+// Otherwise, the message quotes the message it replies to.
+func (ch *Channel) nativeReply(m *chat.Message) bool {
+	// Webhook messages cannot be replies.
+	return m.ReplyTo != nil && !ch.viaWebhook(m.From) && isSnowflake(string(m.ReplyTo.ID))
+}
+
+// isSnowflake returns whether s is a Discord ID.
+func isSnowflake(s string) bool {
+	return s != "" && isAllDigits(s)
+}
+
 func from(ch *Channel, u *chat.User) string {
 	if u == nil || u.Channel == ch && string(u.ID) == ch.cl.userID {
 		return ""
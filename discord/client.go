@@ -9,9 +9,8 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
-	"math"
+	"math/rand"
 	"net/http"
-	"net/url"
 	"path"
 	"runtime"
 	"strconv"
@@ -22,12 +21,12 @@ import (
 
 	"github.com/eaburns/pretty"
 	"github.com/velour/chat"
-	"github.com/velour/chat/websocket"
+	"github.com/velour/chat/cache"
 )
 
 const (
-	apiURL = "https://discordapp.com/"
-	cdnURL = "https://cdn.discordapp.com/"
+	defaultAPIURL = "https://discordapp.com/"
+	cdnURL        = "https://cdn.discordapp.com/"
 )
 
 const (
@@ -35,42 +34,70 @@ const (
 	OpHeartbeat      = 1
 	OpIdentify       = 2
 	OpResume         = 6
+	OpReconnect      = 7
 	OpInvalidSession = 9
 	OpHello          = 10
 	OpHeartbeatACK   = 11
 )
 
+var _ chat.Client = &Client{}
+
 type Client struct {
 	token    string
+	apiURL   string
+	appID    string
 	userID   string
 	userName string
+	opts     Options
+
+	// gatewayURL is the URL of the gateway, from gateway/bot.
+	gatewayURL string
 
 	cancelBackground context.CancelFunc
-	backgroundDone   chan error
-	rpcReq           chan rpc
+	backgroundDone   chan struct{}
+	limiter          *limiter
 
 	mu           sync.Mutex
 	joined       map[string]*Channel
 	deletes      map[string]bool
 	userNames    map[string]string // user names by ID.
 	rewriteNames map[string]string
+	webhooks     bool
+	threads      map[string]string // parent channel IDs by thread ID.
+	nicks        map[string]string // guild nicknames by <guild ID>/<user ID>.
+	guildsByID   map[string]*guild // guilds of the bot, from the gateway.
+
+	// guilds caches map[string]string of role or channel names by ID,
+	// keyed by roles/<guild ID> or channels/<guild ID>.
+	guilds *cache.Cache
 }
 
+// Dial returns a new Client using the given bot token,
+// and the default Options.
 func Dial(ctx context.Context, token string) (*Client, error) {
+	return DialOptions(ctx, token, Options{})
+}
+
+// DialOptions returns a new Client using the given bot token and Options.
+func DialOptions(ctx context.Context, token string, opts Options) (*Client, error) {
 	background, cancel := context.WithCancel(ctx)
 	cl := &Client{
 		token:            token,
+		apiURL:           defaultAPIURL,
+		opts:             opts.withDefaults(),
 		cancelBackground: cancel,
-		backgroundDone:   make(chan error),
-		rpcReq:           make(chan rpc),
+		backgroundDone:   make(chan struct{}),
+		limiter:          newLimiter(http.DefaultClient),
 		joined:           make(map[string]*Channel),
 		deletes:          make(map[string]bool),
 		userNames:        make(map[string]string),
 		rewriteNames:     make(map[string]string),
+		threads:          make(map[string]string),
+		nicks:            make(map[string]string),
+		guildsByID:       make(map[string]*guild),
+		guilds:           cache.New(2*maxGuilds, guildTTL),
 	}
 
-	go limitRPCs(background, cl.rpcReq)
-
 	var user struct {
 		ID       string `json:"id"`
 		Username string `json:"username"`
@@ -81,57 +108,112 @@ func Dial(ctx context.Context, token string) (*Client, error) {
 	cl.userID = user.ID
 	cl.userName = user.Username
 
+	var gw gatewayBot
+	if err := cl.get(ctx, "gateway/bot", &gw); err != nil {
+		return nil, err
+	}
+	cl.gatewayURL = gw.URL
+
 	ready := make(chan error)
-	go runWithRetry(background, cl, ready)
+	go runShards(background, cl, shardCount(cl.opts, gw), gw.SessionStartLimit.MaxConcurrency, ready)
 	if err, ok := <-ready; ok {
 		return nil, err
 	}
 	return cl, nil
 }
 
+// Close closes the gateway connections and the joined Channels.
+// If the Context is done before the connections are closed,
+// Close returns the Context error,
+// and the Channels are closed when the connections are closed.
 func (cl *Client) Close(ctx context.Context) error {
 	cl.cancelBackground()
-	err, _ := <-cl.backgroundDone
-	if err == context.DeadlineExceeded || err == context.Canceled {
-		err = nil
+	stopAll := func() {
+		cl.mu.Lock()
+		defer cl.mu.Unlock()
+		for _, ch := range cl.joined {
+			stop(ch) // but we don't wait for it.
+		}
 	}
-	for _, ch := range cl.joined {
-		stop(ch) // but we don't wait for it.
+	select {
+	case <-cl.backgroundDone:
+		stopAll()
+		return nil
+	case <-ctx.Done():
+		// Events may still be sent to the Channels until the background is done.
+		go func() {
+			<-cl.backgroundDone
+			stopAll()
+		}()
+		return ctx.Err()
 	}
-	return err
 }
 
-func (cl *Client) Join(ctx context.Context, guildName, chName string) (chat.Channel, error) {
-	var guilds []idAndName
-	if err := cl.get(ctx, "/users/@me/guilds", &guilds); err != nil {
-		return nil, err
-	}
-	guildID := nameID(guilds, guildName)
-	if guildID == "" {
-		return nil, errors.New("guild " + guildName + " not found")
-	}
-
-	var channels []idAndName
-	if err := cl.get(ctx, "guilds/"+guildID+"/channels", &channels); err != nil {
+// Join returns a Channel for a guild channel, a thread, or a DM channel.
+// The channel string is either a channel or thread ID,
+// a user ID for the DM channel with the user,
+// or a path of guild, channel, and thread names
+// of the form <guild>/<channel> or <guild>/<channel>/<thread>.
+// The form <guild>:<channel> of earlier versions is also accepted.
+// If more than one guild or channel has a name in the path,
+// an error is returned, and the channel must be joined by ID.
+//
+// Messages in threads that are not joined are received by the Channel of their parent.
+func (cl *Client) Join(ctx context.Context, channel string) (chat.Channel, error) {
+	c, guildName, err := resolve(ctx, cl, channel)
+	if err != nil {
 		return nil, err
 	}
-	chID := nameID(channels, chName)
-	if chID == "" {
-		return nil, errors.New("channel " + chName + " not found")
+	if ch, ok := getChannel(cl, c.ID); ok {
+		return ch, nil
 	}
 
 	ch := &Channel{
 		cl:        cl,
-		id:        chID,
-		name:      chName,
-		guildID:   guildID,
+		id:        c.ID,
+		name:      c.Name,
+		guildID:   c.GuildID,
 		guildName: guildName,
 	}
-	start(ch)
-
+	switch {
+	case c.Type == dmChannelType:
+		if len(c.Recipients) > 0 {
+			ch.name = "@" + c.Recipients[0].Username
+		}
+	case isThreadType(c.Type) && c.ParentID != "":
+		// Join the thread to receive its messages.
+		if err := cl.put(ctx, "channels/"+c.ID+"/thread-members/@me"); err != nil {
+			return nil, err
+		}
+		addThreads(cl, []thread{{ID: c.ID, ParentID: c.ParentID}})
+		ch.parentID = c.ParentID
+		if parent, _, ok := lookupID(cl, c.ParentID); ok {
+			ch.name = parent.Name + "/" + c.Name
+		}
+	}
 	cl.mu.Lock()
-	cl.joined[chID] = ch
+	webhooks := cl.webhooks
 	cl.mu.Unlock()
+	// DM channels cannot have webhooks.
+	if webhooks && ch.guildID != "" {
+		// Threads use the webhook of their parent channel.
+		webhookChannel := ch.id
+		if ch.parentID != "" {
+			webhookChannel = ch.parentID
+		}
+		if ch.webhook, err = channelWebhook(ctx, cl, webhookChannel); err != nil {
+			return nil, err
+		}
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if joined, ok := cl.joined[ch.id]; ok {
+		// Joined concurrently.
+		return joined, nil
+	}
+	start(ch)
+	cl.joined[ch.id] = ch
 	return ch, nil
 }
 
@@ -149,15 +231,6 @@ type idAndName struct {
 	Name string `json:"name"`
 }
 
-func nameID(nids []idAndName, name string) string {
-	for _, x := range nids {
-		if x.Name == name {
-			return x.ID
-		}
-	}
-	return ""
-}
-
 type msg struct {
 	Op int         `json:"op"`
 	T  string      `json:"t"`
@@ -171,22 +244,85 @@ type user struct {
 	Avatar   string `json:"avatar"`
 }
 
-type pingPong struct {
-	Op int `json:"op"`
-	D  int `json:"d"`
-}
-
 type event struct {
 	ID        string `json:"id"`
 	ChannelID string `json:"channel_id"`
 
+	GuildID string `json:"guild_id"`
+
 	// For Message type.
-	Author  *user  `json:"author"`
-	Content string `json:"content"`
+	Type      int     `json:"type"`
+	Author    *user   `json:"author"`
+	Member    *member `json:"member"`
+	Content   string  `json:"content"`
+	WebhookID string  `json:"webhook_id"`
+
+	Attachments []attachment `json:"attachments"`
+	Embeds      []embed      `json:"embeds"`
+
+	// For replies, MessageReference identifies the replied-to message,
+	// and ReferencedMessage is the replied-to message,
+	// or nil if it was deleted.
+	MessageReference  *messageReference `json:"message_reference"`
+	ReferencedMessage *event            `json:"referenced_message"`
 
 	// MESSAGE_DELETE_BULK:
 	// Sets ChannelID and sets IDs instead of ID.
 	IDs []string `json:"ids"`
+
+	// GUILD_MEMBER_ADD, GUILD_MEMBER_REMOVE, GUILD_MEMBER_UPDATE:
+	// Sets GuildID, User, and Nick.
+	User *user   `json:"user"`
+	Nick *string `json:"nick"`
+
+	// THREAD_CREATE, THREAD_UPDATE, THREAD_DELETE:
+	// Sets ID, GuildID, and ParentID.
+	ParentID string `json:"parent_id"`
+
+	// GUILD_CREATE, THREAD_LIST_SYNC:
+	// Sets GuildID, and Threads are the active threads.
+	Threads []thread `json:"threads"`
+
+	// GUILD_CREATE:
+	// Sets ID to the guild ID, and sets Name, Roles, and Channels.
+	// GUILD_UPDATE, GUILD_DELETE:
+	// Sets ID to the guild ID, GUILD_UPDATE sets Name,
+	// and GUILD_DELETE sets Unavailable if the guild is in an outage.
+	// CHANNEL_CREATE, CHANNEL_UPDATE, CHANNEL_DELETE:
+	// Sets ID, GuildID, Name, ParentID, and sets Type to the channel type.
+	Name        string        `json:"name"`
+	Unavailable bool          `json:"unavailable"`
+	Roles       []idAndName   `json:"roles"`
+	Channels    []channelInfo `json:"channels"`
+
+	// INTERACTION_CREATE:
+	// Sets ID, ChannelID, GuildID, Member or User,
+	// and sets Type to the interaction type.
+	ApplicationID string           `json:"application_id"`
+	Token         string           `json:"token"`
+	Data          *interactionData `json:"data"`
+}
+
+// A member is a guild member.
+type member struct {
+	Nick *string `json:"nick"`
+	// User is set for interactions, but not for messages.
+	User *user `json:"user"`
+}
+
+const (
+	// threadCreatedMessageType is the message type
+	// of the message announcing a new thread.
+	threadCreatedMessageType = 18
+	// replyMessageType is the message type of replies.
+	replyMessageType = 19
+)
+
+type messageReference struct {
+	MessageID       string `json:"message_id,omitempty"`
+	ChannelID       string `json:"channel_id,omitempty"`
+	GuildID         string `json:"guild_id,omitempty"`
+	FailIfNotExists *bool  `json:"fail_if_not_exists,omitempty"`
 }
 
 func dispatchEvent(ctx context.Context, cl *Client, t string, data interface{}) error {
@@ -202,13 +338,71 @@ func dispatchEvent(ctx context.Context, cl *Client, t string, data interface{})
 		log.Println("Discord failed to unmarshal", pretty.String(data))
 		return err
 	}
-	ch, ok := getChannel(cl, ev.ChannelID)
+	switch t {
+	case "GUILD_MEMBER_ADD", "GUILD_MEMBER_REMOVE", "GUILD_MEMBER_UPDATE":
+		memberEvent(cl, t, &ev)
+		return nil
+	case "INTERACTION_CREATE":
+		interactionEvent(cl, &ev)
+		return nil
+	case "GUILD_CREATE":
+		putGuild(cl, &guild{ID: ev.ID, Name: ev.Name, Channels: ev.Channels})
+		addThreads(cl, ev.Threads)
+		cl.guilds.Put("roles/"+ev.ID, namesByID(ev.Roles))
+		cl.guilds.Put("channels/"+ev.ID, channelNames(ev.Channels))
+		return nil
+	case "GUILD_UPDATE":
+		renameGuild(cl, ev.ID, ev.Name)
+		return nil
+	case "GUILD_DELETE":
+		if ev.Unavailable {
+			markUnavailable(cl, ev.ID)
+		} else {
+			removeGuild(cl, ev.ID)
+		}
+		return nil
+	case "THREAD_LIST_SYNC":
+		addThreads(cl, ev.Threads)
+		return nil
+	case "GUILD_ROLE_CREATE", "GUILD_ROLE_UPDATE", "GUILD_ROLE_DELETE":
+		cl.guilds.Remove("roles/" + ev.GuildID)
+		return nil
+	case "CHANNEL_CREATE", "CHANNEL_UPDATE":
+		putChannel(cl, channelInfo{
+			ID:       ev.ID,
+			GuildID:  ev.GuildID,
+			Name:     ev.Name,
+			Type:     ev.Type,
+			ParentID: ev.ParentID,
+		})
+		cl.guilds.Remove("channels/" + ev.GuildID)
+		return nil
+	case "CHANNEL_DELETE":
+		removeChannel(cl, ev.GuildID, ev.ID)
+		cl.guilds.Remove("channels/" + ev.GuildID)
+		return nil
+	case "THREAD_CREATE", "THREAD_UPDATE":
+		addThreads(cl, []thread{{ID: ev.ID, ParentID: ev.ParentID}})
+		return nil
+	case "THREAD_DELETE":
+		removeThread(cl, ev.ID)
+		return nil
+	}
+
+	ch, ok := messageChannel(cl, ev.ChannelID)
 	if !ok || ev.Author != nil && ev.Author.ID == cl.userID {
 		return nil
 	}
+	if ch.webhook != nil && ev.WebhookID == ch.webhook.ID {
+		// Ignore messages sent by the Channel through its webhook.
+		return nil
+	}
 	if ev.Author != nil {
 		cl.mu.Lock()
 		cl.userNames[ev.Author.ID] = ev.Author.Username
+		if ev.Member != nil {
+			cl.nicks[ch.guildID+"/"+ev.Author.ID] = nickString(ev.Member.Nick)
+		}
 		cl.mu.Unlock()
 	}
 	switch t {
@@ -226,18 +420,82 @@ func dispatchEvent(ctx context.Context, cl *Client, t string, data interface{})
 		}
 	case "MESSAGE_DELETE_BULK":
 		for _, id := range ev.IDs {
-			if sendDelete(cl, ev.ID) {
+			if sendDelete(cl, id) {
 				send(ch, chat.Delete{ID: chat.MessageID(id), Channel: ch})
 			}
 		}
-		/*
-			case "GUILD_MEMBER_ADD":
-			case "GUILD_MEMBER_REMOVE":
-		*/
 	}
 	return nil
 }
 
+// memberEvent sends Join, Leave, and Rename events
+// for a guild member event to the joined channels of the guild.
+// Threads do not receive member events.
+func memberEvent(cl *Client, t string, ev *event) {
+	if ev.User == nil || ev.User.ID == cl.userID {
+		return
+	}
+	key := ev.GuildID + "/" + ev.User.ID
+	cl.mu.Lock()
+	var channels []*Channel
+	for _, ch := range cl.joined {
+		if ch.guildID == ev.GuildID && ch.parentID == "" {
+			channels = append(channels, ch)
+		}
+	}
+	oldNick, known := cl.nicks[key]
+	if t != "GUILD_MEMBER_REMOVE" {
+		cl.nicks[key] = nickString(ev.Nick)
+	}
+	cl.mu.Unlock()
+	if t == "GUILD_MEMBER_REMOVE" {
+		// Forget the nickname after the Leave events, which use it.
+		defer func() {
+			cl.mu.Lock()
+			delete(cl.nicks, key)
+			cl.mu.Unlock()
+		}()
+	}
+
+	for _, ch := range channels {
+		u := authorUser(ch, ev.User)
+		switch t {
+		case "GUILD_MEMBER_ADD":
+			send(ch, chat.Join{Who: *u})
+		case "GUILD_MEMBER_REMOVE":
+			send(ch, chat.Leave{Who: *u})
+		case "GUILD_MEMBER_UPDATE":
+			// Member updates are also sent for changes other than nicknames,
+			// and renames are unknown if the previous nickname is unknown.
+			if !known || oldNick == nickString(ev.Nick) {
+				continue
+			}
+			from := *u
+			from.DisplayName = displayName(cl, ev.User.Username, oldNick)
+			send(ch, chat.Rename{From: from, To: *u})
+		}
+	}
+}
+
+func nickString(nick *string) string {
+	if nick == nil {
+		return ""
+	}
+	return *nick
+}
+
+// displayName returns the display name for a user with a guild nickname.
+// The caller must hold cl.mu.
+func displayName(cl *Client, username, nick string) string {
+	if name := cl.rewriteNames[username]; name != "" {
+		return name
+	}
+	if nick != "" {
+		return nick
+	}
+	return username
+}
+
 func sendDelete(cl *Client, id string) bool {
 	cl.mu.Lock()
 	defer cl.mu.Unlock()
@@ -267,35 +525,23 @@ func eventMessage(ctx context.Context, ch *Channel, ev *event) chat.Message {
 	var m chat.Message
 	m.ID = chat.MessageID(ev.ID)
 	m.From = authorUser(ch, ev.Author)
-	m.Text = decodeMentions(ctx, ch.cl, ev.Content)
-	return m
-}
-
-func decodeMentions(ctx context.Context, cl *Client, text string) string {
-	const open, close = "<@", ">"
-	var s strings.Builder
-	for {
-		i := strings.Index(text, open)
-		if i < 0 {
-			break
-		}
-		j := strings.Index(text, close)
-		if j <= i+len(open) {
-			// There is no ending, so just return whatever we have.
-			break
-		}
-		id := text[i+len(open) : j]
-		name, err := userName(ctx, cl, id)
-		if err != nil {
-			log.Printf("failed to decode @-mention for %s: %s", id, err)
-			break
-		}
-		s.WriteString(text[:i])
-		s.WriteRune('@')
-		s.WriteString(name)
-		text = text[j+1:]
+	m.Text = decodeMarkup(ctx, ch, ev.Content)
+	if ev.Type == threadCreatedMessageType {
+		m.Text = "/me started a thread: " + m.Text
 	}
-	return s.String() + text
+	m.Text, m.Attachments = attachmentText(m.Text, ev)
+	switch {
+	case ev.Type != replyMessageType:
+	case ev.ReferencedMessage != nil:
+		// Only decode a single level of replies.
+		ref := *ev.ReferencedMessage
+		ref.Type, ref.ReferencedMessage = 0, nil
+		replyTo := eventMessage(ctx, ch, &ref)
+		m.ReplyTo = &replyTo
+	case ev.MessageReference != nil:
+		m.ReplyTo = &chat.Message{ID: chat.MessageID(ev.MessageReference.MessageID)}
+	}
+	return m
 }
 
 func authorUser(ch *Channel, au *user) *chat.User {
@@ -307,13 +553,9 @@ func authorUser(ch *Channel, au *user) *chat.User {
 	u.Nick = au.Username
 	u.FullName = au.Username
 
-	u.DisplayName = ch.cl.rewriteNames[au.Username]
-	if u.DisplayName == "" {
-		u.DisplayName = au.Username
-	}
-
 	u.Channel = ch
 	ch.cl.mu.Lock()
+	u.DisplayName = displayName(ch.cl, au.Username, ch.cl.nicks[ch.guildID+"/"+au.ID])
 	u.PhotoURL = cdnURL + path.Join("avatars", au.ID, au.Avatar+".png")
 	ch.cl.mu.Unlock()
 	return &u
@@ -338,54 +580,127 @@ func userName(ctx context.Context, cl *Client, userID string) (string, error) {
 	return u.Username, nil
 }
 
-func runWithRetry(ctx context.Context, cl *Client, ready chan<- error) {
-	defer close(cl.backgroundDone)
+var (
+	// minBackoff and maxBackoff bound the delay
+	// between consecutive failed gateway connections.
+	minBackoff = time.Second
+	maxBackoff = 2 * time.Minute
+)
 
-	conn, err := dial(ctx, cl)
-	if err != nil {
-		ready <- err
-		return
-	}
-	s, err := newSession(ctx, conn, cl.token)
-	if err != nil {
-		conn.Close(ctx)
-		ready <- err
-		return
-	}
-	close(ready)
+var (
+	// errReconnect is returned by run if the gateway requests a reconnect,
+	// and the session can be resumed.
+	errReconnect = errors.New("reconnect requested")
+	// errInvalidSession is returned if the session cannot be resumed,
+	// and a new session must be identified.
+	errInvalidSession = errors.New("invalid session")
+)
 
+// runWithRetry runs sessions of the shard until the Context is done.
+// When the first session is ready, ready is closed;
+// if it cannot be started, or the Context is done first,
+// the error is sent on ready.
+//
+// Disconnected sessions are resumed on a new connection,
+// unless the gateway invalidates the session,
+// in which case a new session is identified.
+// Consecutive failures to connect are retried with exponential backoff.
+func runWithRetry(ctx context.Context, cl *Client, shard [2]int, ready chan<- error) {
+	s := session{shard: shard}
+	started := false
+	var fails int
 	for {
-		runErr := run(ctx, cl, conn, &s)
-		conn.Close(ctx)
-		select {
-		case <-ctx.Done():
+		conn, err := connect(ctx, cl, &s)
+		if err == nil {
+			if !started {
+				started = true
+				close(ready)
+			}
+			err = run(ctx, cl, conn, &s)
+			conn.close(ctx)
+		}
+		switch {
+		case !started:
+			// Always report, even if the Context is done,
+			// so that the caller does not wait forever.
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			ready <- err
+			return
+		case ctx.Err() != nil:
 			return
-		default:
 		}
 
-		log.Println("Discord disconnected")
-		time.Sleep(5 * time.Second)
-
-		if runErr == errInvalidSession {
-			log.Println("Discord getting a new session.")
-			s, err = newSession(ctx, conn, cl.token)
-		} else {
-			log.Println("Discord reconnecting")
-			conn, err = dial(ctx, cl)
-			if err == nil {
-				log.Println("Discord resuming.")
-				s, err = resumeSession(ctx, conn, s)
-			}
+		if s.connected {
+			fails = 0
 		}
-		if err != nil {
-			cl.backgroundDone <- runErr
+		delay := backoff(fails)
+		fails++
+		if err == errInvalidSession {
+			s = session{shard: shard}
+			// Discord recommends waiting 1 to 5 seconds before identifying.
+			delay += minBackoff + time.Duration(rand.Int63n(int64(4*minBackoff)))
+		}
+		log.Printf("Discord shard %d disconnected: %s; reconnecting in %s\n", shard[0], err, delay)
+		if err := sleepUntil(ctx, time.Now().Add(delay)); err != nil {
 			return
 		}
-		log.Println("Discord reconnected")
 	}
 }
 
-func run(ctx context.Context, cl *Client, conn *websocket.Conn, s *session) error {
+// backoff returns the delay before reconnecting
+// after the given number of consecutive failures.
+// The first reconnect is immediate.
+func backoff(fails int) time.Duration {
+	if fails == 0 {
+		return 0
+	}
+	d := maxBackoff
+	if fails < 32 && minBackoff<<uint(fails-1) < maxBackoff {
+		d = minBackoff << uint(fails-1)
+	}
+	// Add jitter so that shards and clients do not reconnect in lockstep.
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// connect dials the gateway, and resumes the session,
+// or if it has no ID, identifies a new session.
+// The session is not connected until it is identified or resumed
+// on the new connection.
+func connect(ctx context.Context, cl *Client, s *session) (*gateway, error) {
+	s.connected = false
+	gatewayURL := cl.gatewayURL
+	if s.id != "" && s.ready.ResumeGatewayURL != "" {
+		gatewayURL = s.ready.ResumeGatewayURL
+	}
+	conn, err := dialGateway(ctx, gatewayURL, cl.token, cl.opts)
+	if err != nil {
+		return nil, err
+	}
+	if s.id == "" {
+		ns, err := newSession(ctx, conn, cl.token, cl.opts.Intents, s.shard)
+		if err != nil {
+			return nil, err
+		}
+		*s = ns
+		recordReady(cl, s.ready)
+		return conn, nil
+	}
+	if err := resumeSession(ctx, conn, s); err != nil {
+		conn.close(ctx)
+		return nil, err
+	}
+	return conn, nil
+}
+
+type heartbeat struct {
+	Op int `json:"op"`
+	// D is the last sequence number, or nil if none was received.
+	D *int `json:"d"`
+}
+
+func run(ctx context.Context, cl *Client, conn *gateway, s *session) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -394,97 +709,111 @@ func run(ctx context.Context, cl *Client, conn *websocket.Conn, s *session) erro
 	go func() {
 		for {
 			var m msg
-			if err := conn.Recv(ctx, &m); err != nil {
-				log.Println("Discord receive error:", err)
+			if err := conn.recv(ctx, &m); err != nil {
 				errs <- err
 				return
 			}
-			msgs <- m
+			select {
+			case msgs <- m:
+			case <-ctx.Done():
+				return
+			}
 		}
 	}()
 
-	ping := pingPong{Op: OpHeartbeat}
-	pong := pingPong{Op: OpHeartbeatACK}
+	sendHeartbeat := func() error {
+		hb := heartbeat{Op: OpHeartbeat}
+		if s.seq >= 0 {
+			seq := s.seq
+			hb.D = &seq
+		}
+		return conn.send(ctx, hb)
+	}
 	tick := time.NewTicker(s.pingTime)
 	defer tick.Stop()
-	ponged := true
+	acked := true
 	for {
 		select {
 		case err := <-errs:
 			return err
 		case <-tick.C:
-			if !ponged {
-				return errors.New("pong timeout")
+			if !acked {
+				// The connection is dead; resume on a new connection.
+				return errors.New("heartbeat ACK timeout")
 			}
-			ping.D = s.seq
-			if err := conn.Send(ctx, ping); err != nil {
+			if err := sendHeartbeat(); err != nil {
 				return err
 			}
-			ponged = false
+			acked = false
 		case m := <-msgs:
-			switch {
-			case m.Op == OpHeartbeatACK:
-				ponged = true
-			case m.Op == OpHeartbeat:
-				if err := conn.Send(ctx, pong); err != nil {
+			switch m.Op {
+			case OpHeartbeatACK:
+				acked = true
+			case OpHeartbeat:
+				// The gateway requests an immediate heartbeat.
+				if err := sendHeartbeat(); err != nil {
 					return err
 				}
-			case m.Op == OpInvalidSession:
+			case OpReconnect:
+				return errReconnect
+			case OpInvalidSession:
+				if resumable, _ := m.D.(bool); resumable {
+					return errReconnect
+				}
 				return errInvalidSession
-			case m.Op == OpDispatch:
+			case OpDispatch:
 				s.seq = m.S
+				if m.T == "RESUMED" {
+					s.connected = true
+				}
 				dispatchEvent(ctx, cl, m.T, m.D)
 			}
 		}
 	}
 }
 
-func dial(ctx context.Context, cl *Client) (*websocket.Conn, error) {
-	var gateway struct {
-		URL string
-	}
-	if err := cl.get(ctx, "gateway/bot", &gateway); err != nil {
-		return nil, err
-	}
-	url, err := url.Parse(gateway.URL)
-	if err != nil {
-		return nil, err
-	}
-	header := make(http.Header)
-	header.Set("Authorization", "Bot "+cl.token)
-	return websocket.DialHeader(ctx, header, url)
-}
-
 type session struct {
 	id       string
 	seq      int
 	pingTime time.Duration
 	token    string
+	intents  Intent
+	// shard is the shard ID and the number of shards.
+	shard [2]int
+	// ready is the READY event of the session.
+	ready readyData
+	// connected is whether the session was identified or resumed
+	// on the current connection.
+	connected bool
 }
 
-var errInvalidSession = errors.New("invalid session")
-
-func newSession(ctx context.Context, conn *websocket.Conn, token string) (s session, err error) {
+func newSession(ctx context.Context, conn *gateway, token string, intents Intent, shard [2]int) (s session, err error) {
 	defer func() {
 		if err != nil {
-			conn.Close(ctx)
+			conn.close(ctx)
 		}
 	}()
 	if s.pingTime, err = expectHello(ctx, conn); err != nil {
 		return session{}, err
 	}
-	if err = identify(ctx, conn, token); err != nil {
+	if err = identify(ctx, conn, token, intents, shard); err != nil {
 		return session{}, err
 	}
-	if s.id, err = expectReady(ctx, conn); err != nil {
+	if s.ready, s.seq, err = expectReady(ctx, conn); err != nil {
 		return session{}, err
 	}
-	s.seq = -1
+	s.id = s.ready.SessionID
 	s.token = token
+	s.intents = intents
+	s.shard = shard
+	s.connected = true
 	return s, nil
 }
 
-func resumeSession(ctx context.Context, conn *websocket.Conn, s session) (session, error) {
+// resumeSession resumes the session on a new connection.
+// The session is connected when the RESUMED event is received;
+// if it cannot be resumed, the gateway sends an invalid session.
+func resumeSession(ctx context.Context, conn *gateway, s *session) error {
 	type resume struct {
 		Token     string `json:"token"`
 		SessionID string `json:"session_id"`
@@ -501,24 +830,25 @@ func resumeSession(ctx context.Context, conn *websocket.Conn, s session) (sessio
 			Seq:       s.seq,
 		},
 	}
-	var err error
-	if s.pingTime, err = expectHello(ctx, conn); err != nil {
-		return session{}, err
+	pingTime, err := expectHello(ctx, conn)
+	if err != nil {
+		return err
 	}
-	if err = conn.Send(ctx, msg); err != nil {
-		return session{}, err
+	if err = conn.send(ctx, msg); err != nil {
+		return err
 	}
-	return s, nil
+	s.pingTime = pingTime
+	return nil
 }
 
-func expectHello(ctx context.Context, conn *websocket.Conn) (time.Duration, error) {
+func expectHello(ctx context.Context, conn *gateway) (time.Duration, error) {
 	var hello struct {
 		Op int
 		D  struct {
 			Interval int32 `json:"heartbeat_interval"`
 		}
 	}
-	if err := conn.Recv(ctx, &hello); err != nil {
+	if err := conn.recv(ctx, &hello); err != nil {
 		return 0, err
 	}
 	hi := time.Duration(hello.D.Interval) * time.Millisecond
@@ -535,15 +865,19 @@ func expectHello(ctx context.Context, conn *websocket.Conn) (time.Duration, erro
 	return hi - time.Second, nil
 }
 
-func identify(ctx context.Context, conn *websocket.Conn, token string) error {
+func identify(ctx context.Context, conn *gateway, token string, intents Intent, shard [2]int) error {
 	type props struct {
-		OS      string `json:"$os"`
-		Browser string `json:"$browser"`
-		Device  string `json:"$device"`
+		OS      string `json:"os"`
+		Browser string `json:"browser"`
+		Device  string `json:"device"`
 	}
 	type ident struct {
 		Token      string `json:"token"`
 		Properties props  `json:"properties"`
+		Intents    Intent `json:"intents"`
+		Shard      [2]int `json:"shard"`
+		// Compression of individual payloads is not supported.
+		Compress bool `json:"compress"`
 	}
 	type msg struct {
 		Op int   `json:"op"`
@@ -552,7 +886,9 @@ func identify(ctx context.Context, conn *websocket.Conn, token string) error {
 	m := &msg{
 		Op: OpIdentify,
 		D: ident{
-			Token: token,
+			Token:   token,
+			Intents: intents,
+			Shard:   shard,
 			Properties: props{
 				OS:      runtime.GOOS,
 				Browser: "github.com/velour/chat",
@@ -560,32 +896,46 @@ func identify(ctx context.Context, conn *websocket.Conn, token string) error {
 			},
 		},
 	}
-	return conn.Send(ctx, m)
+	return conn.send(ctx, m)
 }
 
-func expectReady(ctx context.Context, conn *websocket.Conn) (string, error) {
+// readyData is the data of the READY event.
+type readyData struct {
+	// unused fields elided
+	SessionID        string `json:"session_id"`
+	ResumeGatewayURL string `json:"resume_gateway_url"`
+	Application      struct {
+		ID string `json:"id"`
+	} `json:"application"`
+	// Guilds are the guilds of the shard,
+	// which are received in GUILD_CREATE events after READY.
+	Guilds []struct {
+		ID string `json:"id"`
+	} `json:"guilds"`
+}
+
+// expectReady returns the data and the sequence number of the READY event.
+func expectReady(ctx context.Context, conn *gateway) (readyData, int, error) {
 	var ready struct {
 		Op int
 		T  string
-		D  struct {
-			// unused fields elided
-			SessionID       string                   `json:"session_id"`
-			PrivateChannels []map[string]interface{} `json:"private_channels"`
-		}
+		S  int
+		D  readyData
 	}
-	if err := conn.Recv(ctx, &ready); err != nil {
-		return "", err
-	}
-	if ready.Op != OpDispatch {
-		return "", errors.New("expected a ready, got non-event: " + strconv.Itoa(ready.Op))
-	}
-	if ready.T != "READY" {
-		return "", errors.New("expected a ready, got: " + ready.T)
-	}
-	if ready.D.SessionID == "" {
-		return "", errors.New("invalid, empty session ID")
+	if err := conn.recv(ctx, &ready); err != nil {
+		return readyData{}, 0, err
 	}
-	return ready.D.SessionID, nil
+	switch {
+	case ready.Op == OpInvalidSession:
+		return readyData{}, 0, errInvalidSession
+	case ready.Op != OpDispatch:
+		return readyData{}, 0, errors.New("expected a ready, got non-event: " + strconv.Itoa(ready.Op))
+	case ready.T != "READY":
+		return readyData{}, 0, errors.New("expected a ready, got: " + ready.T)
+	case ready.D.SessionID == "":
+		return readyData{}, 0, errors.New("invalid, empty session ID")
+	}
+	return ready.D, ready.S, nil
 }
 
 func (cl *Client) get(ctx context.Context, method string, resp interface{}) error {
@@ -600,6 +950,10 @@ func (cl *Client) patch(ctx context.Context, method string, req, resp interface{
 	return cl.rpc(ctx, http.MethodPatch, method, req, resp)
 }
 
+func (cl *Client) put(ctx context.Context, method string) error {
+	return cl.rpc(ctx, http.MethodPut, method, nil, nil)
+}
+
 func (cl *Client) del(ctx context.Context, method string) error {
 	return cl.rpc(ctx, http.MethodDelete, method, nil, nil)
 }
@@ -609,18 +963,20 @@ type httpErr int
 func (err httpErr) Error() string { return "HTTP error " + http.StatusText(int(err)) }
 
 func (cl *Client) rpc(ctx context.Context, httpMethod, apiMethod string, req, resp interface{}) error {
-	httpReq, err := newRequest(cl.token, httpMethod, apiMethod, req)
+	httpReq, err := cl.newRequest(httpMethod, apiMethod, req)
 	if err != nil {
 		return err
 	}
+	return cl.do(ctx, httpReq, resp)
+}
 
-	respChan := make(chan respOrError, 1)
-	cl.rpcReq <- rpc{req: httpReq.WithContext(ctx), resp: respChan}
-	respOrErr := <-respChan
-	if respOrErr.err != nil {
+// do sends the request, rate limited,
+// and decodes the JSON response into resp, if resp is non-nil.
+func (cl *Client) do(ctx context.Context, httpReq *http.Request, resp interface{}) error {
+	httpResp, err := cl.limiter.do(httpReq.WithContext(ctx))
+	if err != nil {
 		return err
 	}
-	httpResp := respOrErr.resp
 
 	defer httpResp.Body.Close()
 	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
@@ -636,7 +992,7 @@ func (cl *Client) rpc(ctx context.Context, httpMethod, apiMethod string, req, re
 	return json.Unmarshal(data, resp)
 }
 
-func newRequest(token, httpMethod, discordMethod string, req interface{}) (*http.Request, error) {
+func (cl *Client) newRequest(httpMethod, discordMethod string, req interface{}) (*http.Request, error) {
 	var body io.Reader
 	if req != nil {
 		b, err := json.Marshal(req)
@@ -645,124 +1001,22 @@ func newRequest(token, httpMethod, discordMethod string, req interface{}) (*http
 		}
 		body = bytes.NewReader(b)
 	}
-	httpReq, err := http.NewRequest(httpMethod, apiURL, body)
+	httpReq, err := http.NewRequest(httpMethod, cl.apiURL, body)
 	if err != nil {
 		return nil, err
 	}
-	httpReq.URL.Path = path.Join("/api", discordMethod)
-	httpReq.Header.Set("Authorization", "Bot "+token)
+	if i := strings.IndexByte(discordMethod, '?'); i >= 0 {
+		httpReq.URL.RawQuery = discordMethod[i+1:]
+		discordMethod = discordMethod[:i]
+	}
+	httpReq.URL.Path = path.Join("/api", "v"+strconv.Itoa(cl.opts.Version), discordMethod)
+	httpReq.Header.Set("Authorization", "Bot "+cl.token)
 	if req != nil {
 		httpReq.Header.Set("Content-Type", "application/json")
 	}
 	return httpReq, nil
 }
 
-type rpc struct {
-	req  *http.Request
-	resp chan<- respOrError
-}
-
-type respOrError struct {
-	resp *http.Response
-	err  error
-}
-
-func limitRPCs(ctx context.Context, rpcs <-chan rpc) {
-	var errRetries int
-	limits := make(map[string]time.Time)
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case rpc := <-rpcs:
-			k := limitKey(rpc.req)
-			if when := limits[k]; time.Now().Before(when) {
-				time.Sleep(time.Until(when))
-			}
-		retry:
-			r, err := http.DefaultClient.Do(rpc.req.WithContext(ctx))
-			if err == nil && r.StatusCode == http.StatusInternalServerError && errRetries < 3 {
-				ms := 500 * math.Pow(2, float64(errRetries))
-				time.Sleep(time.Millisecond * time.Duration(ms))
-				errRetries++
-				goto retry
-			}
-			errRetries = 0
-			if err == nil && r.StatusCode == http.StatusTooManyRequests {
-				retry, err := retryHeader(r.Header, "Retry-After")
-				if err == nil {
-					time.Sleep(time.Until(retry))
-					goto retry
-				}
-			}
-			rpc.resp <- respOrError{resp: r, err: err}
-			if err != nil {
-				break
-			}
-			if v := r.Header["X-Ratelimit-Remaining"]; len(v) > 0 {
-				n, err := strconv.Atoi(v[0])
-				if err != nil {
-					break
-				}
-				if n == 0 {
-					retry, err := retryHeader(r.Header, "X-Ratelimit-Reset")
-					if err != nil {
-						break
-					}
-					limits[k] = retry
-				}
-			}
-		}
-		// Limit to an avg of 2 per second, as recommended by the Discord docs.
-		time.Sleep(500 * time.Millisecond)
-	}
-}
-
-func retryHeader(h http.Header, k string) (time.Time, error) {
-	v, ok := h[k]
-	if !ok || len(v) == 0 {
-		return time.Time{}, errors.New("missing " + k + " header")
-	}
-	until, err := strconv.ParseInt(v[0], 10, 64)
-	if err != nil {
-		return time.Time{}, errors.New("malformed " + k + " value: " + v[0])
-	}
-	return time.Unix(until, 0), nil
-}
-
-// limitKey returns the key identifying the rate limit to use for this request.
-// Discord uses different rate limits for each path, not counting the filename
-// in the case that it is a parameter (all numbers).
-// Except channel_id, guild_id, and webhook_id don't count as parameters.
-// Finally, the http method doesn't affect the rate limit, except that
-// as a special case, message deletes have their own limit.
-func limitKey(req *http.Request) string {
-	switch r := splitAll(req.URL.Path); {
-	case len(r) == 0:
-		return req.URL.Path
-
-	case len(r) == 5 &&
-		r[1] == "channels" &&
-		r[3] == "messages" &&
-		req.Method == http.MethodDelete:
-		// Message deletes have their own rate limit.
-		return http.MethodDelete
-
-	case len(r) == 3 &&
-		(r[1] == "channels" || r[1] == "guilds" || r[1] == "webhooks"):
-		// channel_id, guild_id, and webhook_id count in the path,
-		// and should not be stripped.
-		return req.URL.Path
-
-	// all other number-only filenames do not count, just their directory does.
-	case isAllDigits(r[len(r)-1]):
-		return path.Dir(req.URL.Path)
-
-	default:
-		return req.URL.Path
-	}
-}
-
 func isAllDigits(s string) bool {
 	for _, r := range s {
 		if !unicode.IsDigit(r) {
@@ -771,20 +1025,3 @@ func isAllDigits(s string) bool {
 	}
 	return true
 }
-
-func splitAll(p string) []string {
-	var elms []string
-	for len(p) > 0 {
-		dir, elm := path.Dir(p), path.Base(p)
-		elms = append(elms, elm)
-		if dir == p {
-			break
-		}
-		p = dir
-	}
-	for i := 0; i < len(elms)/2; i++ {
-		j := len(elms) - i - 1
-		elms[i], elms[j] = elms[j], elms[i]
-	}
-	return elms
-}
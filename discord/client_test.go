@@ -1,41 +1,211 @@
 package discord
 
 import (
-	"net/http"
+	"context"
+	"encoding/json"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/velour/chat"
 )
 
-func TestLimitKey(t *testing.T) {
+func TestEventMessage(t *testing.T) {
+	ch := &Channel{cl: &Client{}}
+	alice := &chat.User{
+		ID:          "2",
+		Nick:        "alice",
+		FullName:    "alice",
+		DisplayName: "alice",
+		PhotoURL:    cdnURL + "avatars/2/a.png",
+		Channel:     ch,
+	}
 	tests := []struct {
-		method string
-		path   string
-		want   string
+		name  string
+		event string
+		want  chat.Message
 	}{
-		{"GET", "", ""},
-		{"DELETE", "", ""},
-		{"GET", "/", "/"},
-		{"DELETE", "/", "/"},
-		{"GET", "/channels", "/channels"},
-		{"DELETE", "/channels", "/channels"},
-		{"GET", "/channels/123", "/channels/123"},
-		{"GET", "/channels/123/messages", "/channels/123/messages"},
-		{"GET", "/channels/123/messages/456", "/channels/123/messages"},
-		{"GET", "/channels/123/messages/789", "/channels/123/messages"},
-		{"DELETE", "/channels/123/messages/789", "DELETE"},
-		{"DELETE", "/channels/123/messages/123", "DELETE"},
-		{"GET", "/some/123/other/456", "/some/123/other"},
-		{"GET", "/some/123/other/456/path", "/some/123/other/456/path"},
-		{"GET", "/some/123/other", "/some/123/other"},
-		{"GET", "/some/123", "/some"},
+		{
+			name:  "message",
+			event: `{"id":"10","type":0,"author":{"id":"2","username":"alice","avatar":"a"},"content":"hi"}`,
+			want:  chat.Message{ID: "10", From: alice, Text: "hi"},
+		},
+		{
+			name: "reply",
+			event: `{"id":"11","type":19,"author":{"id":"2","username":"alice","avatar":"a"},"content":"yes",
+				"message_reference":{"message_id":"10"},
+				"referenced_message":{"id":"10","type":19,"author":{"id":"2","username":"alice","avatar":"a"},"content":"hi",
+					"message_reference":{"message_id":"9"}}}`,
+			want: chat.Message{
+				ID:      "11",
+				From:    alice,
+				Text:    "yes",
+				ReplyTo: &chat.Message{ID: "10", From: alice, Text: "hi"},
+			},
+		},
+		{
+			name: "reply to deleted message",
+			event: `{"id":"11","type":19,"author":{"id":"2","username":"alice","avatar":"a"},"content":"yes",
+				"message_reference":{"message_id":"10"},"referenced_message":null}`,
+			want: chat.Message{ID: "11", From: alice, Text: "yes", ReplyTo: &chat.Message{ID: "10"}},
+		},
+		{
+			name: "attachments",
+			event: `{"id":"13","type":0,"author":{"id":"2","username":"alice","avatar":"a"},"content":"look",
+				"attachments":[
+					{"id":"1","filename":"cat.png","content_type":"image/png","size":5,"url":"https://cdn/cat.png"},
+					{"id":"2","filename":"notes.txt","size":3,"url":"https://cdn/notes.txt"}]}`,
+			want: chat.Message{
+				ID:   "13",
+				From: alice,
+				Text: "/me shared an image: https://cdn/cat.png\n/me shared a file: https://cdn/notes.txt\nlook",
+				Attachments: []chat.Attachment{
+					{URL: "https://cdn/cat.png", Name: "cat.png", MimeType: "image/png", Size: 5},
+					{URL: "https://cdn/notes.txt", Name: "notes.txt", Size: 3},
+				},
+			},
+		},
+		{
+			name: "embeds",
+			event: `{"id":"14","type":0,"author":{"id":"2","username":"alice","avatar":"a"},"content":"see https://example.com",
+				"embeds":[
+					{"type":"link","title":"Example","url":"https://example.com"},
+					{"type":"rich","title":"Status","description":"All good","image":{"url":"https://cdn/chart.png"}}]}`,
+			want: chat.Message{
+				ID:   "14",
+				From: alice,
+				Text: "see https://example.com\nStatus\nAll good\nhttps://cdn/chart.png",
+			},
+		},
+		{
+			name: "pin",
+			event: `{"id":"12","type":6,"author":{"id":"2","username":"alice","avatar":"a"},"content":"",
+				"message_reference":{"message_id":"10"}}`,
+			want: chat.Message{ID: "12", From: alice},
+		},
 	}
 	for _, test := range tests {
-		url := "http://test.com" + test.path
-		req, err := http.NewRequest(test.method, url, nil)
+		var ev event
+		if err := json.Unmarshal([]byte(test.event), &ev); err != nil {
+			t.Errorf("%s: failed to unmarshal event: %s", test.name, err)
+			continue
+		}
+		if got := eventMessage(context.Background(), ch, &ev); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: eventMessage(_, _, %s)=%#v, want %#v", test.name, test.event, got, test.want)
+		}
+	}
+}
+
+func TestDispatchEvent(t *testing.T) {
+	cl := &Client{
+		userID:       "1",
+		joined:       make(map[string]*Channel),
+		deletes:      make(map[string]bool),
+		userNames:    make(map[string]string),
+		rewriteNames: make(map[string]string),
+		threads:      make(map[string]string),
+		nicks:        make(map[string]string),
+	}
+	general := &Channel{cl: cl, id: "10", name: "general", guildID: "100"}
+	lunch := &Channel{cl: cl, id: "11", name: "general/lunch", guildID: "100", parentID: "10"}
+	for _, ch := range []*Channel{general, lunch} {
+		start(ch)
+		defer stop(ch)
+		cl.joined[ch.id] = ch
+	}
+	alice := func(ch *Channel, name string) chat.User {
+		return chat.User{
+			ID:          "2",
+			Nick:        "alice",
+			FullName:    "alice",
+			DisplayName: name,
+			PhotoURL:    cdnURL + "avatars/2/a.png",
+			Channel:     ch,
+		}
+	}
+
+	tests := []struct {
+		name  string
+		t     string
+		event string
+		ch    *Channel
+		want  chat.Event
+	}{
+		{
+			name:  "member add",
+			t:     "GUILD_MEMBER_ADD",
+			event: `{"guild_id":"100","user":{"id":"2","username":"alice","avatar":"a"},"nick":null}`,
+			ch:    general,
+			want:  chat.Join{Who: alice(general, "alice")},
+		},
+		{
+			name:  "nickname",
+			t:     "GUILD_MEMBER_UPDATE",
+			event: `{"guild_id":"100","user":{"id":"2","username":"alice","avatar":"a"},"nick":"Ali"}`,
+			ch:    general,
+			want:  chat.Rename{From: alice(general, "alice"), To: alice(general, "Ali")},
+		},
+		{
+			name:  "message with nickname",
+			t:     "MESSAGE_CREATE",
+			event: `{"id":"20","channel_id":"10","type":0,"author":{"id":"2","username":"alice","avatar":"a"},"member":{"nick":"Ali"},"content":"hi"}`,
+			ch:    general,
+			want:  chat.Message{ID: "20", From: &[]chat.User{alice(general, "Ali")}[0], Text: "hi"},
+		},
+		{
+			name:  "thread created",
+			t:     "MESSAGE_CREATE",
+			event: `{"id":"21","channel_id":"10","type":18,"author":{"id":"2","username":"alice","avatar":"a"},"content":"plans"}`,
+			ch:    general,
+			want:  chat.Message{ID: "21", From: &[]chat.User{alice(general, "Ali")}[0], Text: "/me started a thread: plans"},
+		},
+		{
+			name:  "joined thread message",
+			t:     "MESSAGE_CREATE",
+			event: `{"id":"22","channel_id":"11","type":0,"author":{"id":"2","username":"alice","avatar":"a"},"content":"pizza"}`,
+			ch:    lunch,
+			want:  chat.Message{ID: "22", From: &[]chat.User{alice(lunch, "Ali")}[0], Text: "pizza"},
+		},
+		{
+			name:  "thread create",
+			t:     "THREAD_CREATE",
+			event: `{"id":"12","guild_id":"100","parent_id":"10","name":"plans"}`,
+		},
+		{
+			name:  "unjoined thread message",
+			t:     "MESSAGE_CREATE",
+			event: `{"id":"23","channel_id":"12","type":0,"author":{"id":"2","username":"alice","avatar":"a"},"content":"tomorrow"}`,
+			ch:    general,
+			want:  chat.Message{ID: "23", From: &[]chat.User{alice(general, "Ali")}[0], Text: "tomorrow"},
+		},
+		{
+			name:  "member remove",
+			t:     "GUILD_MEMBER_REMOVE",
+			event: `{"guild_id":"100","user":{"id":"2","username":"alice","avatar":"a"}}`,
+			ch:    general,
+			want:  chat.Leave{Who: alice(general, "Ali")},
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, test := range tests {
+		var data interface{}
+		if err := json.Unmarshal([]byte(test.event), &data); err != nil {
+			t.Fatalf("%s: failed to unmarshal event: %s", test.name, err)
+		}
+		if err := dispatchEvent(ctx, cl, test.t, data); err != nil {
+			t.Errorf("%s: dispatchEvent(_, _, %s, %s)=%v", test.name, test.t, test.event, err)
+			continue
+		}
+		if test.ch == nil {
+			continue
+		}
+		got, err := test.ch.Receive(ctx)
 		if err != nil {
-			panic(err)
+			t.Fatalf("%s: %s.Receive(_)=_,%v", test.name, test.ch.name, err)
 		}
-		if got := limitKey(req); got != test.want {
-			t.Errorf("limitKey(%s)=%s, want %s", test.path, got, test.want)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %#v, want %#v", test.name, got, test.want)
 		}
 	}
 }
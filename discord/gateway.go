@@ -0,0 +1,167 @@
+package discord
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/velour/chat/websocket"
+)
+
+// An Intent is a set of gateway events that a Client receives.
+type Intent int
+
+// Gateway intents, from https://discord.com/developers/docs/topics/gateway#gateway-intents.
+// IntentGuildMembers, IntentGuildPresences, and IntentMessageContent are privileged,
+// and must be enabled for the bot in the Discord developer portal.
+const (
+	IntentGuilds Intent = 1 << iota
+	IntentGuildMembers
+	IntentGuildModeration
+	IntentGuildEmojisAndStickers
+	IntentGuildIntegrations
+	IntentGuildWebhooks
+	IntentGuildInvites
+	IntentGuildVoiceStates
+	IntentGuildPresences
+	IntentGuildMessages
+	IntentGuildMessageReactions
+	IntentGuildMessageTyping
+	IntentDirectMessages
+	IntentDirectMessageReactions
+	IntentDirectMessageTyping
+	IntentMessageContent
+)
+
+// DefaultIntents are the intents used if Options.Intents is zero.
+// They are the intents needed to bridge messages.
+const DefaultIntents = IntentGuilds | IntentGuildMessages | IntentDirectMessages | IntentMessageContent
+
+// DefaultVersion is the API version used if Options.Version is zero.
+const DefaultVersion = 10
+
+// Options are optional settings for a Client.
+type Options struct {
+	// Intents are the gateway intents sent when identifying.
+	// If zero, DefaultIntents is used.
+	Intents Intent
+
+	// Version is the Discord API version used
+	// for both the gateway and REST requests.
+	// If zero, DefaultVersion is used.
+	Version int
+
+	// Compress, if true, enables zlib-stream transport compression
+	// of the gateway connection.
+	Compress bool
+
+	// Shards is the number of gateway sessions,
+	// each receiving the events of a subset of the guilds.
+	// If zero, the number of shards recommended by Discord is used.
+	Shards int
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.Intents == 0 {
+		opts.Intents = DefaultIntents
+	}
+	if opts.Version == 0 {
+		opts.Version = DefaultVersion
+	}
+	return opts
+}
+
+// A gateway is a connection to the Discord gateway.
+type gateway struct {
+	conn *websocket.Conn
+
+	// If the connection is compressed, all messages from the gateway
+	// are a single zlib stream, with a sync flush after each payload.
+	// stream reads the compressed messages from conn,
+	// and dec decodes payloads from the decompressed stream.
+	// Both are nil if the connection is not compressed.
+	stream *messageReader
+	dec    *json.Decoder
+}
+
+// dialGateway dials the gateway at the URL
+// with the version and compression of the Options.
+func dialGateway(ctx context.Context, gatewayURL, token string, opts Options) (*gateway, error) {
+	u, err := url.Parse(gatewayURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("v", strconv.Itoa(opts.Version))
+	q.Set("encoding", "json")
+	if opts.Compress {
+		q.Set("compress", "zlib-stream")
+	}
+	u.RawQuery = q.Encode()
+	header := make(http.Header)
+	header.Set("Authorization", "Bot "+token)
+	conn, err := websocket.DialHeader(ctx, header, u)
+	if err != nil {
+		return nil, err
+	}
+	g := &gateway{conn: conn}
+	if opts.Compress {
+		g.stream = &messageReader{conn: conn}
+	}
+	return g, nil
+}
+
+// recv receives the next payload into msg.
+func (g *gateway) recv(ctx context.Context, msg interface{}) error {
+	if g.stream == nil {
+		return g.conn.Recv(ctx, msg)
+	}
+	g.stream.ctx = ctx
+	if g.dec == nil {
+		// zlib.NewReader reads the stream header from the first message.
+		z, err := zlib.NewReader(g.stream)
+		if err != nil {
+			return err
+		}
+		g.dec = json.NewDecoder(z)
+	}
+	return g.dec.Decode(msg)
+}
+
+func (g *gateway) send(ctx context.Context, msg interface{}) error {
+	return g.conn.Send(ctx, msg)
+}
+
+func (g *gateway) close(ctx context.Context) error {
+	return g.conn.Close(ctx)
+}
+
+// A messageReader is an io.Reader of the concatenated binary messages of a Conn.
+// Calls to Read block until the next message is received.
+type messageReader struct {
+	conn *websocket.Conn
+	// ctx is the Context for receiving messages.
+	ctx context.Context
+	buf bytes.Buffer
+}
+
+func (r *messageReader) Read(p []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		m, err := r.conn.RecvMessage(r.ctx)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if m.Binary {
+			r.buf.Write(m.Data)
+		}
+	}
+	return r.buf.Read(p)
+}
@@ -0,0 +1,508 @@
+package discord
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/velour/chat"
+	"github.com/velour/chat/cache"
+	"github.com/velour/chat/websocket"
+)
+
+// gatewayFrames are payloads recorded from the Discord gateway,
+// with unused fields elided.
+var gatewayFrames = []string{
+	`{"t":null,"s":null,"op":10,"d":{"heartbeat_interval":41250,"_trace":["[\"gateway-prd-us-east1-b-0568\",{\"micros\":0.0}]"]}}`,
+	`{"t":"READY","s":1,"op":0,"d":{"v":10,"user_settings":{},"user":{"verified":true,"username":"bridge","mfa_enabled":false,"id":"1043577744931266640","flags":0,"email":null,"discriminator":"0","bot":true,"avatar":null},"session_type":"normal","session_id":"8f7d8fd41f0cd8c4b06b8b2f08b6b3b5","resume_gateway_url":"wss://gateway-us-east1-b.discord.gg","relationships":[],"private_channels":[],"presences":[],"guilds":[{"unavailable":true,"id":"1043578096745291876"}],"guild_join_requests":[],"geo_ordered_rtc_regions":["newark","us-east"],"application":{"id":"1043577744931266640","flags":8953856}}}`,
+	`{"t":"GUILD_CREATE","s":2,"op":0,"d":{"id":"1043578096745291876","name":"velour","channels":[{"type":0,"name":"general","id":"1043578097407856722"}]}}`,
+}
+
+// fakeGateway serves the gatewayFrames, and records the query
+// and the identify payload sent by the client.
+type fakeGateway struct {
+	*httptest.Server
+	query    chan map[string][]string
+	identify chan map[string]interface{}
+}
+
+func newFakeGateway(t *testing.T) *fakeGateway {
+	g := &fakeGateway{
+		query:    make(chan map[string][]string, 1),
+		identify: make(chan map[string]interface{}, 1),
+	}
+	g.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+		defer cancel()
+		conn, err := websocket.Upgrade(ctx, w, req)
+		if err != nil {
+			t.Errorf("websocket.Upgrade(…)=_,%v", err)
+			return
+		}
+		defer conn.Close(ctx)
+		g.query <- req.URL.Query()
+
+		compress := req.URL.Query().Get("compress") == "zlib-stream"
+		var buf bytes.Buffer
+		z := zlib.NewWriter(&buf)
+		send := func(frame string) {
+			if !compress {
+				if err := conn.SendMessage(ctx, websocket.Message{Data: []byte(frame)}); err != nil {
+					t.Errorf("conn.SendMessage(…)=%v", err)
+				}
+				return
+			}
+			z.Write([]byte(frame))
+			z.Flush()
+			data := append([]byte{}, buf.Bytes()...)
+			buf.Reset()
+			// Split the payload across two messages,
+			// which the client must buffer.
+			for _, d := range [][]byte{data[:len(data)/2], data[len(data)/2:]} {
+				if err := conn.SendMessage(ctx, websocket.Message{Binary: true, Data: d}); err != nil {
+					t.Errorf("conn.SendMessage(…)=%v", err)
+				}
+			}
+		}
+
+		send(gatewayFrames[0])
+		var identify map[string]interface{}
+		if err := conn.Recv(ctx, &identify); err != nil {
+			t.Errorf("conn.Recv(…)=%v", err)
+			return
+		}
+		g.identify <- identify
+		for _, frame := range gatewayFrames[1:] {
+			send(frame)
+		}
+		// Wait for the client to close.
+		for conn.Recv(ctx, nil) == nil {
+		}
+	}))
+	return g
+}
+
+func TestGateway(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		g := newFakeGateway(t)
+		defer g.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		opts := Options{Intents: DefaultIntents | IntentGuildMembers, Compress: compress}.withDefaults()
+		gatewayURL := "ws" + strings.TrimPrefix(g.URL, "http")
+		conn, err := dialGateway(ctx, gatewayURL, "token", opts)
+		if err != nil {
+			t.Fatalf("dialGateway(…)=_,%v", err)
+		}
+		s, err := newSession(ctx, conn, "token", opts.Intents, [2]int{0, 1})
+		if err != nil {
+			t.Fatalf("compress=%v: newSession(…)=_,%v", compress, err)
+		}
+		if s.id != "8f7d8fd41f0cd8c4b06b8b2f08b6b3b5" {
+			t.Errorf("compress=%v: s.id=%q, want 8f7d8fd41f0cd8c4b06b8b2f08b6b3b5", compress, s.id)
+		}
+		var m msg
+		if err := conn.recv(ctx, &m); err != nil || m.T != "GUILD_CREATE" || m.S != 2 {
+			t.Errorf("compress=%v: conn.recv(…)=%v, got %+v, want GUILD_CREATE 2", compress, err, m)
+		}
+		conn.close(ctx)
+
+		wantQuery := map[string][]string{"v": {"10"}, "encoding": {"json"}}
+		if compress {
+			wantQuery["compress"] = []string{"zlib-stream"}
+		}
+		if q := <-g.query; !reflect.DeepEqual(q, wantQuery) {
+			t.Errorf("compress=%v: query=%v, want %v", compress, q, wantQuery)
+		}
+		identify := <-g.identify
+		d, _ := identify["d"].(map[string]interface{})
+		// 1<<0 | 1<<1 | 1<<9 | 1<<12 | 1<<15
+		const wantIntents = 37379
+		if identify["op"] != float64(OpIdentify) || d["token"] != "token" || d["intents"] != float64(wantIntents) ||
+			!reflect.DeepEqual(d["shard"], []interface{}{0.0, 1.0}) {
+			data, _ := json.Marshal(identify)
+			t.Errorf("compress=%v: identify=%s, want op 2, token, intents %d, shard [0,1]", compress, data, wantIntents)
+		}
+	}
+}
+
+func TestShards(t *testing.T) {
+	g := newFakeGateway(t)
+	defer g.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	background, cancelBackground := context.WithCancel(ctx)
+	cl := &Client{
+		token:            "token",
+		opts:             Options{}.withDefaults(),
+		gatewayURL:       "ws" + strings.TrimPrefix(g.URL, "http"),
+		cancelBackground: cancelBackground,
+		backgroundDone:   make(chan struct{}),
+		joined:           make(map[string]*Channel),
+		threads:          make(map[string]string),
+		guildsByID:       make(map[string]*guild),
+		guilds:           cache.New(0, 0),
+	}
+
+	ready := make(chan error)
+	go runShards(background, cl, 2, 2, ready)
+	for i := 0; i < 2; i++ {
+		<-g.query
+		identify := <-g.identify
+		d, _ := identify["d"].(map[string]interface{})
+		if want := []interface{}{float64(i), 2.0}; !reflect.DeepEqual(d["shard"], want) {
+			t.Errorf("identify shard=%v, want %v", d["shard"], want)
+		}
+	}
+	if err, ok := <-ready; ok {
+		t.Fatalf("runShards(…) ready=%v", err)
+	}
+	cl.mu.Lock()
+	appID := cl.appID
+	cl.mu.Unlock()
+	if appID != "1043577744931266640" {
+		t.Errorf("cl.appID=%q, want 1043577744931266640", appID)
+	}
+
+	// The guild is unavailable from READY until GUILD_CREATE.
+	want := []Guild{{
+		ID:   "1043578096745291876",
+		Name: "velour",
+		Channels: []GuildChannel{
+			{ID: "1043578097407856722", Name: "general"},
+		},
+	}}
+	var guilds []Guild
+	for {
+		if guilds = cl.Guilds(); len(guilds) == 1 && !guilds[0].Unavailable || ctx.Err() != nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !reflect.DeepEqual(guilds, want) {
+		t.Errorf("cl.Guilds()=%+v, want %+v", guilds, want)
+	}
+
+	cl.cancelBackground()
+	<-cl.backgroundDone
+}
+
+// A gatewayScript is the expected sequence of payloads
+// on one connection to a scriptedGateway.
+type gatewayScript struct {
+	// path is the expected request path.
+	path string
+	// status, if non-zero, fails the websocket handshake with the HTTP status.
+	status int
+	steps  []gatewayStep
+}
+
+// A gatewayStep is a payload sent to the client, if send is non-empty,
+// or else a payload expected from the client.
+type gatewayStep struct {
+	// send is the payload to send,
+	// with $URL replaced by the websocket URL of the scriptedGateway.
+	send string
+	// op is the op of the expected payload.
+	op int
+	// d is the expected data of the payload.
+	// If d is a map, only the fields of d are compared.
+	d interface{}
+}
+
+// scriptedGateway is a fake gateway that runs the next script on each connection.
+// When the last script is run, done is closed,
+// and each connection stays open until the client closes it.
+type scriptedGateway struct {
+	*httptest.Server
+	done chan struct{}
+
+	mu sync.Mutex
+	// arrivals are the arrival times of the connections.
+	arrivals []time.Time
+}
+
+func (g *scriptedGateway) arrived() []time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]time.Time{}, g.arrivals...)
+}
+
+func newScriptedGateway(t *testing.T, scripts []gatewayScript) *scriptedGateway {
+	g := &scriptedGateway{done: make(chan struct{})}
+	g.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		g.mu.Lock()
+		i := len(g.arrivals)
+		g.arrivals = append(g.arrivals, time.Now())
+		g.mu.Unlock()
+		if i >= len(scripts) {
+			t.Errorf("unexpected connection %d to %s", i, req.URL.Path)
+			http.Error(w, "no more scripts", http.StatusGone)
+			return
+		}
+		script := scripts[i]
+		last := i == len(scripts)-1
+		if req.URL.Path != script.path {
+			t.Errorf("connection %d: path %s, want %s", i, req.URL.Path, script.path)
+		}
+		if script.status != 0 {
+			http.Error(w, http.StatusText(script.status), script.status)
+			if last {
+				close(g.done)
+			}
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+		defer cancel()
+		conn, err := websocket.Upgrade(ctx, w, req)
+		if err != nil {
+			t.Errorf("connection %d: websocket.Upgrade(…)=_,%v", i, err)
+			return
+		}
+		defer conn.Close(ctx)
+		wsURL := "ws" + strings.TrimPrefix(g.URL, "http")
+		for j, step := range script.steps {
+			if step.send != "" {
+				data := []byte(strings.Replace(step.send, "$URL", wsURL, -1))
+				if err := conn.SendMessage(ctx, websocket.Message{Data: data}); err != nil {
+					t.Errorf("connection %d step %d: conn.SendMessage(…)=%v", i, j, err)
+					return
+				}
+				continue
+			}
+			var m struct {
+				Op int         `json:"op"`
+				D  interface{} `json:"d"`
+			}
+			if err := conn.Recv(ctx, &m); err != nil {
+				t.Errorf("connection %d step %d: conn.Recv(…)=%v", i, j, err)
+				return
+			}
+			if m.Op != step.op {
+				t.Errorf("connection %d step %d: got op %d, want %d", i, j, m.Op, step.op)
+				continue
+			}
+			want, ok := step.d.(map[string]interface{})
+			if !ok {
+				if !reflect.DeepEqual(m.D, step.d) {
+					t.Errorf("connection %d step %d: got d=%v, want %v", i, j, m.D, step.d)
+				}
+				continue
+			}
+			d, _ := m.D.(map[string]interface{})
+			for k, v := range want {
+				if !reflect.DeepEqual(d[k], v) {
+					t.Errorf("connection %d step %d: got %s=%v, want %v", i, j, k, d[k], v)
+				}
+			}
+		}
+		if last {
+			close(g.done)
+		}
+		// Wait for the client to close.
+		for conn.Recv(ctx, nil) == nil {
+		}
+	}))
+	return g
+}
+
+func TestGatewayReconnect(t *testing.T) {
+	defer func(min, max time.Duration) { minBackoff, maxBackoff = min, max }(minBackoff, maxBackoff)
+	minBackoff, maxBackoff = 20*time.Millisecond, time.Second
+
+	const (
+		hello   = `{"op":10,"d":{"heartbeat_interval":41250}}`
+		message = `{"op":0,"t":"MESSAGE_CREATE","s":%d,"d":{"id":"%d","channel_id":"10","author":{"id":"2","username":"alice"},"content":"hi"}}`
+	)
+	ready := func(id string) gatewayStep {
+		return gatewayStep{send: `{"op":0,"t":"READY","s":1,"d":{"session_id":"` + id + `","resume_gateway_url":"$URL/resume","guilds":[]}}`}
+	}
+	send := func(format string, args ...interface{}) gatewayStep {
+		return gatewayStep{send: fmt.Sprintf(format, args...)}
+	}
+	identify := gatewayStep{op: OpIdentify, d: map[string]interface{}{"token": "token"}}
+	resume := func(id string, seq int) gatewayStep {
+		return gatewayStep{
+			op: OpResume,
+			d:  map[string]interface{}{"token": "token", "session_id": id, "seq": float64(seq)},
+		}
+	}
+	g := newScriptedGateway(t, []gatewayScript{
+		{
+			path: "/",
+			steps: []gatewayStep{
+				send(hello),
+				identify,
+				ready("s1"),
+				send(message, 2, 20),
+				// A heartbeat request is answered immediately.
+				send(`{"op":1,"d":null}`),
+				{op: OpHeartbeat, d: 2.0},
+				// A reconnect request is resumed at the resume URL.
+				send(`{"op":7,"d":null}`),
+			},
+		},
+		{
+			path: "/resume",
+			steps: []gatewayStep{
+				send(hello),
+				resume("s1", 2),
+				// A resumable invalid session is resumed again.
+				send(`{"op":9,"d":true}`),
+			},
+		},
+		// A failed connection is retried.
+		{path: "/resume", status: http.StatusServiceUnavailable},
+		{
+			path: "/resume",
+			steps: []gatewayStep{
+				send(hello),
+				resume("s1", 2),
+				send(`{"op":0,"t":"RESUMED","s":3,"d":{}}`),
+				send(message, 4, 21),
+				// A non-resumable invalid session identifies a new session
+				// at the gateway URL.
+				send(`{"op":9,"d":false}`),
+			},
+		},
+		{
+			path: "/",
+			steps: []gatewayStep{
+				send(hello),
+				identify,
+				ready("s2"),
+				send(message, 2, 22),
+				send(`{"op":7,"d":null}`),
+			},
+		},
+		// Consecutive failed connections are retried with increasing delays,
+		// even though the session was connected before them.
+		{path: "/resume", status: http.StatusServiceUnavailable},
+		{path: "/resume", status: http.StatusServiceUnavailable},
+		{path: "/resume", status: http.StatusServiceUnavailable},
+		{path: "/resume", status: http.StatusServiceUnavailable},
+		{
+			path: "/resume",
+			steps: []gatewayStep{
+				send(hello),
+				resume("s2", 2),
+				send(`{"op":0,"t":"RESUMED","s":3,"d":{}}`),
+				send(message, 4, 23),
+			},
+		},
+	})
+	defer g.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	background, cancelBackground := context.WithCancel(ctx)
+	cl := &Client{
+		token:            "token",
+		opts:             Options{}.withDefaults(),
+		gatewayURL:       "ws" + strings.TrimPrefix(g.URL, "http"),
+		cancelBackground: cancelBackground,
+		backgroundDone:   make(chan struct{}),
+		joined:           make(map[string]*Channel),
+		userNames:        make(map[string]string),
+		rewriteNames:     make(map[string]string),
+		threads:          make(map[string]string),
+		nicks:            make(map[string]string),
+		guildsByID:       make(map[string]*guild),
+		guilds:           cache.New(0, 0),
+	}
+	general := &Channel{cl: cl, id: "10", name: "general"}
+	start(general)
+	cl.joined[general.id] = general
+
+	errs := make(chan error)
+	go runShards(background, cl, 1, 1, errs)
+	if err, ok := <-errs; ok {
+		t.Fatalf("runShards(…) ready=%v", err)
+	}
+	// Messages are received across reconnects.
+	for _, id := range []chat.MessageID{"20", "21", "22", "23"} {
+		ev, err := general.Receive(ctx)
+		if m, ok := ev.(chat.Message); err != nil || !ok || m.ID != id {
+			t.Fatalf("general.Receive(…)=%#v,%v, want message %s", ev, err, id)
+		}
+	}
+	<-g.done
+	if err := cl.Close(ctx); err != nil {
+		t.Errorf("cl.Close(…)=%v", err)
+	}
+
+	// The delay after the nth consecutive failure
+	// is at least half of minBackoff<<(n-1).
+	arrivals := g.arrived()
+	failed := arrivals[len(arrivals)-5:]
+	var delays []time.Duration
+	for i := 1; i < len(failed); i++ {
+		delays = append(delays, failed[i].Sub(failed[i-1]))
+	}
+	for i, d := range delays {
+		if min := minBackoff << uint(i) / 2; d < min {
+			t.Errorf("delay after failure %d is %s, want at least %s", i+1, d, min)
+		}
+	}
+	if first, last := delays[0], delays[len(delays)-1]; last <= first {
+		t.Errorf("delays %v, want increasing", delays)
+	}
+}
+
+// TestGatewayCanceledStart tests that runShards reports an error
+// if the Context is canceled during the first connect.
+func TestGatewayCanceledStart(t *testing.T) {
+	g := newScriptedGateway(t, []gatewayScript{
+		{
+			path: "/",
+			steps: []gatewayStep{
+				{send: `{"op":10,"d":{"heartbeat_interval":41250}}`},
+				{op: OpIdentify, d: map[string]interface{}{"token": "token"}},
+				// READY is never sent.
+			},
+		},
+	})
+	defer g.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	background, cancelBackground := context.WithCancel(ctx)
+	cl := &Client{
+		token:            "token",
+		opts:             Options{}.withDefaults(),
+		gatewayURL:       "ws" + strings.TrimPrefix(g.URL, "http"),
+		cancelBackground: cancelBackground,
+		backgroundDone:   make(chan struct{}),
+		joined:           make(map[string]*Channel),
+		threads:          make(map[string]string),
+		guildsByID:       make(map[string]*guild),
+		guilds:           cache.New(0, 0),
+	}
+
+	ready := make(chan error)
+	go runShards(background, cl, 1, 1, ready)
+	<-g.done
+	cancelBackground()
+	select {
+	case err, ok := <-ready:
+		if !ok || err == nil {
+			t.Errorf("runShards(…) ready=%v,%v, want an error", err, ok)
+		}
+	case <-ctx.Done():
+		t.Fatalf("runShards(…) did not report ready")
+	}
+	select {
+	case <-cl.backgroundDone:
+	case <-ctx.Done():
+		t.Errorf("runShards(…) did not stop")
+	}
+}
@@ -0,0 +1,367 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Channel types, from https://discord.com/developers/docs/resources/channel#channel-object-channel-types.
+const (
+	dmChannelType                 = 1
+	categoryChannelType           = 4
+	announcementThreadChannelType = 10
+	publicThreadChannelType       = 11
+	privateThreadChannelType      = 12
+)
+
+// A guild is a guild and its channels, not including threads.
+type guild struct {
+	ID   string
+	Name string
+	// Unavailable is whether the guild is in an outage,
+	// or is not yet received from the gateway.
+	Unavailable bool
+	Channels    []channelInfo
+}
+
+// A channelInfo is a guild channel, thread, or DM channel.
+type channelInfo struct {
+	ID       string `json:"id"`
+	GuildID  string `json:"guild_id"`
+	Name     string `json:"name"`
+	Type     int    `json:"type"`
+	ParentID string `json:"parent_id"`
+
+	// Recipients are the users of a DM channel.
+	Recipients []user `json:"recipients"`
+}
+
+func isThreadType(t int) bool {
+	return t == announcementThreadChannelType ||
+		t == publicThreadChannelType ||
+		t == privateThreadChannelType
+}
+
+func channelNames(chs []channelInfo) map[string]string {
+	names := make(map[string]string, len(chs))
+	for _, x := range chs {
+		names[x.ID] = x.Name
+	}
+	return names
+}
+
+// putGuild records the guild, replacing any previous record of the guild.
+func putGuild(cl *Client, g *guild) {
+	for i := range g.Channels {
+		g.Channels[i].GuildID = g.ID
+	}
+	cl.mu.Lock()
+	cl.guildsByID[g.ID] = g
+	cl.mu.Unlock()
+}
+
+// renameGuild changes the name of a recorded guild.
+func renameGuild(cl *Client, id, name string) {
+	cl.mu.Lock()
+	if g, ok := cl.guildsByID[id]; ok {
+		g.Name = name
+	}
+	cl.mu.Unlock()
+}
+
+// markUnavailable records that a guild is in an outage.
+func markUnavailable(cl *Client, id string) {
+	cl.mu.Lock()
+	if g, ok := cl.guildsByID[id]; ok {
+		g.Unavailable = true
+	}
+	cl.mu.Unlock()
+}
+
+// recordReady records the application ID and the guilds of a READY event.
+// The guilds are unavailable until received in GUILD_CREATE events.
+func recordReady(cl *Client, r readyData) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	cl.appID = r.Application.ID
+	for _, g := range r.Guilds {
+		if _, ok := cl.guildsByID[g.ID]; !ok {
+			cl.guildsByID[g.ID] = &guild{ID: g.ID, Unavailable: true}
+		}
+	}
+}
+
+// removeGuild forgets a guild that the bot left.
+func removeGuild(cl *Client, id string) {
+	cl.mu.Lock()
+	delete(cl.guildsByID, id)
+	cl.mu.Unlock()
+}
+
+// putChannel records a created or updated channel of a recorded guild.
+func putChannel(cl *Client, c channelInfo) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	g, ok := cl.guildsByID[c.GuildID]
+	if !ok {
+		return
+	}
+	for i := range g.Channels {
+		if g.Channels[i].ID == c.ID {
+			g.Channels[i] = c
+			return
+		}
+	}
+	g.Channels = append(g.Channels, c)
+}
+
+// removeChannel forgets a deleted channel of a recorded guild.
+func removeChannel(cl *Client, guildID, id string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	g, ok := cl.guildsByID[guildID]
+	if !ok {
+		return
+	}
+	for i := range g.Channels {
+		if g.Channels[i].ID == id {
+			g.Channels = append(g.Channels[:i], g.Channels[i+1:]...)
+			return
+		}
+	}
+}
+
+// lookupID returns the recorded guild channel with the ID,
+// and the ID and name of its guild.
+func lookupID(cl *Client, id string) (channelInfo, idAndName, bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for _, g := range cl.guildsByID {
+		for _, c := range g.Channels {
+			if c.ID == id {
+				return c, idAndName{ID: g.ID, Name: g.Name}, true
+			}
+		}
+	}
+	return channelInfo{}, idAndName{}, false
+}
+
+// lookupName returns the recorded channel with the name in the named guild,
+// and the ID and name of the guild.
+// Categories are not channels for lookupName.
+// If the guild is not recorded, the returned guild is nil.
+// If more than one guild or channel has the name, an error is returned.
+func lookupName(cl *Client, guildName, chName string) (channelInfo, *idAndName, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	var g *guild
+	for _, x := range cl.guildsByID {
+		if x.Name != guildName || x.Unavailable {
+			continue
+		}
+		if g != nil {
+			return channelInfo{}, nil, errors.New("guild name " + guildName + " is ambiguous, join by channel ID")
+		}
+		g = x
+	}
+	if g == nil {
+		return channelInfo{}, nil, nil
+	}
+	var c *channelInfo
+	for i, x := range g.Channels {
+		if x.Name != chName || x.Type == categoryChannelType {
+			continue
+		}
+		if c != nil {
+			return channelInfo{}, nil, errors.New("channel name " + chName + " is ambiguous, join by channel ID")
+		}
+		c = &g.Channels[i]
+	}
+	nid := &idAndName{ID: g.ID, Name: g.Name}
+	if c == nil {
+		return channelInfo{}, nid, errors.New("channel " + chName + " not found")
+	}
+	return *c, nid, nil
+}
+
+// fetchGuilds records the guilds of the bot
+// that are not yet recorded from the gateway.
+func fetchGuilds(ctx context.Context, cl *Client) error {
+	var guilds []idAndName
+	if err := cl.get(ctx, "users/@me/guilds", &guilds); err != nil {
+		return err
+	}
+	for _, nid := range guilds {
+		cl.mu.Lock()
+		g, ok := cl.guildsByID[nid.ID]
+		available := ok && !g.Unavailable
+		cl.mu.Unlock()
+		if available {
+			continue
+		}
+		g = &guild{ID: nid.ID, Name: nid.Name}
+		if err := cl.get(ctx, "guilds/"+g.ID+"/channels", &g.Channels); err != nil {
+			return err
+		}
+		putGuild(cl, g)
+	}
+	return nil
+}
+
+// fetchChannel returns the channel with the ID.
+// If there is no such channel, but the ID is a user ID,
+// the DM channel with the user is returned.
+func fetchChannel(ctx context.Context, cl *Client, id string) (channelInfo, error) {
+	var c channelInfo
+	err := cl.get(ctx, "channels/"+id, &c)
+	if code, ok := err.(httpErr); !ok || code != 404 {
+		return c, err
+	}
+	req := struct {
+		RecipientID string `json:"recipient_id"`
+	}{RecipientID: id}
+	if err := cl.post(ctx, "users/@me/channels", req, &c); err != nil {
+		return channelInfo{}, errors.New("channel or user " + id + " not found")
+	}
+	return c, nil
+}
+
+// resolve returns the channel, and the name of its guild,
+// for the argument to Join.
+func resolve(ctx context.Context, cl *Client, name string) (channelInfo, string, error) {
+	if isSnowflake(name) {
+		if c, g, ok := lookupID(cl, name); ok {
+			return c, g.Name, nil
+		}
+		c, err := fetchChannel(ctx, cl, name)
+		if err != nil || c.GuildID == "" {
+			return c, "", err
+		}
+		var guildName string
+		cl.mu.Lock()
+		g, ok := cl.guildsByID[c.GuildID]
+		if ok {
+			guildName = g.Name
+		}
+		cl.mu.Unlock()
+		if ok {
+			return c, guildName, nil
+		}
+		var nid idAndName
+		if err := cl.get(ctx, "guilds/"+c.GuildID, &nid); err != nil {
+			return channelInfo{}, "", err
+		}
+		return c, nid.Name, nil
+	}
+
+	var err error
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		var c channelInfo
+		var guildName string
+		if c, guildName, err = resolvePath(ctx, cl, name[:i], name[i+1:]); err == nil {
+			return c, guildName, nil
+		}
+	}
+	// Earlier versions joined <guild>:<channel>, which is still accepted.
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		c, guildName, legacyErr := resolvePath(ctx, cl, name[:i], name[i+1:])
+		if legacyErr == nil || err == nil {
+			return c, guildName, legacyErr
+		}
+	}
+	if err == nil {
+		err = errors.New("malformed channel " + name + ", want an ID, <guild>/<channel>, or <guild>/<channel>/<thread>")
+	}
+	return channelInfo{}, "", err
+}
+
+// resolvePath returns the channel, and the name of its guild,
+// for a guild name and a path of the form <channel> or <channel>/<thread>.
+func resolvePath(ctx context.Context, cl *Client, guildName, path string) (channelInfo, string, error) {
+	chName, threadName := splitThread(path)
+	c, g, err := lookupName(cl, guildName, chName)
+	if g == nil && err == nil {
+		// The guild may not be received from the gateway yet.
+		if err := fetchGuilds(ctx, cl); err != nil {
+			return channelInfo{}, "", err
+		}
+		c, g, err = lookupName(cl, guildName, chName)
+	}
+	switch {
+	case err != nil:
+		return channelInfo{}, "", err
+	case g == nil:
+		return channelInfo{}, "", errors.New("guild " + guildName + " not found")
+	case threadName == "":
+		return c, g.Name, nil
+	}
+	threadID, err := findThread(ctx, cl, g.ID, c.ID, threadName)
+	if err != nil {
+		return channelInfo{}, "", err
+	}
+	th := channelInfo{
+		ID:       threadID,
+		GuildID:  g.ID,
+		Name:     threadName,
+		Type:     publicThreadChannelType,
+		ParentID: c.ID,
+	}
+	return th, g.Name, nil
+}
+
+// A Guild is a guild of the bot.
+type Guild struct {
+	ID   string
+	Name string
+
+	// Unavailable is whether the guild is in an outage,
+	// or is not yet received from the gateway.
+	// The Name and Channels of an unavailable guild may be unknown.
+	Unavailable bool
+
+	// Channels are the channels of the guild, not including threads.
+	Channels []GuildChannel
+}
+
+// A GuildChannel is a channel of a Guild.
+type GuildChannel struct {
+	ID   string
+	Name string
+
+	// Type is the Discord channel type.
+	// See https://discord.com/developers/docs/resources/channel#channel-object-channel-types.
+	Type int
+
+	// ParentID is the ID of the category of the channel,
+	// or empty if the channel has no category.
+	ParentID string
+}
+
+// Guilds returns the guilds of the bot, sorted by name,
+// as received from the gateway by all shards.
+func (cl *Client) Guilds() []Guild {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	var guilds []Guild
+	for _, g := range cl.guildsByID {
+		gg := Guild{ID: g.ID, Name: g.Name, Unavailable: g.Unavailable}
+		for _, c := range g.Channels {
+			gg.Channels = append(gg.Channels, GuildChannel{
+				ID:       c.ID,
+				Name:     c.Name,
+				Type:     c.Type,
+				ParentID: c.ParentID,
+			})
+		}
+		guilds = append(guilds, gg)
+	}
+	sort.Slice(guilds, func(i, j int) bool {
+		if guilds[i].Name != guilds[j].Name {
+			return guilds[i].Name < guilds[j].Name
+		}
+		return guilds[i].ID < guilds[j].ID
+	})
+	return guilds
+}
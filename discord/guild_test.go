@@ -0,0 +1,99 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/velour/chat/cache"
+)
+
+func TestLookupName(t *testing.T) {
+	cl := &Client{
+		joined:     make(map[string]*Channel),
+		threads:    make(map[string]string),
+		guildsByID: make(map[string]*guild),
+		guilds:     cache.New(0, 0),
+	}
+	events := []struct {
+		t     string
+		event string
+	}{
+		{"GUILD_CREATE", `{"id":"100","name":"velour","channels":[{"id":"4","type":4,"name":"general"},{"id":"10","type":0,"name":"general"},{"id":"11","type":0,"name":"random"}]}`},
+		{"GUILD_CREATE", `{"id":"200","name":"twins","channels":[{"id":"20","type":0,"name":"general"}]}`},
+		{"GUILD_CREATE", `{"id":"300","name":"twins","channels":[{"id":"30","type":0,"name":"general"}]}`},
+		{"GUILD_CREATE", `{"id":"400","name":"old","channels":[{"id":"40","type":0,"name":"general"}]}`},
+		{"GUILD_UPDATE", `{"id":"400","name":"new"}`},
+		{"CHANNEL_CREATE", `{"id":"12","guild_id":"100","type":0,"name":"lunch"}`},
+		{"CHANNEL_UPDATE", `{"id":"11","guild_id":"100","type":0,"name":"dinner"}`},
+		{"CHANNEL_CREATE", `{"id":"13","guild_id":"100","type":0,"name":"dupe"}`},
+		{"CHANNEL_CREATE", `{"id":"14","guild_id":"100","type":0,"name":"dupe"}`},
+		{"GUILD_CREATE", `{"id":"500","name":"gone","channels":[{"id":"50","type":0,"name":"general"}]}`},
+		{"GUILD_DELETE", `{"id":"500"}`},
+		{"CHANNEL_DELETE", `{"id":"12","guild_id":"100","type":0,"name":"lunch"}`},
+		{"GUILD_CREATE", `{"id":"600","name":"a:b","channels":[{"id":"60","type":0,"name":"general"}]}`},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, ev := range events {
+		var data interface{}
+		if err := json.Unmarshal([]byte(ev.event), &data); err != nil {
+			t.Fatalf("failed to unmarshal %s: %s", ev.event, err)
+		}
+		if err := dispatchEvent(ctx, cl, ev.t, data); err != nil {
+			t.Fatalf("dispatchEvent(_, _, %s, %s)=%v", ev.t, ev.event, err)
+		}
+	}
+
+	tests := []struct {
+		guild, channel string
+		// id is the ID of the channel found, if any.
+		id string
+		// known is whether the guild is recorded.
+		known bool
+		err   bool
+	}{
+		{guild: "velour", channel: "general", id: "10", known: true},
+		{guild: "velour", channel: "dinner", id: "11", known: true},
+		{guild: "velour", channel: "random", known: true, err: true},
+		{guild: "velour", channel: "lunch", known: true, err: true},
+		{guild: "velour", channel: "dupe", err: true},
+		{guild: "twins", channel: "general", err: true},
+		{guild: "new", channel: "general", id: "40", known: true},
+		{guild: "old", channel: "general"},
+		{guild: "gone", channel: "general"},
+	}
+	for _, test := range tests {
+		c, g, err := lookupName(cl, test.guild, test.channel)
+		if (err != nil) != test.err || (g != nil) != test.known || c.ID != test.id {
+			t.Errorf("lookupName(_, %s, %s)=%+v,%+v,%v, want ID %q, known %v, err %v",
+				test.guild, test.channel, c, g, err, test.id, test.known, test.err)
+		}
+	}
+
+	if c, g, ok := lookupID(cl, "20"); !ok || c.Name != "general" || g.ID != "200" {
+		t.Errorf("lookupID(_, 20)=%+v,%+v,%v, want general in 200", c, g, ok)
+	}
+	if c, g, ok := lookupID(cl, "50"); ok {
+		t.Errorf("lookupID(_, 50)=%+v,%+v,%v, want not found", c, g, ok)
+	}
+
+	// Joining a joined channel does not make requests.
+	general := &Channel{cl: cl, id: "10", name: "general", guildID: "100"}
+	cl.joined["10"] = general
+	for _, name := range []string{"10", "velour/general", "velour:general"} {
+		if ch, err := cl.Join(ctx, name); err != nil || ch != general {
+			t.Errorf("Join(_, %s)=%v,%v, want the general Channel", name, ch, err)
+		}
+	}
+
+	// The / form takes precedence over the : form.
+	if c, guildName, err := resolve(ctx, cl, "a:b/general"); err != nil || c.ID != "60" || guildName != "a:b" {
+		t.Errorf("resolve(_, _, a:b/general)=%+v,%s,%v, want 60 in a:b", c, guildName, err)
+	}
+	if _, _, err := resolve(ctx, cl, "general"); err == nil || !strings.Contains(err.Error(), "<guild>/<channel>") {
+		t.Errorf("resolve(_, _, general)=_,_,%v, want an error naming <guild>/<channel>", err)
+	}
+}
@@ -0,0 +1,224 @@
+package discord
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/velour/chat"
+)
+
+// An OptionType is the type of a command option.
+type OptionType int
+
+// Command option types, from https://discord.com/developers/docs/interactions/application-commands#application-command-object-application-command-option-type.
+const (
+	OptionSubcommand OptionType = 1 + iota
+	OptionSubcommandGroup
+	OptionString
+	OptionInteger
+	OptionBoolean
+	OptionUser
+	OptionChannel
+	OptionRole
+	OptionMentionable
+	OptionNumber
+)
+
+// A Command is an application command, invoked by users as /<name>.
+type Command struct {
+	// Name is the name of the command.
+	// It must be lowercase, without spaces.
+	Name string `json:"name"`
+
+	// Description is shown to users with the command.
+	Description string `json:"description"`
+
+	// Options are the options or subcommands of the command.
+	Options []CommandOption `json:"options,omitempty"`
+}
+
+// A CommandOption is an option or subcommand of a Command.
+type CommandOption struct {
+	Type        OptionType `json:"type"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Required    bool       `json:"required,omitempty"`
+
+	// Options are the options of a subcommand,
+	// or the subcommands of a subcommand group.
+	Options []CommandOption `json:"options,omitempty"`
+}
+
+// RegisterCommands replaces the global application commands of the bot.
+// When a user invokes one of the Commands in a joined channel,
+// an Interaction event is received on the Channel.
+func (cl *Client) RegisterCommands(ctx context.Context, cmds []Command) error {
+	var app struct {
+		ID string `json:"id"`
+	}
+	cl.mu.Lock()
+	app.ID = cl.appID
+	cl.mu.Unlock()
+	if app.ID == "" {
+		if err := cl.get(ctx, "oauth2/applications/@me", &app); err != nil {
+			return err
+		}
+	}
+	if cmds == nil {
+		// Discord requires an array, even if empty.
+		cmds = []Command{}
+	}
+	return cl.rpc(ctx, http.MethodPut, "applications/"+app.ID+"/commands", cmds, nil)
+}
+
+// applicationCommandInteractionType is the interaction type of commands.
+const applicationCommandInteractionType = 2
+
+type interactionData struct {
+	Name    string              `json:"name"`
+	Options []interactionOption `json:"options"`
+}
+
+type interactionOption struct {
+	Name    string              `json:"name"`
+	Type    OptionType          `json:"type"`
+	Value   interface{}         `json:"value"`
+	Options []interactionOption `json:"options"`
+}
+
+// An Interaction is an event describing a user invoking a Command.
+// Discord shows the user an error
+// unless the Interaction is replied to or deferred within 3 seconds.
+type Interaction struct {
+	// Channel is the Channel in which the Command was invoked.
+	Channel chat.Channel
+
+	// ID is the unique identifier of the interaction.
+	ID string
+
+	// From is the User who invoked the Command.
+	From *chat.User
+
+	// Command is the name of the invoked Command,
+	// followed by the names of any subcommand group and subcommand,
+	// separated by spaces.
+	Command string
+
+	// Options are the option values by name.
+	// The values of string, user, channel, role, and mentionable options
+	// are strings, with user, channel, and role IDs;
+	// the values of integer and number options are float64s;
+	// and the values of boolean options are bools.
+	Options map[string]interface{}
+
+	appID  string
+	token  string
+	client *Client
+}
+
+func (e Interaction) Origin() chat.Channel { return e.Channel }
+
+// ephemeralFlag is the message flag of messages shown only to the invoking user.
+const ephemeralFlag = 1 << 6
+
+// Interaction callback types.
+const (
+	messageCallbackType         = 4
+	deferredMessageCallbackType = 5
+)
+
+type interactionResponse struct {
+	Type int                      `json:"type"`
+	Data *interactionResponseData `json:"data,omitempty"`
+}
+
+type interactionResponseData struct {
+	Content string `json:"content,omitempty"`
+	Flags   int    `json:"flags,omitempty"`
+}
+
+// Reply replies to the Interaction with a message.
+// If ephemeral is true, the message is only shown to the invoking user.
+// Reply may only be called once, and not after Defer.
+func (e Interaction) Reply(ctx context.Context, text string, ephemeral bool) error {
+	return e.respond(ctx, messageCallbackType, text, ephemeral)
+}
+
+// Defer acknowledges the Interaction, showing a loading state,
+// to reply later with EditReply.
+// If ephemeral is true, the reply is only shown to the invoking user.
+func (e Interaction) Defer(ctx context.Context, ephemeral bool) error {
+	return e.respond(ctx, deferredMessageCallbackType, "", ephemeral)
+}
+
+// EditReply changes the text of the reply to the Interaction.
+// If the Interaction was deferred, EditReply sends the reply.
+// Interactions can be edited for 15 minutes.
+func (e Interaction) EditReply(ctx context.Context, text string) error {
+	req := interactionResponseData{Content: text}
+	return e.client.patch(ctx, "webhooks/"+e.appID+"/"+e.token+"/messages/@original", req, nil)
+}
+
+func (e Interaction) respond(ctx context.Context, callbackType int, text string, ephemeral bool) error {
+	req := interactionResponse{
+		Type: callbackType,
+		Data: &interactionResponseData{Content: text},
+	}
+	if ephemeral {
+		req.Data.Flags = ephemeralFlag
+	}
+	return e.client.post(ctx, "interactions/"+e.ID+"/"+e.token+"/callback", req, nil)
+}
+
+// interactionEvent sends an Interaction event
+// for a command interaction to its joined Channel.
+// Other interactions and interactions in other channels are ignored.
+func interactionEvent(cl *Client, ev *event) {
+	if ev.Type != applicationCommandInteractionType || ev.Data == nil {
+		return
+	}
+	ch, ok := messageChannel(cl, ev.ChannelID)
+	if !ok {
+		return
+	}
+	// Interactions in guilds have the Member, and in DMs have the User.
+	u := ev.User
+	if ev.Member != nil && ev.Member.User != nil {
+		u = ev.Member.User
+	}
+	if u == nil {
+		return
+	}
+	cl.mu.Lock()
+	cl.userNames[u.ID] = u.Username
+	if ev.Member != nil {
+		cl.nicks[ch.guildID+"/"+u.ID] = nickString(ev.Member.Nick)
+	}
+	cl.mu.Unlock()
+
+	in := Interaction{
+		Channel: ch,
+		ID:      ev.ID,
+		From:    authorUser(ch, u),
+		Options: make(map[string]interface{}),
+		appID:   ev.ApplicationID,
+		token:   ev.Token,
+		client:  cl,
+	}
+	command := []string{ev.Data.Name}
+	opts := ev.Data.Options
+	for len(opts) > 0 {
+		o := opts[0]
+		if o.Type != OptionSubcommand && o.Type != OptionSubcommandGroup {
+			break
+		}
+		command = append(command, o.Name)
+		opts = o.Options
+	}
+	in.Command = strings.Join(command, " ")
+	for _, o := range opts {
+		in.Options[o.Name] = o.Value
+	}
+	send(ch, in)
+}
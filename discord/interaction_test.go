@@ -0,0 +1,121 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/velour/chat"
+)
+
+func TestInteraction(t *testing.T) {
+	f := newFakeLimits(func(w http.ResponseWriter, req *http.Request, n int) {
+		w.Write([]byte("{}"))
+	})
+	defer f.Close()
+	cl := newLimitsClient(f)
+	cl.userID = "1"
+	cl.joined = make(map[string]*Channel)
+	cl.userNames = make(map[string]string)
+	cl.rewriteNames = make(map[string]string)
+	cl.threads = make(map[string]string)
+	cl.nicks = make(map[string]string)
+	general := &Channel{cl: cl, id: "10", name: "general", guildID: "100"}
+	start(general)
+	defer stop(general)
+	cl.joined[general.id] = general
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	const interaction = `{
+		"id": "50",
+		"application_id": "1",
+		"type": 2,
+		"token": "tok",
+		"guild_id": "100",
+		"channel_id": "10",
+		"member": {"user": {"id": "2", "username": "alice", "avatar": "a"}, "nick": "Ali"},
+		"data": {
+			"id": "60",
+			"name": "link",
+			"options": [{
+				"name": "add",
+				"type": 1,
+				"options": [
+					{"name": "channel", "type": 7, "value": "11"},
+					{"name": "days", "type": 4, "value": 3},
+					{"name": "quiet", "type": 5, "value": true}
+				]
+			}]
+		}
+	}`
+	var data interface{}
+	if err := json.Unmarshal([]byte(interaction), &data); err != nil {
+		t.Fatalf("failed to unmarshal: %s", err)
+	}
+	// Pings and interactions in unjoined channels are ignored.
+	for _, d := range []string{`{"id":"51","type":1,"token":"tok","channel_id":"10"}`, `{"id":"52","type":2,"token":"tok","channel_id":"12","user":{"id":"2"},"data":{"name":"who"}}`} {
+		var data interface{}
+		if err := json.Unmarshal([]byte(d), &data); err != nil {
+			t.Fatalf("failed to unmarshal: %s", err)
+		}
+		if err := dispatchEvent(ctx, cl, "INTERACTION_CREATE", data); err != nil {
+			t.Fatalf("dispatchEvent(_, _, INTERACTION_CREATE, %s)=%v", d, err)
+		}
+	}
+	if err := dispatchEvent(ctx, cl, "INTERACTION_CREATE", data); err != nil {
+		t.Fatalf("dispatchEvent(_, _, INTERACTION_CREATE, _)=%v", err)
+	}
+	got, err := general.Receive(ctx)
+	if err != nil {
+		t.Fatalf("general.Receive(_)=_,%v", err)
+	}
+	want := Interaction{
+		Channel: general,
+		ID:      "50",
+		From: &chat.User{
+			ID:          "2",
+			Nick:        "alice",
+			FullName:    "alice",
+			DisplayName: "Ali",
+			PhotoURL:    cdnURL + "avatars/2/a.png",
+			Channel:     general,
+		},
+		Command: "link add",
+		Options: map[string]interface{}{"channel": "11", "days": 3.0, "quiet": true},
+		appID:   "1",
+		token:   "tok",
+		client:  cl,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	in := got.(Interaction)
+	if err := in.Reply(ctx, "linked", true); err != nil {
+		t.Errorf("in.Reply(_, linked, true)=%v", err)
+	}
+	if err := in.Defer(ctx, false); err != nil {
+		t.Errorf("in.Defer(_, false)=%v", err)
+	}
+	if err := in.EditReply(ctx, "done"); err != nil {
+		t.Errorf("in.EditReply(_, done)=%v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	wantBodies := map[string][]string{
+		"/api/v10/interactions/50/tok/callback": {
+			`{"type":4,"data":{"content":"linked","flags":64}}`,
+			`{"type":5,"data":{}}`,
+		},
+		"/api/v10/webhooks/1/tok/messages/@original": {
+			`{"content":"done"}`,
+		},
+	}
+	if !reflect.DeepEqual(f.bodies, wantBodies) {
+		t.Errorf("request bodies %q, want %q", f.bodies, wantBodies)
+	}
+}
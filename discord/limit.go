@@ -0,0 +1,314 @@
+package discord
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxErrRetries is the number of times a request is retried
+// after an internal server error.
+const maxErrRetries = 3
+
+// A limiter sends requests within the Discord REST rate limits.
+// See https://discord.com/developers/docs/topics/rate-limits.
+//
+// Each request belongs to a bucket,
+// and requests in the same bucket are sent one at a time,
+// waiting for the bucket to reset if its limit is exhausted.
+// Requests in different buckets are sent concurrently.
+//
+// Buckets are identified by the X-RateLimit-Bucket response header,
+// which may be shared by several routes,
+// and the major parameters of the request:
+// the channel, guild, or webhook and its token.
+// Until the bucket of a route is known, the route is its own bucket.
+// Idle buckets are evicted after they reset.
+type limiter struct {
+	client *http.Client
+
+	mu sync.Mutex
+	// hashes are the X-RateLimit-Bucket values by route.
+	hashes  map[string]string
+	buckets map[string]*bucket
+	// global is the time at which the global rate limit resets.
+	global time.Time
+	// swept is the last time that buckets were evicted.
+	swept time.Time
+}
+
+// sweepInterval is the minimum time between evictions of idle buckets.
+const sweepInterval = time.Minute
+
+// A bucket is the rate limit state of a bucket.
+type bucket struct {
+	// users is the number of requests using the bucket.
+	// It is guarded by the limiter mu.
+	users int
+
+	// sem is held while a request of the bucket is in flight.
+	sem chan struct{}
+
+	// remaining and reset are guarded by sem.
+	// remaining is the number of requests that may be sent before reset,
+	// and is -1 if unknown.
+	remaining int
+	reset     time.Time
+}
+
+func newLimiter(client *http.Client) *limiter {
+	return &limiter{
+		client:  client,
+		hashes:  make(map[string]string),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// do sends the request, waiting as needed for the rate limits.
+// The request is retried if it is rate limited,
+// and up to maxErrRetries times after an internal server error.
+func (l *limiter) do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	r := route(req)
+	major := majorParams(req)
+	b := l.bucket(r, major)
+	defer l.release(b)
+	select {
+	case b.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-b.sem }()
+
+	var errRetries int
+	for {
+		if b.remaining == 0 {
+			if err := sleepUntil(ctx, b.reset); err != nil {
+				return nil, err
+			}
+			b.remaining = -1
+		}
+		l.mu.Lock()
+		global := l.global
+		l.mu.Unlock()
+		if err := sleepUntil(ctx, global); err != nil {
+			return nil, err
+		}
+
+		resp, err := l.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		nb := l.update(r, major, b, resp)
+		if nb != b {
+			// The bucket of the route was learned from the response.
+			// Record the limits on both, but later requests use nb.
+			nb.sem <- struct{}{}
+			nb.remaining, nb.reset = b.remaining, b.reset
+			<-nb.sem
+		}
+		l.release(nb)
+
+		// backoff is the time to wait before retrying
+		// in addition to any rate limit.
+		var backoff time.Duration
+		switch {
+		case resp.StatusCode == http.StatusTooManyRequests:
+			retry, global := retryAfter(resp)
+			log.Printf("Discord rate limited %s (global=%v), retrying after %s\n", r, global, retry)
+			if global {
+				l.mu.Lock()
+				l.global = time.Now().Add(retry)
+				l.mu.Unlock()
+			} else {
+				b.remaining, b.reset = 0, time.Now().Add(retry)
+			}
+		case resp.StatusCode == http.StatusInternalServerError && errRetries < maxErrRetries:
+			ms := 500 * math.Pow(2, float64(errRetries))
+			backoff = time.Duration(ms) * time.Millisecond
+			errRetries++
+		default:
+			return resp, nil
+		}
+		if req.Body != nil {
+			if req.GetBody == nil {
+				// The request cannot be resent.
+				return resp, nil
+			}
+			if req.Body, err = req.GetBody(); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		resp.Body.Close()
+		if err := sleepUntil(ctx, time.Now().Add(backoff)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// bucket returns the current bucket of the route
+// with the major parameters.
+// The bucket must be released when the request is done with it.
+func (l *limiter) bucket(route, major string) *bucket {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now := time.Now(); now.Sub(l.swept) > sweepInterval {
+		l.sweep(now)
+	}
+	key := route
+	if hash, ok := l.hashes[route]; ok {
+		key = hash + ":" + major
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{sem: make(chan struct{}, 1), remaining: -1}
+		l.buckets[key] = b
+	}
+	b.users++
+	return b
+}
+
+// release releases a bucket returned by bucket.
+func (l *limiter) release(b *bucket) {
+	l.mu.Lock()
+	b.users--
+	l.mu.Unlock()
+}
+
+// sweep evicts the buckets that are not in use and have reset.
+// Their state is no longer needed:
+// an evicted bucket is recreated with unknown limits.
+// The limiter mu must be held.
+func (l *limiter) sweep(now time.Time) {
+	l.swept = now
+	for key, b := range l.buckets {
+		// A bucket with no users is not held,
+		// so its reset can be read without the sem.
+		if b.users == 0 && now.After(b.reset) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// update updates the bucket from the rate limit headers of the response,
+// and returns the bucket of the route named by the response.
+// The returned bucket must be released.
+func (l *limiter) update(route, major string, b *bucket, resp *http.Response) *bucket {
+	h := resp.Header
+	if n, err := strconv.Atoi(h.Get("X-RateLimit-Remaining")); err == nil {
+		b.remaining = n
+	}
+	if secs, err := strconv.ParseFloat(h.Get("X-RateLimit-Reset-After"), 64); err == nil {
+		b.reset = time.Now().Add(seconds(secs))
+	}
+	hash := h.Get("X-RateLimit-Bucket")
+	if hash != "" {
+		l.mu.Lock()
+		l.hashes[route] = hash
+		l.mu.Unlock()
+	}
+	return l.bucket(route, major)
+}
+
+// retryAfter returns the time to wait before retrying a rate limited request,
+// and whether the global rate limit was exceeded.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	var body struct {
+		RetryAfter float64 `json:"retry_after"`
+		Global     bool    `json:"global"`
+	}
+	if data, err := ioutil.ReadAll(resp.Body); err == nil {
+		json.Unmarshal(data, &body)
+	}
+	global := body.Global || resp.Header.Get("X-RateLimit-Global") == "true"
+	if body.RetryAfter > 0 {
+		return seconds(body.RetryAfter), global
+	}
+	if secs, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64); err == nil {
+		return seconds(secs), global
+	}
+	return time.Second, global
+}
+
+func seconds(secs float64) time.Duration {
+	return time.Duration(secs * float64(time.Second))
+}
+
+// sleepUntil sleeps until t or until the Context is done.
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// route returns the rate limit route of the request:
+// its method and path, with the IDs that are not major parameters
+// replaced by :id, and webhook and interaction tokens replaced by :token.
+// The major parameters are channel, guild, and webhook IDs.
+func route(req *http.Request) string {
+	path := strings.Split(req.URL.Path, "/")
+	elms := append([]string{}, path...)
+	for i, elm := range path {
+		if i == 0 {
+			continue
+		}
+		switch {
+		case isToken(path, i):
+			elms[i] = ":token"
+		case !isSnowflake(elm):
+			// Not an ID.
+		case path[i-1] == "channels", path[i-1] == "guilds", path[i-1] == "webhooks":
+			// A major parameter.
+		default:
+			elms[i] = ":id"
+		}
+	}
+	return req.Method + " " + strings.Join(elms, "/")
+}
+
+// isToken returns whether the ith element of a path
+// is a webhook or interaction token,
+// which follow the webhook or interaction ID.
+func isToken(elms []string, i int) bool {
+	if i < 2 || !isSnowflake(elms[i-1]) {
+		return false
+	}
+	return elms[i-2] == "webhooks" || elms[i-2] == "interactions"
+}
+
+// majorParams returns the major parameters of the request path:
+// its channel, guild, and webhook IDs, and webhook token,
+// which distinguish requests of the same bucket.
+func majorParams(req *http.Request) string {
+	var major []string
+	elms := strings.Split(req.URL.Path, "/")
+	for i := 1; i < len(elms); i++ {
+		switch elms[i-1] {
+		case "channels", "guilds":
+			major = append(major, elms[i])
+		case "webhooks":
+			major = append(major, elms[i])
+			if i+1 < len(elms) {
+				major = append(major, elms[i+1])
+			}
+		}
+	}
+	return strings.Join(major, "/")
+}
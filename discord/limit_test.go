@@ -0,0 +1,273 @@
+package discord
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoute(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"GET", "/api/v10/users/@me", "GET /api/v10/users/@me"},
+		{"GET", "/api/v10/users/123", "GET /api/v10/users/:id"},
+		{"GET", "/api/v10/channels/123", "GET /api/v10/channels/123"},
+		{"POST", "/api/v10/channels/123/messages", "POST /api/v10/channels/123/messages"},
+		{"PATCH", "/api/v10/channels/123/messages/456", "PATCH /api/v10/channels/123/messages/:id"},
+		{"DELETE", "/api/v10/channels/123/messages/456", "DELETE /api/v10/channels/123/messages/:id"},
+		{"GET", "/api/v10/guilds/123/members/456", "GET /api/v10/guilds/123/members/:id"},
+		{"POST", "/api/v10/webhooks/123/token", "POST /api/v10/webhooks/123/:token"},
+		{"PATCH", "/api/v10/webhooks/123/token/messages/456", "PATCH /api/v10/webhooks/123/:token/messages/:id"},
+		{"PATCH", "/api/v10/webhooks/123/token/messages/@original", "PATCH /api/v10/webhooks/123/:token/messages/@original"},
+		{"POST", "/api/v10/interactions/456/token/callback", "POST /api/v10/interactions/:id/:token/callback"},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, "http://test.com"+test.path, nil)
+		if err != nil {
+			panic(err)
+		}
+		if got := route(req); got != test.want {
+			t.Errorf("route(%s %s)=%s, want %s", test.method, test.path, got, test.want)
+		}
+	}
+}
+
+func TestMajorParams(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/v10/users/@me", ""},
+		{"/api/v10/channels/123/messages/456", "123"},
+		{"/api/v10/guilds/123/members/456", "123"},
+		{"/api/v10/webhooks/123/token", "123/token"},
+		{"/api/v10/interactions/456/token/callback", ""},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", "http://test.com"+test.path, nil)
+		if err != nil {
+			panic(err)
+		}
+		if got := majorParams(req); got != test.want {
+			t.Errorf("majorParams(%s)=%s, want %s", test.path, got, test.want)
+		}
+	}
+}
+
+func TestLimiterSweep(t *testing.T) {
+	l := newLimiter(http.DefaultClient)
+	now := time.Now()
+	l.swept = now
+	reset := l.bucket("reset", "")
+	reset.reset = now.Add(-time.Second)
+	l.release(reset)
+	inUse := l.bucket("in use", "")
+	inUse.reset = now.Add(-time.Second)
+	limited := l.bucket("limited", "")
+	limited.reset = now.Add(time.Hour)
+	l.release(limited)
+
+	l.mu.Lock()
+	l.sweep(now)
+	var keys []string
+	for key := range l.buckets {
+		keys = append(keys, key)
+	}
+	l.mu.Unlock()
+	sort.Strings(keys)
+	if want := []string{"in use", "limited"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("buckets after sweep %v, want %v", keys, want)
+	}
+	l.release(inUse)
+}
+
+// fakeLimits is a fake Discord API server with rate limits.
+type fakeLimits struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// arrivals are the arrival times of the requests by path.
+	arrivals map[string][]time.Time
+	// bodies are the bodies of the requests by path.
+	bodies map[string][]string
+}
+
+func (f *fakeLimits) arrived(path string) []time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]time.Time{}, f.arrivals[path]...)
+}
+
+func newFakeLimits(handler func(w http.ResponseWriter, req *http.Request, n int)) *fakeLimits {
+	f := &fakeLimits{
+		arrivals: make(map[string][]time.Time),
+		bodies:   make(map[string][]string),
+	}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		f.mu.Lock()
+		f.arrivals[req.URL.Path] = append(f.arrivals[req.URL.Path], time.Now())
+		f.bodies[req.URL.Path] = append(f.bodies[req.URL.Path], string(body))
+		n := len(f.arrivals[req.URL.Path])
+		f.mu.Unlock()
+		handler(w, req, n)
+	}))
+	return f
+}
+
+func newLimitsClient(f *fakeLimits) *Client {
+	return &Client{
+		token:   "token",
+		apiURL:  f.URL,
+		opts:    Options{}.withDefaults(),
+		limiter: newLimiter(f.Client()),
+	}
+}
+
+func limits(w http.ResponseWriter, bucket string, remaining int, resetAfter string) {
+	w.Header().Set("X-RateLimit-Bucket", bucket)
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset-After", resetAfter)
+	w.Write([]byte("{}"))
+}
+
+// minWait is the minimum time to wait for a reset after 0.2 seconds,
+// allowing for the clock granularity.
+const minWait = 150 * time.Millisecond
+
+func TestLimiterBucket(t *testing.T) {
+	release := make(chan struct{})
+	f := newFakeLimits(func(w http.ResponseWriter, req *http.Request, n int) {
+		switch req.URL.Path {
+		case "/api/v10/channels/1/messages":
+			limits(w, "messages", 0, "0.2")
+		case "/api/v10/channels/2/messages":
+			<-release
+			limits(w, "messages", 4, "1")
+		case "/api/v10/channels/3/messages":
+			limits(w, "messages", 4, "1")
+		case "/api/v10/guilds/1/roles":
+			limits(w, "guild", 1, "1")
+		case "/api/v10/guilds/1/channels":
+			limits(w, "guild", 0, "0.2")
+		default:
+			http.NotFound(w, req)
+		}
+	})
+	defer f.Close()
+	cl := newLimitsClient(f)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// A request waits for the reset of its exhausted bucket.
+	for i := 0; i < 2; i++ {
+		if err := cl.get(ctx, "channels/1/messages", nil); err != nil {
+			t.Fatalf("cl.get(channels/1/messages)=%v", err)
+		}
+	}
+	if a := f.arrived("/api/v10/channels/1/messages"); len(a) != 2 || a[1].Sub(a[0]) < minWait {
+		t.Errorf("channels/1/messages arrivals %v, want 2, 0.2s apart", a)
+	}
+
+	// Requests with different major parameters do not wait for each other.
+	blocked := make(chan error)
+	go func() { blocked <- cl.get(ctx, "channels/2/messages", nil) }()
+	for len(f.arrived("/api/v10/channels/2/messages")) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := cl.get(ctx, "channels/3/messages", nil); err != nil {
+		t.Errorf("cl.get(channels/3/messages)=%v", err)
+	}
+	close(release)
+	if err := <-blocked; err != nil {
+		t.Errorf("cl.get(channels/2/messages)=%v", err)
+	}
+
+	// Routes with the same bucket share its limit.
+	for _, method := range []string{"guilds/1/roles", "guilds/1/channels", "guilds/1/roles"} {
+		if err := cl.get(ctx, method, nil); err != nil {
+			t.Fatalf("cl.get(%s)=%v", method, err)
+		}
+	}
+	roles := f.arrived("/api/v10/guilds/1/roles")
+	channels := f.arrived("/api/v10/guilds/1/channels")
+	if len(roles) != 2 || len(channels) != 1 || roles[1].Sub(channels[0]) < minWait {
+		t.Errorf("guilds/1/roles arrivals %v, guilds/1/channels arrivals %v, want roles 0.2s after channels", roles, channels)
+	}
+}
+
+func TestLimiterTooManyRequests(t *testing.T) {
+	limited := make(chan struct{})
+	f := newFakeLimits(func(w http.ResponseWriter, req *http.Request, n int) {
+		switch {
+		case req.URL.Path == "/api/v10/channels/1/messages" && n == 1:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.2, "global": true}`))
+			close(limited)
+		case req.URL.Path == "/api/v10/channels/2/messages" && n == 1:
+			w.Header().Set("Retry-After", "0.2")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte("{}"))
+		}
+	})
+	defer f.Close()
+	cl := newLimitsClient(f)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// A request is retried after the global limit,
+	// and requests in other buckets wait for the global limit.
+	sent := make(chan error)
+	req := messageRequest{Content: "hello"}
+	go func() { sent <- cl.post(ctx, "channels/1/messages", req, nil) }()
+	<-limited
+	for {
+		cl.limiter.mu.Lock()
+		global := cl.limiter.global
+		cl.limiter.mu.Unlock()
+		if !global.IsZero() {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := cl.get(ctx, "users/@me", nil); err != nil {
+		t.Errorf("cl.get(users/@me)=%v", err)
+	}
+	if err := <-sent; err != nil {
+		t.Errorf("cl.post(channels/1/messages)=%v", err)
+	}
+	messages := f.arrived("/api/v10/channels/1/messages")
+	me := f.arrived("/api/v10/users/@me")
+	if len(messages) != 2 || messages[1].Sub(messages[0]) < minWait {
+		t.Errorf("channels/1/messages arrivals %v, want 2, 0.2s apart", messages)
+	}
+	if len(me) != 1 || me[0].Sub(messages[0]) < minWait {
+		t.Errorf("users/@me arrivals %v, want 0.2s after %v", me, messages[0])
+	}
+	f.mu.Lock()
+	bodies := f.bodies["/api/v10/channels/1/messages"]
+	f.mu.Unlock()
+	if len(bodies) != 2 || bodies[0] != bodies[1] || bodies[1] == "" {
+		t.Errorf("channels/1/messages bodies %q, want 2 of the same", bodies)
+	}
+
+	// A request is retried after a bucket limit.
+	if err := cl.get(ctx, "channels/2/messages", nil); err != nil {
+		t.Errorf("cl.get(channels/2/messages)=%v", err)
+	}
+	if a := f.arrived("/api/v10/channels/2/messages"); len(a) != 2 || a[1].Sub(a[0]) < minWait {
+		t.Errorf("channels/2/messages arrivals %v, want 2, 0.2s apart", a)
+	}
+}
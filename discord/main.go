@@ -10,7 +10,6 @@ import (
 	"io"
 	"log"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/eaburns/pretty"
@@ -20,7 +19,7 @@ import (
 
 var (
 	token   = flag.String("token", "", "The bot's super secret token")
-	channel = flag.String("channel", "", "server:channel")
+	channel = flag.String("channel", "", "channel ID, server/channel, or user ID")
 )
 
 func main() {
@@ -29,11 +28,6 @@ func main() {
 		fmt.Println("need a token")
 		os.Exit(1)
 	}
-	serverChan := strings.Split(*channel, ":")
-	if *channel != "" && len(serverChan) != 2 {
-		fmt.Println("malformed -channel=<server:channel>")
-		os.Exit(1)
-	}
 
 	ctx := context.Background()
 	cl, err := discord.Dial(ctx, *token)
@@ -42,10 +36,10 @@ func main() {
 		os.Exit(1)
 	}
 
-	if len(serverChan) == 2 {
+	if *channel != "" {
 		go func() {
-			fmt.Println("joining", serverChan[0], serverChan[1])
-			ch, err := cl.Join(ctx, serverChan[0], serverChan[1])
+			fmt.Println("joining", *channel)
+			ch, err := cl.Join(ctx, *channel)
 			if err != nil {
 				fmt.Println("failed to join", *channel, err)
 				return
@@ -0,0 +1,250 @@
+package discord
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxGuilds is the maximum number of guilds
+	// for which to remember roles and channels.
+	maxGuilds = 100
+	// guildTTL is how long to remember the roles and channels of a guild
+	// without an update from the gateway.
+	guildTTL = time.Hour
+)
+
+// now returns the current time; it is replaced in tests.
+var now = time.Now
+
+// decodeMarkup returns the text with Discord markup tokens
+// replaced by their plain-text rendering:
+//
+//	<@id> and <@!id> user mentions become @name.
+//	<@&id> role mentions become @role.
+//	<#id> channel mentions become #channel.
+//	</name:id> command mentions become /name.
+//	<:name:id> and <a:name:id> custom emoji become :name:.
+//	<t:unix> and <t:unix:style> timestamps become the formatted time.
+//
+// Tokens in code spans and code blocks are not decoded.
+func decodeMarkup(ctx context.Context, ch *Channel, text string) string {
+	var s strings.Builder
+	for {
+		i := strings.IndexAny(text, "<`")
+		if i < 0 {
+			break
+		}
+		s.WriteString(text[:i])
+		text = text[i:]
+
+		if text[0] == '`' {
+			delim := "`"
+			if strings.HasPrefix(text, "```") {
+				delim = "```"
+			}
+			j := strings.Index(text[len(delim):], delim)
+			if j < 0 {
+				// An unclosed code span is not a code span.
+				s.WriteString(delim)
+				text = text[len(delim):]
+				continue
+			}
+			j += 2 * len(delim)
+			s.WriteString(text[:j])
+			text = text[j:]
+			continue
+		}
+
+		j := strings.IndexByte(text, '>')
+		if j < 0 {
+			break
+		}
+		if r, ok := decodeToken(ctx, ch, text[1:j]); ok {
+			s.WriteString(r)
+			text = text[j+1:]
+		} else {
+			s.WriteByte('<')
+			text = text[1:]
+		}
+	}
+	return s.String() + text
+}
+
+// decodeToken returns the rendering of the markup token
+// between < and >, and whether it is a valid token.
+func decodeToken(ctx context.Context, ch *Channel, token string) (string, bool) {
+	switch {
+	case strings.HasPrefix(token, "@&"):
+		id := token[2:]
+		if !isSnowflake(id) {
+			return "", false
+		}
+		return "@" + guildName(ctx, ch, "roles", id, "deleted-role"), true
+
+	case strings.HasPrefix(token, "@"):
+		id := strings.TrimPrefix(token[1:], "!")
+		if !isSnowflake(id) {
+			return "", false
+		}
+		name, err := userName(ctx, ch.cl, id)
+		if err != nil {
+			log.Printf("failed to decode @-mention for %s: %s", id, err)
+			return "@unknown-user", true
+		}
+		ch.cl.mu.Lock()
+		name = displayName(ch.cl, name, ch.cl.nicks[ch.guildID+"/"+id])
+		ch.cl.mu.Unlock()
+		return "@" + name, true
+
+	case strings.HasPrefix(token, "#"):
+		id := token[1:]
+		if !isSnowflake(id) {
+			return "", false
+		}
+		return "#" + guildName(ctx, ch, "channels", id, "deleted-channel"), true
+
+	case strings.HasPrefix(token, "/"):
+		// Command names may have spaces for subcommands.
+		i := strings.LastIndexByte(token, ':')
+		if i < 0 || !isSnowflake(token[i+1:]) {
+			return "", false
+		}
+		return token[:i], true
+
+	case strings.HasPrefix(token, "t:"):
+		parts := strings.Split(token, ":")
+		if len(parts) > 3 {
+			return "", false
+		}
+		sec, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return "", false
+		}
+		style := "f"
+		if len(parts) == 3 {
+			style = parts[2]
+		}
+		return formatTime(time.Unix(sec, 0), style)
+
+	case strings.HasPrefix(token, ":") || strings.HasPrefix(token, "a:"):
+		parts := strings.Split(strings.TrimPrefix(token, "a"), ":")
+		if len(parts) != 3 || parts[1] == "" || !isSnowflake(parts[2]) {
+			return "", false
+		}
+		return ":" + parts[1] + ":", true
+	}
+	return "", false
+}
+
+// formatTime returns the rendering of a timestamp with a Discord timestamp style.
+func formatTime(t time.Time, style string) (string, bool) {
+	t = t.UTC()
+	switch style {
+	case "t":
+		return t.Format("3:04 PM MST"), true
+	case "T":
+		return t.Format("3:04:05 PM MST"), true
+	case "d":
+		return t.Format("01/02/2006"), true
+	case "D":
+		return t.Format("January 2, 2006"), true
+	case "f":
+		return t.Format("January 2, 2006 3:04 PM MST"), true
+	case "F":
+		return t.Format("Monday, January 2, 2006 3:04 PM MST"), true
+	case "R":
+		return relativeTime(t.Sub(now())), true
+	}
+	return "", false
+}
+
+// relativeTime returns a rendering of the duration from now,
+// such as "in 5 minutes" or "3 days ago".
+func relativeTime(d time.Duration) string {
+	units := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+		{"second", time.Second},
+	}
+	abs := d
+	if abs < 0 {
+		abs = -abs
+	}
+	n, name := 0, "second"
+	for _, u := range units {
+		if abs >= u.d {
+			n = int(math.Round(float64(abs) / float64(u.d)))
+			name = u.name
+			break
+		}
+	}
+	s := strconv.Itoa(n) + " " + name
+	if n != 1 {
+		s += "s"
+	}
+	if d < 0 {
+		return s + " ago"
+	}
+	return "in " + s
+}
+
+// guildName returns the name of a role or channel of the Channel's guild,
+// where kind is either "roles" or "channels".
+// If the name is not found, missing is returned.
+func guildName(ctx context.Context, ch *Channel, kind, id, missing string) string {
+	if ch.guildID == "" {
+		// DM channels have no guild.
+		return missing
+	}
+	names, err := guildNames(ctx, ch.cl, ch.guildID, kind)
+	if err != nil {
+		log.Printf("failed to get %s of guild %s: %s", kind, ch.guildID, err)
+		return missing
+	}
+	if name, ok := names[id]; ok {
+		return name
+	}
+	if kind == "channels" {
+		// Threads are not listed with the guild channels.
+		var th thread
+		if err := ch.cl.get(ctx, "channels/"+id, &th); err == nil && th.Name != "" {
+			return th.Name
+		}
+	}
+	return missing
+}
+
+// guildNames returns the names of the roles or channels of a guild, by ID,
+// where kind is either "roles" or "channels".
+func guildNames(ctx context.Context, cl *Client, guildID, kind string) (map[string]string, error) {
+	v, err := cl.guilds.Fetch(ctx, kind+"/"+guildID, func(ctx context.Context) (interface{}, error) {
+		var nids []idAndName
+		if err := cl.get(ctx, "guilds/"+guildID+"/"+kind, &nids); err != nil {
+			return nil, err
+		}
+		return namesByID(nids), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]string), nil
+}
+
+func namesByID(nids []idAndName) map[string]string {
+	names := make(map[string]string, len(nids))
+	for _, x := range nids {
+		names[x.ID] = x.Name
+	}
+	return names
+}
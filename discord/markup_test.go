@@ -0,0 +1,77 @@
+package discord
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/velour/chat/cache"
+)
+
+func TestDecodeMarkup(t *testing.T) {
+	defer func(orig func() time.Time) { now = orig }(now)
+	now = func() time.Time { return time.Unix(1618935600, 0) } // April 20, 2021 4:20 PM UTC
+
+	cl := &Client{
+		userNames:    map[string]string{"2": "alice", "3": "bob", "4": "carol"},
+		nicks:        map[string]string{"100/3": "Bobby"},
+		rewriteNames: map[string]string{"carol": "Caroline"},
+		guilds:       cache.New(0, 0),
+	}
+	cl.guilds.Put("roles/100", map[string]string{"20": "admins"})
+	cl.guilds.Put("channels/100", map[string]string{"10": "general"})
+	ch := &Channel{cl: cl, guildID: "100"}
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"no markup", "no markup"},
+		{"hi <@2>", "hi @alice"},
+		{"hi <@!2>!", "hi @alice!"},
+		{"<@3> and <@4>", "@Bobby and @Caroline"},
+		{"<@&20> help", "@admins help"},
+		{"<@&21>", "@deleted-role"},
+		{"see <#10>", "see #general"},
+		{"try </roll:30>", "try /roll"},
+		{"try </role add:30>", "try /role add"},
+		{"nice <:blobcat:40>", "nice :blobcat:"},
+		{"nice <a:party:41>", "nice :party:"},
+		{"<t:1618935600>", "April 20, 2021 4:20 PM UTC"},
+		{"<t:1618935600:t>", "4:20 PM UTC"},
+		{"<t:1618935600:T>", "4:20:00 PM UTC"},
+		{"<t:1618935600:d>", "04/20/2021"},
+		{"<t:1618935600:D>", "April 20, 2021"},
+		{"<t:1618935600:f>", "April 20, 2021 4:20 PM UTC"},
+		{"<t:1618935600:F>", "Tuesday, April 20, 2021 4:20 PM UTC"},
+		{"<t:1618942800:R>", "in 2 hours"},
+		{"<t:1618676400:R>", "3 days ago"},
+		{"<t:1618935601:R>", "in 1 second"},
+
+		// Invalid tokens are left as they are.
+		{"<t:1618935600:x>", "<t:1618935600:x>"},
+		{"<t:soon>", "<t:soon>"},
+		{"<@alice>", "<@alice>"},
+		{"<#general>", "<#general>"},
+		{"<:blobcat:>", "<:blobcat:>"},
+		{"</roll>", "</roll>"},
+		{"a < b", "a < b"},
+		{"a <b> c", "a <b> c"},
+		{"a < b <@2>", "a < b @alice"},
+		{"1 < 2 > 0", "1 < 2 > 0"},
+		{"<", "<"},
+		{"<@2", "<@2"},
+
+		// Code is not decoded.
+		{"`<@2>` <@2>", "`<@2>` @alice"},
+		{"```\n<@2>\n``` <@2>", "```\n<@2>\n``` @alice"},
+		{"unclosed ` <@2>", "unclosed ` @alice"},
+	}
+	ctx := context.Background()
+	for _, test := range tests {
+		if got := decodeMarkup(ctx, ch, test.text); got != test.want {
+			t.Errorf("decodeMarkup(%q)=%q, want %q", test.text, got, test.want)
+		}
+	}
+}
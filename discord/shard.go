@@ -0,0 +1,74 @@
+package discord
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// identifyInterval is the time between identifying
+// batches of max_concurrency shards.
+const identifyInterval = 5 * time.Second
+
+// gatewayBot is the response of the gateway/bot method.
+type gatewayBot struct {
+	URL string `json:"url"`
+	// Shards is the recommended number of shards.
+	Shards            int `json:"shards"`
+	SessionStartLimit struct {
+		// MaxConcurrency is the number of shards
+		// that may identify every identifyInterval.
+		MaxConcurrency int `json:"max_concurrency"`
+	} `json:"session_start_limit"`
+}
+
+// shardCount returns the number of shards to run:
+// the Options.Shards, if non-zero,
+// and otherwise the number recommended by Discord.
+func shardCount(opts Options, gw gatewayBot) int {
+	switch {
+	case opts.Shards > 0:
+		return opts.Shards
+	case gw.Shards > 0:
+		return gw.Shards
+	default:
+		return 1
+	}
+}
+
+// runShards runs a session for each of n shards,
+// starting them at the rate allowed by Discord.
+// When all shards are ready, ready is closed.
+// If a shard fails to start, its error is sent on ready,
+// and all shards are stopped.
+// When all shards are stopped, cl.backgroundDone is closed.
+func runShards(ctx context.Context, cl *Client, n, maxConcurrency int, ready chan<- error) {
+	defer close(cl.backgroundDone)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+	for i := 0; i < n; i++ {
+		if i > 0 && i%maxConcurrency == 0 {
+			if err := sleepUntil(ctx, time.Now().Add(identifyInterval)); err != nil {
+				cl.cancelBackground()
+				ready <- err
+				return
+			}
+		}
+		shardReady := make(chan error, 1)
+		shard := [2]int{i, n}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runWithRetry(ctx, cl, shard, shardReady)
+		}()
+		if err, ok := <-shardReady; ok {
+			cl.cancelBackground()
+			ready <- err
+			return
+		}
+	}
+	close(ready)
+}
@@ -0,0 +1,88 @@
+package discord
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// A thread is a thread channel.
+type thread struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	ParentID string `json:"parent_id"`
+}
+
+// threadsResponse is the response of the thread listing methods.
+type threadsResponse struct {
+	Threads []thread `json:"threads"`
+}
+
+// splitThread splits a channel name of the form <channel>/<thread>
+// into the channel and thread names.
+// If the name has no /, the thread name is empty.
+func splitThread(name string) (string, string) {
+	i := strings.IndexByte(name, '/')
+	if i < 0 {
+		return name, ""
+	}
+	return name[:i], name[i+1:]
+}
+
+// findThread returns the ID of the named thread in the channel.
+// The thread may be active or archived, but archived private threads are not found.
+func findThread(ctx context.Context, cl *Client, guildID, parentID, name string) (string, error) {
+	var active threadsResponse
+	if err := cl.get(ctx, "guilds/"+guildID+"/threads/active", &active); err != nil {
+		return "", err
+	}
+	for _, th := range active.Threads {
+		if th.ParentID == parentID && th.Name == name {
+			return th.ID, nil
+		}
+	}
+	var archived threadsResponse
+	if err := cl.get(ctx, "channels/"+parentID+"/threads/archived/public", &archived); err != nil {
+		return "", err
+	}
+	for _, th := range archived.Threads {
+		if th.Name == name {
+			return th.ID, nil
+		}
+	}
+	return "", errors.New("thread " + name + " not found")
+}
+
+// addThreads records the parent channels of threads.
+func addThreads(cl *Client, threads []thread) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for _, th := range threads {
+		if th.ParentID != "" {
+			cl.threads[th.ID] = th.ParentID
+		}
+	}
+}
+
+// removeThread forgets the parent channel of a deleted thread.
+func removeThread(cl *Client, id string) {
+	cl.mu.Lock()
+	delete(cl.threads, id)
+	cl.mu.Unlock()
+}
+
+// messageChannel returns the joined Channel for messages in the channel with the ID.
+// If the channel is a thread that is not joined, but its parent is joined,
+// its messages are routed to the parent Channel.
+func messageChannel(cl *Client, id string) (*Channel, bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if ch, ok := cl.joined[id]; ok {
+		return ch, true
+	}
+	if parentID, ok := cl.threads[id]; ok {
+		ch, ok := cl.joined[parentID]
+		return ch, ok
+	}
+	return nil, false
+}
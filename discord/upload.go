@@ -0,0 +1,236 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/velour/chat"
+)
+
+const megabyte = 1 << 20
+
+// maxUploadSize is the largest total size, in bytes,
+// of the files uploaded with a message.
+// Larger attachments are sent as links.
+var maxUploadSize int64 = 25 * megabyte
+
+var errTooLarge = errors.New("file too large")
+
+// An attachment is a file attached to a message.
+type attachment struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+// An embed is embedded content of a message,
+// such as a link preview or rich content sent by a bot.
+type embed struct {
+	Type        string      `json:"type"`
+	Title       string      `json:"title"`
+	Description string      `json:"description"`
+	URL         string      `json:"url"`
+	Image       *embedMedia `json:"image"`
+}
+
+type embedMedia struct {
+	URL string `json:"url"`
+}
+
+// An upload is a file to upload with a message.
+type upload struct {
+	name string
+	data []byte
+}
+
+// attachmentText returns the text and Attachments of a message
+// with the message's attachments and embeds.
+//
+// Each attachment is a line of the form "/me shared a file: <url>",
+// before the text of the message.
+// Embeds are rendered as lines after the text,
+// except for link previews of URLs in the text.
+func attachmentText(text string, ev *event) (string, []chat.Attachment) {
+	var lines []string
+	var attachments []chat.Attachment
+	for _, a := range ev.Attachments {
+		what := "a file"
+		if strings.HasPrefix(a.ContentType, "image/") {
+			what = "an image"
+		}
+		lines = append(lines, "/me shared "+what+": "+a.URL)
+		attachments = append(attachments, chat.Attachment{
+			URL:      a.URL,
+			Name:     a.Filename,
+			MimeType: a.ContentType,
+			Size:     a.Size,
+		})
+	}
+	if text != "" {
+		lines = append(lines, text)
+	}
+	for _, e := range ev.Embeds {
+		if e.URL != "" && strings.Contains(text, e.URL) {
+			continue
+		}
+		var title []string
+		if e.Title != "" {
+			title = append(title, e.Title)
+		}
+		if e.URL != "" {
+			title = append(title, e.URL)
+		}
+		if len(title) > 0 {
+			lines = append(lines, strings.Join(title, ": "))
+		}
+		if e.Description != "" {
+			lines = append(lines, e.Description)
+		}
+		if e.Image != nil && e.Image.URL != "" {
+			lines = append(lines, e.Image.URL)
+		}
+	}
+	return strings.Join(lines, "\n"), attachments
+}
+
+// uploadText returns the text of a message with uploaded attachments.
+// It is the message text, with the attachment URLs removed,
+// along with the ": " before them in lines like "/me shared a file: <url>".
+func uploadText(m chat.Message) string {
+	for _, a := range m.Attachments {
+		m.Text = strings.Replace(m.Text, ": "+a.URL, "", -1)
+		m.Text = strings.Replace(m.Text, a.URL, "", -1)
+	}
+	var lines []string
+	for _, line := range strings.Split(m.Text, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// editContent returns the content of an edited message.
+// A message with Attachments was sent with its uploadText,
+// so the edit uses the uploadText too.
+func editContent(ch *Channel, m chat.Message) string {
+	if len(m.Attachments) > 0 {
+		m.Text = uploadText(m)
+	}
+	return content(ch, &m)
+}
+
+// downloadAttachments downloads the attachments of a message.
+// If any attachment cannot be downloaded,
+// or the total size is larger than maxUploadSize, an error is returned.
+func downloadAttachments(ctx context.Context, attachments []chat.Attachment) ([]upload, error) {
+	var uploads []upload
+	var size int64
+	for _, a := range attachments {
+		u, err := download(ctx, a, maxUploadSize-size)
+		if err != nil {
+			return nil, err
+		}
+		size += int64(len(u.data))
+		uploads = append(uploads, u)
+	}
+	return uploads, nil
+}
+
+// download downloads an attachment.
+// If the attachment is larger than max bytes, errTooLarge is returned.
+func download(ctx context.Context, a chat.Attachment, max int64) (upload, error) {
+	if a.Size > max {
+		return upload{}, errTooLarge
+	}
+	req, err := http.NewRequest(http.MethodGet, a.URL, nil)
+	if err != nil {
+		return upload{}, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return upload{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return upload{}, errors.New(resp.Status)
+	}
+	if resp.ContentLength > max {
+		return upload{}, errTooLarge
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return upload{}, err
+	}
+	if int64(len(data)) > max {
+		return upload{}, errTooLarge
+	}
+	name := a.Name
+	if name == "" {
+		if u, err := url.Parse(a.URL); err == nil {
+			name = path.Base(u.Path)
+		}
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+	return upload{name: name, data: data}, nil
+}
+
+// postFiles posts a multipart/form-data request with the JSON payload
+// and the uploaded files.
+// The payload must be a *messageRequest; its Attachments are set to the files.
+func (cl *Client) postFiles(ctx context.Context, method string, payload *messageRequest, files []upload, resp interface{}) error {
+	payload.Attachments = nil
+	for i, f := range files {
+		payload.Attachments = append(payload.Attachments, attachment{
+			ID:       strconv.Itoa(i),
+			Filename: f.name,
+		})
+	}
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err := w.WriteField("payload_json", string(payloadJSON)); err != nil {
+		return err
+	}
+	for i, f := range files {
+		fw, err := w.CreateFormFile("files["+strconv.Itoa(i)+"]", f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	httpReq, err := cl.newRequest(http.MethodPost, method, nil)
+	if err != nil {
+		return err
+	}
+	httpReq.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body.Bytes())), nil
+	}
+	httpReq.Body, _ = httpReq.GetBody()
+	httpReq.ContentLength = int64(body.Len())
+	httpReq.Header.Set("Content-Type", w.FormDataContentType())
+	return cl.do(ctx, httpReq, resp)
+}
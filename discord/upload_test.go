@@ -0,0 +1,28 @@
+package discord
+
+import (
+	"testing"
+
+	"github.com/velour/chat"
+)
+
+func TestUploadText(t *testing.T) {
+	a := []chat.Attachment{{URL: "http://x/a.png"}, {URL: "http://x/b.png"}}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"hello", "hello"},
+		{"/me shared a photo: http://x/a.png", "/me shared a photo"},
+		{"/me shared a photo: http://x/a.png\nmy cat", "/me shared a photo\nmy cat"},
+		{"http://x/a.png http://x/b.png", ""},
+		{"look: http://x/a.png\n\nnice", "look\nnice"},
+		{"Agenda:\n/me shared a photo: http://x/a.png", "Agenda:\n/me shared a photo"},
+	}
+	for _, test := range tests {
+		if got := uploadText(chat.Message{Text: test.text, Attachments: a}); got != test.want {
+			t.Errorf("uploadText(%q)=%q, want %q", test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package discord
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/velour/chat"
+)
+
+// maxWebhookName is the maximum length of a webhook message's username.
+const maxWebhookName = 80
+
+// A webhook is a Discord channel webhook,
+// used to send messages with the name and avatar of their sender.
+type webhook struct {
+	ID    string `json:"id"`
+	Token string `json:"token"`
+	Name  string `json:"name"`
+	User  *user  `json:"user"`
+}
+
+// UseWebhooks sets whether channels joined after the call
+// send bridged messages through a channel webhook.
+//
+// Messages sent through the webhook have the name and avatar of their sender,
+// instead of being sent by the bot with the sender's name as a prefix.
+// The bot must have the Manage Webhooks permission in the channel.
+func (cl *Client) UseWebhooks(b bool) {
+	cl.mu.Lock()
+	cl.webhooks = b
+	cl.mu.Unlock()
+}
+
+// channelWebhook returns a webhook for the channel,
+// reusing a webhook created by the bot if there is one,
+// and otherwise creating a new one.
+func channelWebhook(ctx context.Context, cl *Client, chID string) (*webhook, error) {
+	var hooks []webhook
+	if err := cl.get(ctx, "channels/"+chID+"/webhooks", &hooks); err != nil {
+		return nil, err
+	}
+	for _, h := range hooks {
+		if h.Token != "" && h.User != nil && h.User.ID == cl.userID {
+			return &h, nil
+		}
+	}
+	req := struct {
+		Name string `json:"name"`
+	}{
+		Name: cl.userName,
+	}
+	var h webhook
+	if err := cl.post(ctx, "channels/"+chID+"/webhooks", req, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// viaWebhook returns whether a message from the user is sent through the webhook.
+// Messages from the bot itself are sent as the bot.
+func (ch *Channel) viaWebhook(u *chat.User) bool {
+	return ch.webhook != nil && from(ch, u) != ""
+}
+
+// webhookMethod returns the API method for the path suffix of the webhook,
+// with the query parameters, and the thread_id parameter if the Channel is a thread.
+func (ch *Channel) webhookMethod(suffix string, q url.Values) string {
+	if q == nil {
+		q = make(url.Values)
+	}
+	if ch.parentID != "" {
+		q.Set("thread_id", ch.id)
+	}
+	method := "webhooks/" + ch.webhook.ID + "/" + ch.webhook.Token + suffix
+	if len(q) > 0 {
+		method += "?" + q.Encode()
+	}
+	return method
+}
+
+func editWebhook(ctx context.Context, ch *Channel, m chat.Message) (chat.Message, error) {
+	req := struct {
+		Content string `json:"content"`
+	}{
+		Content: editContent(ch, m),
+	}
+	var ev event // Message type
+	err := ch.cl.patch(ctx, ch.webhookMethod("/messages/"+string(m.ID), nil), req, &ev)
+	if err != nil {
+		if code, ok := err.(httpErr); ok && code == http.StatusNotFound {
+			return m, nil
+		}
+		return chat.Message{}, err
+	}
+	m.ID = chat.MessageID(ev.ID)
+	return m, nil
+}
+
+func deleteWebhook(ctx context.Context, ch *Channel, m chat.Message) error {
+	ch.cl.mu.Lock()
+	ch.cl.deletes[string(m.ID)] = true
+	ch.cl.mu.Unlock()
+	err := ch.cl.del(ctx, ch.webhookMethod("/messages/"+string(m.ID), nil))
+	if code, ok := err.(httpErr); ok && code == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// reservedName matches the words that Discord does not allow in webhook usernames.
+var reservedName = regexp.MustCompile(`(?i)(c)(lyde)|(d)(iscord)`)
+
+// webhookName returns the webhook username for a user.
+func webhookName(u *chat.User) string {
+	name := u.DisplayName
+	if name == "" {
+		name = u.Nick
+	}
+	// Break up reserved words with a zero-width space.
+	name = reservedName.ReplaceAllString(name, "$1$3\u200b$2$4")
+	if utf8.RuneCountInString(name) > maxWebhookName {
+		name = string([]rune(name)[:maxWebhookName])
+	}
+	return name
+}
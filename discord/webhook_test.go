@@ -0,0 +1,119 @@
+package discord
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/velour/chat"
+)
+
+func TestContent(t *testing.T) {
+	cl := &Client{userID: "1", userName: "bot"}
+	plain := &Channel{cl: cl}
+	hooked := &Channel{cl: cl, webhook: &webhook{ID: "2", Token: "t"}}
+	alice := &chat.User{ID: "3", DisplayName: "Alice"}
+	bot := &chat.User{ID: "1", DisplayName: "bot"}
+
+	tests := []struct {
+		ch   *Channel
+		msg  chat.Message
+		want string
+	}{
+		{plain, chat.Message{From: alice, Text: "hi"}, "**Alice**: hi\n"},
+		{plain, chat.Message{From: alice, Text: "/me waves"}, "_Alice_ _waves_\n"},
+		{hooked, chat.Message{From: alice, Text: "hi\nthere"}, "hi\nthere\n"},
+		{hooked, chat.Message{From: alice, Text: "/me waves"}, "_waves_\n"},
+		{
+			hooked,
+			chat.Message{From: alice, Text: "yes", ReplyTo: &chat.Message{From: alice, Text: "hi"}},
+			"_Alice said_: `hi`\nyes\n",
+		},
+		{hooked, chat.Message{Text: "hi"}, "hi\n"},
+		{
+			plain,
+			chat.Message{From: alice, Text: "yes", ReplyTo: &chat.Message{ID: "123", From: alice, Text: "hi"}},
+			"**Alice**: yes\n",
+		},
+		{
+			plain,
+			chat.Message{From: alice, Text: "yes", ReplyTo: &chat.Message{ID: "not-discord", From: alice, Text: "hi"}},
+			"**Alice**: _Alice said_: `hi`\n**Alice**: yes\n",
+		},
+		{
+			hooked,
+			chat.Message{From: alice, Text: "yes", ReplyTo: &chat.Message{ID: "123", From: alice, Text: "hi"}},
+			"_Alice said_: `hi`\nyes\n",
+		},
+	}
+	for _, test := range tests {
+		if got := content(test.ch, &test.msg); got != test.want {
+			t.Errorf("content(_, %#v)=%q, want %q", test.msg, got, test.want)
+		}
+	}
+
+	bot.Channel = hooked
+	if hooked.viaWebhook(bot) {
+		t.Errorf("hooked.viaWebhook(bot)=true, want false")
+	}
+	if !hooked.viaWebhook(alice) {
+		t.Errorf("hooked.viaWebhook(alice)=false, want true")
+	}
+}
+
+func TestWebhookName(t *testing.T) {
+	long := "abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabcdefghij"
+	tests := []struct {
+		user chat.User
+		want string
+	}{
+		{chat.User{DisplayName: "Alice", Nick: "alice"}, "Alice"},
+		{chat.User{Nick: "alice"}, "alice"},
+		{chat.User{DisplayName: "Discord Fan"}, "D\u200biscord Fan"},
+		{chat.User{DisplayName: "clyde"}, "c\u200blyde"},
+		{chat.User{DisplayName: long}, long[:80]},
+	}
+	for _, test := range tests {
+		if got := webhookName(&test.user); got != test.want {
+			t.Errorf("webhookName(%#v)=%q, want %q", test.user, got, test.want)
+		}
+	}
+}
+
+func TestEdit(t *testing.T) {
+	f := newFakeLimits(func(w http.ResponseWriter, req *http.Request, n int) {
+		w.Write([]byte(`{"id":"5"}`))
+	})
+	defer f.Close()
+	cl := newLimitsClient(f)
+	cl.userID = "1"
+	plain := &Channel{cl: cl, id: "10"}
+	hooked := &Channel{cl: cl, id: "11", webhook: &webhook{ID: "2", Token: "t"}}
+	alice := &chat.User{ID: "3", DisplayName: "Alice"}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Edits of messages with uploaded attachments omit the attachment URLs.
+	m := chat.Message{
+		ID:          "5",
+		From:        alice,
+		Text:        "/me shared a photo: http://x/a.png\nmy cat",
+		Attachments: []chat.Attachment{{URL: "http://x/a.png"}},
+	}
+	for _, ch := range []*Channel{plain, hooked} {
+		if _, err := ch.Edit(ctx, m); err != nil {
+			t.Errorf("ch.Edit(…)=_,%v", err)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	want := map[string][]string{
+		"/api/v10/channels/10/messages/5":  {`{"content":"_Alice_ _shared a photo_\n**Alice**: my cat\n"}`},
+		"/api/v10/webhooks/2/t/messages/5": {`{"content":"_shared a photo_\nmy cat\n"}`},
+	}
+	if !reflect.DeepEqual(f.bodies, want) {
+		t.Errorf("request bodies %q, want %q", f.bodies, want)
+	}
+}
@@ -0,0 +1,235 @@
+package slack
+
+import (
+	"encoding/json"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// A textFixer converts Slack message text into its display form.
+type textFixer struct {
+	// findUser maps a user ID to the user's nick.
+	findUser func(string) (string, bool)
+	// findChannel maps a channel ID to the channel's name.
+	findChannel func(string) (string, bool)
+	// findEmoji maps a custom emoji name to its UTF-8, if it has one.
+	findEmoji func(string) (string, bool)
+}
+
+func (f textFixer) fix(text string) string {
+	return fixText(f.findUser, f.findEmoji, html.UnescapeString(text))
+}
+
+// hasLayout returns whether the blocks contain any non-rich text blocks.
+//
+// Messages typed by a user contain a rich_text block
+// that duplicates the message text.
+// Messages from apps and integrations often use other blocks,
+// with only a plain fallback in the message text.
+func hasLayout(blocks []Block) bool {
+	for _, b := range blocks {
+		if b.Type != "rich_text" {
+			return true
+		}
+	}
+	return false
+}
+
+// blocksText returns the text of Block Kit blocks,
+// rendered as readable, plain text.
+// Unsupported blocks, such as inputs, are ignored.
+func blocksText(f textFixer, blocks []Block) string {
+	var lines []string
+	for _, b := range blocks {
+		if text := blockText(f, b); text != "" {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func blockText(f textFixer, b Block) string {
+	switch b.Type {
+	case "section":
+		lines := []string{f.fix(string(b.Text))}
+		for _, field := range b.Fields {
+			lines = append(lines, f.fix(string(field)))
+		}
+		return joinNonEmpty("\n", lines...)
+
+	case "header":
+		return f.fix(string(b.Text))
+
+	case "context":
+		var elms []string
+		for _, e := range b.Elements {
+			if e.Type == "image" {
+				elms = append(elms, e.AltText)
+			} else {
+				elms = append(elms, f.fix(string(e.Text)))
+			}
+		}
+		return joinNonEmpty(" ", elms...)
+
+	case "divider":
+		return "———"
+
+	case "image":
+		return joinNonEmpty(" — ", f.fix(string(b.Title)), b.ImageURL)
+
+	case "actions":
+		var elms []string
+		for _, e := range b.Elements {
+			if e.Type != "button" {
+				continue
+			}
+			elms = append(elms, joinNonEmpty(" ", "["+f.fix(string(e.Text))+"]", e.URL))
+		}
+		return joinNonEmpty(" ", elms...)
+
+	case "rich_text":
+		var s strings.Builder
+		for _, e := range b.Elements {
+			richText(f, &s, e)
+		}
+		return strings.TrimRight(s.String(), "\n")
+	}
+	return ""
+}
+
+// richText writes the text of a rich text element to s.
+func richText(f textFixer, s *strings.Builder, e Block) {
+	switch e.Type {
+	case "rich_text_section":
+		for _, e := range e.Elements {
+			richText(f, s, e)
+		}
+
+	case "rich_text_list":
+		var style string
+		json.Unmarshal(e.Style, &style)
+		for i, item := range e.Elements {
+			if style == "ordered" {
+				s.WriteString(strconv.Itoa(i+1) + ". ")
+			} else {
+				s.WriteString("• ")
+			}
+			richText(f, s, item)
+			s.WriteRune('\n')
+		}
+
+	case "rich_text_quote":
+		var q strings.Builder
+		for _, e := range e.Elements {
+			richText(f, &q, e)
+		}
+		for _, line := range strings.Split(strings.TrimRight(q.String(), "\n"), "\n") {
+			s.WriteString("> " + line + "\n")
+		}
+
+	case "rich_text_preformatted":
+		s.WriteString("```\n")
+		for _, e := range e.Elements {
+			richText(f, s, e)
+		}
+		s.WriteString("\n```\n")
+
+	case "text":
+		s.WriteString(string(e.Text))
+
+	case "link":
+		switch {
+		case e.Text == "" || string(e.Text) == e.URL:
+			s.WriteString(e.URL)
+		default:
+			s.WriteString(string(e.Text) + " (" + e.URL + ")")
+		}
+
+	case "user":
+		name := e.UserID
+		if f.findUser != nil {
+			if n, ok := f.findUser(e.UserID); ok {
+				name = n
+			}
+		}
+		s.WriteString("@" + name)
+
+	case "channel":
+		name := e.ChannelID
+		if f.findChannel != nil {
+			if n, ok := f.findChannel(e.ChannelID); ok {
+				name = n
+			}
+		}
+		s.WriteString("#" + name)
+
+	case "emoji":
+		text := f.fix(":" + e.Name + ":")
+		if u, ok := unicodeEmoji(e.Unicode); ok && text == ":"+e.Name+":" {
+			text = u
+		}
+		s.WriteString(text)
+
+	case "broadcast":
+		s.WriteString("@" + e.Range)
+	}
+}
+
+// unicodeEmoji returns the UTF-8 of an emoji
+// given as dash-separated, hexadecimal code points, such as 1f1fa-1f1f8.
+func unicodeEmoji(codePoints string) (string, bool) {
+	if codePoints == "" {
+		return "", false
+	}
+	var s strings.Builder
+	for _, cp := range strings.Split(codePoints, "-") {
+		r, err := strconv.ParseUint(cp, 16, 32)
+		if err != nil {
+			return "", false
+		}
+		s.WriteRune(rune(r))
+	}
+	return s.String(), true
+}
+
+// attachmentsText returns the text of message attachments,
+// rendered as readable, plain text.
+// Link unfurls are ignored, since their URL is already in the message text.
+func attachmentsText(f textFixer, attachments []Attachment) string {
+	var texts []string
+	for _, a := range attachments {
+		if a.FromURL != "" {
+			continue
+		}
+		lines := []string{
+			f.fix(a.Pretext),
+			a.AuthorName,
+			joinNonEmpty(" — ", f.fix(a.Title), a.TitleLink),
+			f.fix(a.Text),
+		}
+		for _, field := range a.Fields {
+			lines = append(lines, joinNonEmpty(": ", f.fix(field.Title), f.fix(field.Value)))
+		}
+		lines = append(lines, a.ImageURL, f.fix(a.Footer))
+		text := joinNonEmpty("\n", lines...)
+		if text == "" {
+			text = f.fix(a.Fallback)
+		}
+		if text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// joinNonEmpty joins the non-empty strings with the separator.
+func joinNonEmpty(sep string, strs ...string) string {
+	var nonEmpty []string
+	for _, s := range strs {
+		if s != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	return strings.Join(nonEmpty, sep)
+}
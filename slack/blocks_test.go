@@ -0,0 +1,177 @@
+package slack
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBlocksText(t *testing.T) {
+	f := textFixer{
+		findUser: func(id string) (string, bool) {
+			if id == "Ufound" {
+				return "found", true
+			}
+			return "", false
+		},
+		findChannel: func(id string) (string, bool) {
+			if id == "Cfound" {
+				return "theclub", true
+			}
+			return "", false
+		},
+	}
+	tests := []struct {
+		name, blocks, want string
+	}{
+		{
+			name:   "Header and section",
+			blocks: `[{"type":"header","text":{"type":"plain_text","text":"Deploy"}},{"type":"section","text":{"type":"mrkdwn","text":"Deployed by <@Ufound> &amp; friends :copyright:"}}]`,
+			want:   "Deploy\nDeployed by @found & friends ©",
+		},
+		{
+			name:   "Section fields",
+			blocks: `[{"type":"section","text":{"type":"mrkdwn","text":"Status"},"fields":[{"type":"mrkdwn","text":"*Env*: prod"},{"type":"plain_text","text":"OK"}]}]`,
+			want:   "Status\n*Env*: prod\nOK",
+		},
+		{
+			name:   "Context",
+			blocks: `[{"type":"context","elements":[{"type":"image","image_url":"https://a.com/i.png","alt_text":"icon"},{"type":"mrkdwn","text":"from CI"}]}]`,
+			want:   "icon from CI",
+		},
+		{
+			name:   "Divider and image",
+			blocks: `[{"type":"divider"},{"type":"image","image_url":"https://a.com/i.png","alt_text":"a cat","title":{"type":"plain_text","text":"Cat"}}]`,
+			want:   "———\nCat — https://a.com/i.png",
+		},
+		{
+			name:   "Actions",
+			blocks: `[{"type":"actions","elements":[{"type":"button","text":{"type":"plain_text","text":"Open"},"url":"https://a.com"},{"type":"button","text":{"type":"plain_text","text":"Ack"}},{"type":"static_select"}]}]`,
+			want:   "[Open] https://a.com [Ack]",
+		},
+		{
+			name: "Rich text",
+			blocks: `[{"type":"rich_text","elements":[
+				{"type":"rich_text_section","elements":[
+					{"type":"text","text":"hi ","style":{"bold":true}},
+					{"type":"user","user_id":"Ufound"},
+					{"type":"text","text":" and "},
+					{"type":"user","user_id":"Unotfound"},
+					{"type":"text","text":" in "},
+					{"type":"channel","channel_id":"Cfound"},
+					{"type":"text","text":" "},
+					{"type":"broadcast","range":"here"},
+					{"type":"text","text":" "},
+					{"type":"emoji","name":"relaxed"},
+					{"type":"emoji","name":"partyparrot"},
+					{"type":"emoji","name":"us","unicode":"1f1fa-1f1f8"},
+					{"type":"text","text":"\n"}
+				]},
+				{"type":"rich_text_list","style":"ordered","elements":[
+					{"type":"rich_text_section","elements":[{"type":"text","text":"one"}]},
+					{"type":"rich_text_section","elements":[{"type":"link","url":"https://a.com","text":"two"}]}
+				]},
+				{"type":"rich_text_list","style":"bullet","elements":[
+					{"type":"rich_text_section","elements":[{"type":"link","url":"https://a.com"}]}
+				]},
+				{"type":"rich_text_quote","elements":[{"type":"text","text":"a\nb"}]},
+				{"type":"rich_text_preformatted","elements":[{"type":"text","text":"x := 1"}]}
+			]}]`,
+			want: "hi @found and @Unotfound in #theclub @here ☺:partyparrot:🇺🇸\n" +
+				"1. one\n" +
+				"2. two (https://a.com)\n" +
+				"• https://a.com\n" +
+				"> a\n" +
+				"> b\n" +
+				"```\nx := 1\n```",
+		},
+	}
+	for _, test := range tests {
+		var blocks []Block
+		if err := json.Unmarshal([]byte(test.blocks), &blocks); err != nil {
+			t.Errorf("%s: failed to unmarshal blocks: %s", test.name, err)
+			continue
+		}
+		if got := blocksText(f, blocks); got != test.want {
+			t.Errorf("%s: blocksText(_, %s)=%q, want %q", test.name, test.blocks, got, test.want)
+		}
+	}
+}
+
+func TestHasLayout(t *testing.T) {
+	tests := []struct {
+		blocks []Block
+		want   bool
+	}{
+		{blocks: nil, want: false},
+		{blocks: []Block{{Type: "rich_text"}}, want: false},
+		{blocks: []Block{{Type: "rich_text"}, {Type: "section"}}, want: true},
+		{blocks: []Block{{Type: "header"}}, want: true},
+	}
+	for _, test := range tests {
+		if got := hasLayout(test.blocks); got != test.want {
+			t.Errorf("hasLayout(%+v)=%v, want %v", test.blocks, got, test.want)
+		}
+	}
+}
+
+func TestAttachmentsText(t *testing.T) {
+	tests := []struct {
+		name, attachments, want string
+	}{
+		{
+			name:        "Full",
+			attachments: `[{"pretext":"New alert","author_name":"Grafana","title":"CPU high","title_link":"https://g.com/a","text":"CPU &gt; 90%","fields":[{"title":"Host","value":"web1","short":true}],"image_url":"https://g.com/i.png","footer":"Grafana v9"}]`,
+			want:        "New alert\nGrafana\nCPU high — https://g.com/a\nCPU > 90%\nHost: web1\nhttps://g.com/i.png\nGrafana v9",
+		},
+		{
+			name:        "Fallback",
+			attachments: `[{"fallback":"Something happened","color":"#ff0000"}]`,
+			want:        "Something happened",
+		},
+		{
+			name:        "Unfurl is ignored",
+			attachments: `[{"from_url":"https://a.com","title":"A","text":"About A"}]`,
+			want:        "",
+		},
+		{
+			name:        "Multiple",
+			attachments: `[{"text":"one"},{"text":"two"}]`,
+			want:        "one\ntwo",
+		},
+	}
+	for _, test := range tests {
+		var attachments []Attachment
+		if err := json.Unmarshal([]byte(test.attachments), &attachments); err != nil {
+			t.Errorf("%s: failed to unmarshal attachments: %s", test.name, err)
+			continue
+		}
+		if got := attachmentsText(textFixer{}, attachments); got != test.want {
+			t.Errorf("%s: attachmentsText(_, %s)=%q, want %q", test.name, test.attachments, got, test.want)
+		}
+	}
+}
+
+func TestResolveEmoji(t *testing.T) {
+	emoji := map[string]string{
+		"partyparrot":  "https://emoji.slack-edge.com/T1/partyparrot/1.gif",
+		"parrot":       "alias:partyparrot",
+		"thumbsup_all": "alias:+1",
+		"loop1":        "alias:loop2",
+		"loop2":        "alias:loop1",
+	}
+	tests := []struct {
+		name, want string
+	}{
+		{name: "partyparrot", want: "partyparrot"},
+		{name: "parrot", want: "partyparrot"},
+		{name: "thumbsup_all", want: "+1"},
+		{name: "unknown", want: "unknown"},
+	}
+	for _, test := range tests {
+		if got := resolveEmoji(emoji, test.name); got != test.want {
+			t.Errorf("resolveEmoji(_, %q)=%q, want %q", test.name, got, test.want)
+		}
+	}
+	// Just make sure that a cycle terminates.
+	resolveEmoji(emoji, "loop1")
+}
@@ -2,9 +2,7 @@ package slack
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"html"
 	"io"
 	"log"
 	"net/url"
@@ -32,6 +30,21 @@ type channel struct {
 	// We save the first here, attach it to the next message_replied subtype
 	// with the matching thread_id, and remove it from this list.
 	replies map[string]*chat.Message
+
+	// broadcast is whether @here, @channel, and @everyone
+	// in sent messages notify the members of the channel.
+	broadcast bool
+
+	// lastTS is the timestamp of the last update received on the channel.
+	// It is used to backfill missed messages after a reconnect.
+	// The client lock must be held to access lastTS.
+	lastTS string
+
+	// backfilled is the set of timestamps of messages
+	// received from conversations.history on the last reconnect.
+	// These are ignored if they are also received from RTM.
+	// The client lock must be held to access backfilled.
+	backfilled map[string]bool
 }
 
 // newChannel creates a new channel
@@ -63,6 +76,13 @@ func (ch *channel) PrettyPrint() string {
 	return "\"" + ch.Name() + " at " + ch.ServiceName() + "\""
 }
 
+// AllowBroadcast sets whether @here, @channel, and @everyone
+// in sent message text notify the members of the channel.
+// By default they are sent as plain text, and do not notify anyone.
+func (ch *channel) AllowBroadcast(allow bool) {
+	ch.broadcast = allow
+}
+
 func (ch *channel) Name() string        { return ch.ChannelName }
 func (ch *channel) ServiceName() string { return ch.client.domain + ".slack.com" }
 
@@ -109,18 +129,24 @@ func getUserByID(ctx context.Context, ch *channel, id chat.UserID) (*chat.User,
 	return &u, nil
 }
 
-// getUserByNick looks up a user by their nick.
-func getUserByNick(ch *channel, nick string) (*chat.User, error) {
-	ch.client.Lock()
-	defer ch.client.Unlock()
-
-	for _, u := range ch.client.users {
-		if u.Nick == nick {
-			u.Channel = ch
-			return &u, nil
-		}
+// messageUser returns the sender of a message.
+// Messages from apps and integrations with no user
+// are sent by a User with the bot ID and the bot username.
+func messageUser(ctx context.Context, ch *channel, u *Update) (*chat.User, error) {
+	if u.User != "" {
+		return getUserByID(ctx, ch, u.User)
 	}
-	return nil, errors.New("nick not found: " + nick)
+	name := u.Username
+	if name == "" {
+		name = u.BotID
+	}
+	return &chat.User{
+		ID:          chat.UserID(u.BotID),
+		Nick:        name,
+		FullName:    name,
+		DisplayName: name,
+		Channel:     ch,
+	}, nil
 }
 
 // chatEvent returns the chat event corresponding to the update.
@@ -134,40 +160,15 @@ func (ch *channel) chatEvent(ctx context.Context, u *Update) (chat.Event, error)
 	}
 	ch.client.Unlock()
 
+	if u.BotID != "" && u.BotID == ch.client.me.Profile.BotID {
+		// Ignore messages sent by this Client.
+		return nil, nil
+	}
 	switch {
 	case u.Type == "message":
 		switch {
-		case len(u.Attachments) > 0 && u.Attachments[0].ImageURL != "":
-			attachment := u.Attachments[0]
-			var user *chat.User
-			if u.User != "" {
-				var err error
-				if user, err = getUserByID(ctx, ch, u.User); err != nil {
-					return nil, err
-				}
-			} else {
-				// AuthorName isn't guaranteed to be a nick.
-				// Ignore any error, and defer to the the fallback case.
-				user, _ = getUserByNick(ch, attachment.AuthorName)
-			}
-			var text string
-			if user == nil {
-				text = attachment.AuthorName + " sent an image: "
-			} else {
-				text = "/me sent an image: "
-			}
-			if attachment.Title != "" {
-				text += attachment.Title + " — "
-			}
-			text += attachment.ImageURL
-			if attachment.Footer != "" {
-				text += "\n" + attachment.Footer
-			}
-			id := chat.MessageID(u.Ts)
-			return chat.Message{ID: id, From: user, Text: text}, nil
-
-		case u.SubType == "" || u.SubType == "me_message":
-			if u.User == "" || u.Text == "" {
+		case u.SubType == "" || u.SubType == "me_message" || u.SubType == "bot_message":
+			if u.User == "" && u.BotID == "" || u.Text == "" && len(u.Blocks) == 0 && len(u.Attachments) == 0 {
 				return nil, nil
 			}
 			if u.ThreadTS != "" {
@@ -182,6 +183,8 @@ func (ch *channel) chatEvent(ctx context.Context, u *Update) (chat.Event, error)
 			}
 			if msg, err := chatMessage(ctx, ch, u); err != nil {
 				return nil, err
+			} else if msg.Text == "" {
+				return nil, nil
 			} else {
 				return *msg, nil
 			}
@@ -202,7 +205,7 @@ func (ch *channel) chatEvent(ctx context.Context, u *Update) (chat.Event, error)
 			}
 			delete(ch.replies, ts)
 
-			if u.Message.User == "" {
+			if u.Message.User == "" && u.Message.BotID == "" {
 				log.Printf("no message user")
 				return nil, nil
 			}
@@ -215,7 +218,7 @@ func (ch *channel) chatEvent(ctx context.Context, u *Update) (chat.Event, error)
 			return *reply, nil
 
 		case u.SubType == "message_changed" && u.Message != nil:
-			if u.Message.User == "" {
+			if u.Message.User == "" && u.Message.BotID == "" {
 				return nil, nil
 			}
 			msg, err := chatMessage(ctx, ch, u.Message)
@@ -242,14 +245,25 @@ func (ch *channel) chatEvent(ctx context.Context, u *Update) (chat.Event, error)
 			fileURL.Path = path.Join(fileURL.Path, u.File.ID)
 			text := "/me shared a file: " + fileURL.String()
 			id := chat.MessageID(u.Ts)
-			return chat.Message{ID: id, From: user, Text: text}, nil
+			attachment := chat.Attachment{
+				URL:      fileURL.String(),
+				Name:     u.File.Name,
+				MimeType: u.File.Mimetype,
+				Size:     u.File.Size,
+			}
+			return chat.Message{
+				ID:          id,
+				From:        user,
+				Text:        text,
+				Attachments: []chat.Attachment{attachment},
+			}, nil
 		}
 	}
 	return nil, nil
 }
 
 func chatMessage(ctx context.Context, ch *channel, u *Update) (*chat.Message, error) {
-	user, err := getUserByID(ctx, ch, u.User)
+	user, err := messageUser(ctx, ch, u)
 	if err != nil {
 		return nil, err
 	}
@@ -261,10 +275,20 @@ func chatMessage(ctx context.Context, ch *channel, u *Update) (*chat.Message, er
 		}
 		return u.Name(), true
 	}
+	f := textFixer{
+		findUser:    findUser,
+		findChannel: ch.client.findChannelName,
+		findEmoji:   ch.client.findEmoji,
+	}
+	text := f.fix(u.Text)
+	if hasLayout(u.Blocks) {
+		// The message text is just a fallback for the blocks.
+		text = blocksText(f, u.Blocks)
+	}
 	msg := &chat.Message{
 		ID:   chat.MessageID(u.Ts),
 		From: user,
-		Text: fixText(findUser, html.UnescapeString(u.Text)),
+		Text: joinNonEmpty("\n", text, attachmentsText(f, u.Attachments)),
 	}
 	if u.SubType == "me_message" {
 		msg.Text = "/me " + msg.Text
@@ -272,7 +296,8 @@ func chatMessage(ctx context.Context, ch *channel, u *Update) (*chat.Message, er
 	return msg, nil
 }
 
-// Send sends text to the Channel and returns the sent Message.
+// send sends Slack message text to the Channel and returns the sent Message.
+// The text must already be encoded with mrkdwn.
 func (ch *channel) send(ctx context.Context, sendAs *chat.User, text string) (chat.Message, error) {
 	// Do not attempt to send empty messages
 	// TODO(cws): make bridge just not crash when errors come back from Send/SendAs)
@@ -280,13 +305,6 @@ func (ch *channel) send(ctx context.Context, sendAs *chat.User, text string) (ch
 		return chat.Message{}, nil
 	}
 
-	if strings.HasPrefix(text, "/me ") {
-		text = strings.TrimPrefix(text, "/me ")
-		// Add a space before the closing _ so if text ends with a URL,
-		// Slack doesn't think that the closing _ is really part of the URL.
-		text = fmt.Sprintf("_%s _", text)
-	}
-
 	args := []string{
 		"channel=" + ch.ID,
 		"text=" + text,
@@ -312,18 +330,39 @@ func (ch *channel) send(ctx context.Context, sendAs *chat.User, text string) (ch
 	return msg, nil
 }
 
+// mrkdwn returns message text encoded for sending to the Channel.
+func (ch *channel) mrkdwn(text string) string {
+	me := strings.HasPrefix(text, "/me ")
+	if me {
+		text = strings.TrimPrefix(text, "/me ")
+	}
+	text = encodeText(ch.client.findUserID, ch.client.findChannelID, ch.broadcast, text)
+	if me {
+		// Add a space before the closing _ so if text ends with a URL,
+		// Slack doesn't think that the closing _ is really part of the URL.
+		text = fmt.Sprintf("_%s _", text)
+	}
+	return text
+}
+
 func (ch *channel) Send(ctx context.Context, msg chat.Message) (chat.Message, error) {
 	if msg.ReplyTo != nil {
 		if msg.ReplyTo.From == nil {
 			me := chatUser(ch.client.me)
 			msg.ReplyTo.From = &me
 		}
-		txt := "_" + msg.ReplyTo.From.Name() + " said_:\n>" + msg.ReplyTo.Text
+		name := encodeText(nil, nil, false, msg.ReplyTo.From.Name())
+		txt := "_" + name + " said_:\n>" + ch.mrkdwn(msg.ReplyTo.Text)
 		if _, err := ch.send(ctx, msg.From, txt); err != nil {
 			return chat.Message{}, err
 		}
 	}
-	return ch.send(ctx, msg.From, msg.Text)
+	sent, err := ch.send(ctx, msg.From, ch.mrkdwn(msg.Text))
+	if err != nil {
+		return chat.Message{}, err
+	}
+	sent.Text = msg.Text
+	return sent, nil
 }
 
 func (ch *channel) Delete(ctx context.Context, msg chat.Message) error {
@@ -350,7 +389,7 @@ func (ch *channel) Edit(ctx context.Context, msg chat.Message) (chat.Message, er
 		"chat.update",
 		"channel="+ch.ID,
 		"ts="+string(msg.ID),
-		"text="+msg.Text)
+		"text="+ch.mrkdwn(msg.Text))
 	if err != nil {
 		if rpcErr, ok := err.(rpcErr); ok && rpcErr.httpStatus == 404 {
 			return msg, nil
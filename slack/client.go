@@ -17,10 +17,18 @@ import (
 	"time"
 
 	"github.com/velour/chat"
+	"github.com/velour/chat/cache"
 
 	"golang.org/x/net/websocket"
 )
 
+const (
+	// maxMedia is the maximum number of Files to remember.
+	maxMedia = 1000
+	// mediaTTL is how long to remember a File.
+	mediaTTL = time.Hour
+)
+
 var (
 	api = url.URL{Scheme: "https", Host: "slack.com", Path: "/api"}
 )
@@ -29,12 +37,14 @@ var _ chat.Client = &Client{}
 
 // A Client represents a connection to the slack API.
 type Client struct {
-	token   string
-	me      *User
-	domain  string
-	webSock *websocket.Conn
-
-	pingError chan error
+	token  string
+	me     *User
+	domain string
+
+	// pingDone is closed when the ping goroutine returns.
+	pingDone chan struct{}
+	// pollError reports an unrecoverable error
+	// from the poll goroutine to the Close method.
 	pollError chan error
 
 	// cancel cancels the background goroutines.
@@ -42,12 +52,24 @@ type Client struct {
 
 	httpClient http.Client
 
+	// media caches File, keyed by the string file ID.
+	media *cache.Cache
+
 	sync.Mutex
+	// webSock is the current RTM connection.
+	// It is replaced each time the Client reconnects.
+	webSock  *websocket.Conn
 	channels map[string]*channel
-	users    map[chat.UserID]chat.User
-	media    map[string]File
-	nextID   uint64
-	localURL *url.URL
+	// channelIDs maps channel names to IDs for all listed channels,
+	// whether or not they are joined.
+	channelIDs map[string]string
+	users      map[chat.UserID]chat.User
+	nextID     uint64
+	localURL   *url.URL
+
+	// emoji maps custom emoji names to their image URL,
+	// or to alias:<name> for aliases of other emoji.
+	emoji map[string]string
 }
 
 // Dial returns a new slack client using the given token.
@@ -55,12 +77,13 @@ type Client struct {
 // and automatically sends pings.
 func Dial(ctx context.Context, token string) (*Client, error) {
 	c := &Client{
-		token:     token,
-		pingError: make(chan error, 1),
-		pollError: make(chan error, 1),
-		channels:  make(map[string]*channel),
-		users:     make(map[chat.UserID]chat.User),
-		media:     make(map[string]File),
+		token:      token,
+		pingDone:   make(chan struct{}),
+		pollError:  make(chan error, 1),
+		channels:   make(map[string]*channel),
+		channelIDs: make(map[string]string),
+		users:      make(map[chat.UserID]chat.User),
+		media:      cache.New(maxMedia, mediaTTL),
 	}
 
 	var resp struct {
@@ -100,6 +123,11 @@ func Dial(ctx context.Context, token string) (*Client, error) {
 		return nil, fmt.Errorf("expected hello, got %v", event)
 	}
 
+	if err := loadEmoji(ctx, c); err != nil {
+		// Custom emoji are a nicety; don't fail if they can't be listed.
+		log.Printf("Failed to load Slack custom emoji: %s\n", err)
+	}
+
 	bkg := context.Background()
 	bkg, c.cancel = context.WithCancel(bkg)
 	go ping(bkg, c)
@@ -125,16 +153,14 @@ func (c *Client) Close(_ context.Context) error {
 	// before closing the socket.
 	c.cancel()
 	pollError := <-c.pollError
-	pingError := <-c.pingError
-	closeError := c.webSock.Close()
+	<-c.pingDone
+	closeError := c.conn().Close()
 
 	switch {
 	case closeError != nil:
 		return closeError
 	case pollError != nil:
 		return pollError
-	case pingError != nil:
-		return pingError
 	default:
 		return nil
 	}
@@ -174,8 +200,7 @@ func (c *Client) Join(ctx context.Context, channel string) (chat.Channel, error)
 }
 
 func ping(ctx context.Context, c *Client) {
-	defer c.cancel()
-	defer close(c.pingError)
+	defer close(c.pingDone)
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 	for {
@@ -183,9 +208,14 @@ func ping(ctx context.Context, c *Client) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
+			webSock := c.conn()
 			if err := c.send(ctx, map[string]interface{}{"type": "ping"}); err != nil {
-				c.pingError <- err
-				return
+				if ctx.Err() != nil {
+					return
+				}
+				// Closing the connection causes poll to reconnect.
+				log.Printf("Slack ping failed: %s\n", err)
+				webSock.Close()
 			}
 		}
 	}
@@ -203,11 +233,28 @@ func poll(ctx context.Context, c *Client) {
 		switch msg, err := c.next(ctx); {
 		case err == context.DeadlineExceeded || err == context.Canceled:
 			return
-		case err != nil:
-			c.pollError <- err
-			return
+		case err != nil || msg.Type == "goodbye":
+			// Slack sends goodbye before closing the connection,
+			// for example, when it periodically refreshes the socket.
+			if err != nil {
+				log.Printf("Slack receive failed: %s\n", err)
+			} else {
+				log.Println("Slack said goodbye")
+			}
+			if err := reconnect(ctx, c); err != nil {
+				if err != context.DeadlineExceeded && err != context.Canceled {
+					c.pollError <- err
+				}
+				return
+			}
 		case msg.Type == "message":
 			c.update(ctx, msg)
+		case msg.Type == "emoji_changed":
+			go func() {
+				if err := loadEmoji(ctx, c); err != nil {
+					log.Printf("Failed to reload Slack custom emoji: %s\n", err)
+				}
+			}()
 		}
 	}
 }
@@ -218,7 +265,15 @@ func (c *Client) update(ctx context.Context, u Update) {
 
 	ch, ok := c.channels[u.Channel]
 	if !ok {
-		ch, _ = c.join(ctx, u.Channel)
+		// Ignore updates for channels that are not joined.
+		return
+	}
+	if u.Type == "message" && u.SubType == "" && ch.backfilled[u.Ts] {
+		// This message was already received from conversations.history.
+		return
+	}
+	if tsLess(ch.lastTS, u.Ts) {
+		ch.lastTS = u.Ts
 	}
 	select {
 	case ch.in <- []*Update{&u}:
@@ -230,12 +285,13 @@ func (c *Client) update(ctx context.Context, u Update) {
 // next returns the next event from Slack.
 // It never returns pong type messages.
 func (c *Client) next(ctx context.Context) (Update, error) {
+	webSock := c.conn()
 	err := make(chan error, 1)
 	for {
 		var u Update
 		go func() {
 		again:
-			e := jsonCodec.Receive(c.webSock, &u)
+			e := jsonCodec.Receive(webSock, &u)
 			if _, ok := e.(*json.UnmarshalTypeError); ok {
 				// Not all RTM events can be unmarshaled into an Update.
 				// However, all "message" type events can,
@@ -260,6 +316,203 @@ func (c *Client) next(ctx context.Context) (Update, error) {
 	}
 }
 
+// conn returns the current RTM connection.
+func (c *Client) conn() *websocket.Conn {
+	c.Lock()
+	defer c.Unlock()
+	return c.webSock
+}
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 2 * time.Minute
+)
+
+// reconnect closes the current RTM connection and connects a new one,
+// retrying with exponential backoff until it succeeds,
+// the context is done, or Slack rejects the Client's token.
+// Once reconnected, messages missed while disconnected
+// are backfilled to the joined channels.
+func reconnect(ctx context.Context, c *Client) error {
+	c.conn().Close()
+	delay := minReconnectDelay
+	for {
+		err := connect(ctx, c)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err, ok := err.(rpcErr); ok && fatalErrors[err.msg] {
+			return err
+		}
+		log.Printf("Slack reconnect failed: %s, retrying in %s\n", err, delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+	log.Println("Slack reconnected")
+	backfill(ctx, c)
+	return nil
+}
+
+// fatalErrors are rtm.connect errors for which reconnecting will never succeed.
+var fatalErrors = map[string]bool{
+	"not_authed":       true,
+	"invalid_auth":     true,
+	"account_inactive": true,
+	"token_revoked":    true,
+	"no_permission":    true,
+	"missing_scope":    true,
+}
+
+// connect connects a new RTM websocket for the Client
+// and waits for the hello event.
+func connect(ctx context.Context, c *Client) error {
+	var resp struct {
+		ResponseHeader
+		URL string `json:"url"`
+	}
+	if err := rpc(ctx, c, &resp, "rtm.connect"); err != nil {
+		return err
+	}
+	webSock, err := websocket.Dial(resp.URL, "", api.String())
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	c.webSock = webSock
+	c.Unlock()
+
+	switch event, err := c.next(ctx); {
+	case err != nil:
+		webSock.Close()
+		return err
+	case event.Type != "hello":
+		webSock.Close()
+		return fmt.Errorf("expected hello, got %v", event)
+	}
+	return nil
+}
+
+// maxBackfillPages is the maximum number of conversations.history pages
+// fetched for each channel on reconnect.
+const maxBackfillPages = 10
+
+// backfill sends all messages since the last received message
+// to each joined channel.
+func backfill(ctx context.Context, c *Client) {
+	type since struct {
+		id string
+		ts string
+	}
+	var chs []since
+	c.Lock()
+	for _, ch := range c.channels {
+		ch.backfilled = make(map[string]bool)
+		if ch.lastTS != "" {
+			chs = append(chs, since{id: ch.ID, ts: ch.lastTS})
+		}
+	}
+	c.Unlock()
+
+	for _, ch := range chs {
+		us, err := history(ctx, c, ch.id, ch.ts)
+		if err != nil {
+			log.Printf("Failed to backfill Slack channel %s: %s\n", ch.id, err)
+			continue
+		}
+		if len(us) > 0 {
+			log.Printf("Backfilling %d Slack messages to channel %s\n", len(us), ch.id)
+		}
+		for _, u := range us {
+			c.update(ctx, u)
+			c.Lock()
+			if ch, ok := c.channels[ch.id]; ok && u.SubType == "" {
+				ch.backfilled[u.Ts] = true
+			}
+			c.Unlock()
+		}
+	}
+}
+
+// history returns the messages posted to a channel after the oldest timestamp,
+// ordered from oldest to newest.
+func history(ctx context.Context, c *Client, channelID, oldest string) ([]Update, error) {
+	var us []Update
+	var cursor string
+	for i := 0; i < maxBackfillPages; i++ {
+		var resp struct {
+			ResponseHeader
+			Messages []Update `json:"messages"`
+			HasMore  bool     `json:"has_more"`
+			Metadata struct {
+				NextCursor string `json:"next_cursor"`
+			} `json:"response_metadata"`
+		}
+		args := []string{"channel=" + channelID, "oldest=" + oldest}
+		if cursor != "" {
+			args = append(args, "cursor="+cursor)
+		}
+		if err := rpc(ctx, c, &resp, "conversations.history", args...); err != nil {
+			return nil, err
+		}
+		us = append(us, resp.Messages...)
+		if !resp.HasMore || resp.Metadata.NextCursor == "" {
+			break
+		}
+		cursor = resp.Metadata.NextCursor
+	}
+	// Messages are returned newest first.
+	for i := 0; i < len(us)/2; i++ {
+		j := len(us) - i - 1
+		us[i], us[j] = us[j], us[i]
+	}
+	for i := range us {
+		us[i].Channel = channelID
+		if us[i].Type == "" {
+			us[i].Type = "message"
+		}
+	}
+	return us, nil
+}
+
+// tsLess returns whether Slack timestamp a is before b.
+// The empty string is before all other timestamps.
+func tsLess(a, b string) bool {
+	aSec, aFrac := splitTS(a)
+	bSec, bFrac := splitTS(b)
+	if len(aSec) != len(bSec) {
+		return len(aSec) < len(bSec)
+	}
+	if aSec != bSec {
+		return aSec < bSec
+	}
+	for len(aFrac) < len(bFrac) {
+		aFrac += "0"
+	}
+	for len(bFrac) < len(aFrac) {
+		bFrac += "0"
+	}
+	return aFrac < bFrac
+}
+
+// splitTS splits a Slack timestamp into its seconds,
+// without leading zeros, and its fractional part.
+func splitTS(ts string) (string, string) {
+	i := strings.IndexByte(ts, '.')
+	if i < 0 {
+		return strings.TrimLeft(ts, "0"), ""
+	}
+	return strings.TrimLeft(ts[:i], "0"), ts[i+1:]
+}
+
 // send sends an RTM message. It returns without waiting for a response.
 func (c *Client) send(ctx context.Context, message map[string]interface{}) error {
 	c.Lock()
@@ -267,7 +520,8 @@ func (c *Client) send(ctx context.Context, message map[string]interface{}) error
 	c.nextID++
 	c.Unlock()
 	err := make(chan error, 1)
-	go func() { err <- websocket.JSON.Send(c.webSock, message) }()
+	webSock := c.conn()
+	go func() { err <- websocket.JSON.Send(webSock, message) }()
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
@@ -277,6 +531,7 @@ func (c *Client) send(ctx context.Context, message map[string]interface{}) error
 }
 
 // channelsList returns a list of all slack channels.
+// The Client must be locked.
 func (c *Client) channelsList(ctx context.Context) ([]*channel, error) {
 	var resp struct {
 		ResponseHeader
@@ -290,10 +545,96 @@ func (c *Client) channelsList(ctx context.Context) ([]*channel, error) {
 		ch := ch
 		initChannel(c, &ch)
 		channels = append(channels, &ch)
+		c.channelIDs[ch.ChannelName] = ch.ID
 	}
 	return channels, nil
 }
 
+// findUserID returns the ID of the user with the given nick.
+func (c *Client) findUserID(nick string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	for id, u := range c.users {
+		if u.Nick == nick {
+			return string(id), true
+		}
+	}
+	return "", false
+}
+
+// findChannelName returns the name of the channel with the given ID.
+func (c *Client) findChannelName(id string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if ch, ok := c.channels[id]; ok {
+		return ch.Name(), true
+	}
+	for name, chID := range c.channelIDs {
+		if chID == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// findChannelID returns the ID of the channel with the given name.
+func (c *Client) findChannelID(name string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	id, ok := c.channelIDs[name]
+	return id, ok
+}
+
+// loadEmoji loads the custom emoji of the Client's team.
+func loadEmoji(ctx context.Context, c *Client) error {
+	var resp struct {
+		ResponseHeader
+		Emoji map[string]string `json:"emoji"`
+	}
+	if err := rpc(ctx, c, &resp, "emoji.list"); err != nil {
+		return err
+	}
+	c.Lock()
+	c.emoji = resp.Emoji
+	c.Unlock()
+	return nil
+}
+
+// EmojiURL returns the image URL of a custom emoji, given its name without colons.
+// If the name is an alias, the URL is that of the aliased emoji.
+// The second return is false if there is no such custom emoji.
+func (c *Client) EmojiURL(name string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	name = resolveEmoji(c.emoji, name)
+	u, ok := c.emoji[name]
+	return u, ok
+}
+
+// findEmoji returns the UTF-8 of a custom emoji
+// that is an alias of a default emoji.
+func (c *Client) findEmoji(name string) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	e, ok := defaultEmoji[resolveEmoji(c.emoji, name)]
+	return e, ok
+}
+
+// resolveEmoji returns the emoji name to which the named emoji is an alias.
+// If the name is not an alias, it is returned as is.
+func resolveEmoji(emoji map[string]string, name string) string {
+	// Slack does not allow aliases of aliases,
+	// but limit the depth in case of a cycle.
+	for i := 0; i < 10; i++ {
+		v := emoji[name]
+		if !strings.HasPrefix(v, "alias:") {
+			break
+		}
+		name = strings.TrimPrefix(v, "alias:")
+	}
+	return name
+}
+
 // postMessage posts a message to the server with as the given username.
 func (c *Client) postMessage(ctx context.Context, username, iconurl, channel, text string) error {
 	if iconurl != "" {
@@ -352,20 +693,20 @@ func (c *Client) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 }
 
 func filesInfo(ctx context.Context, c *Client, fileID string) (File, error) {
-	c.Lock()
-	defer c.Unlock()
-	if f, ok := c.media[fileID]; ok {
-		return f, nil
-	}
-	var resp struct {
-		ResponseHeader
-		File `json:"file"`
-	}
-	if err := rpc(ctx, c, &resp, "files.info", "file="+fileID, "count=0"); err != nil {
+	f, err := c.media.Fetch(ctx, fileID, func(ctx context.Context) (interface{}, error) {
+		var resp struct {
+			ResponseHeader
+			File `json:"file"`
+		}
+		if err := rpc(ctx, c, &resp, "files.info", "file="+fileID, "count=0"); err != nil {
+			return nil, err
+		}
+		return resp.File, nil
+	})
+	if err != nil {
 		return File{}, err
 	}
-	c.media[fileID] = resp.File
-	return resp.File, nil
+	return f.(File), nil
 }
 
 type Response interface {
@@ -0,0 +1,231 @@
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/velour/chat"
+	"golang.org/x/net/websocket"
+)
+
+func TestTSLess(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"", "", false},
+		{"", "1.0", true},
+		{"1.0", "", false},
+		{"1503435956.000247", "1503435956.000247", false},
+		{"1503435956.000247", "1503435956.000248", true},
+		{"1503435956.000248", "1503435956.000247", false},
+		{"999999999.999999", "1503435956.000000", true},
+		{"1503435956.1", "1503435956.000247", false},
+		{"1503435956", "1503435956.000001", true},
+	}
+	for _, test := range tests {
+		if got := tsLess(test.a, test.b); got != test.want {
+			t.Errorf("tsLess(%q, %q)=%v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+// TestReconnect tests that the Client reconnects after a goodbye,
+// and backfills messages missed while disconnected.
+func TestReconnect(t *testing.T) {
+	message := func(ts, text string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":    "message",
+			"channel": "C1",
+			"user":    "U1",
+			"ts":      ts,
+			"text":    text,
+		}
+	}
+	var (
+		connects = make(chan *websocket.Conn, 2)
+		oldest   = make(chan string, 1)
+	)
+	mux := http.NewServeMux()
+	mux.Handle("/ws", websocket.Handler(func(conn *websocket.Conn) {
+		websocket.JSON.Send(conn, map[string]interface{}{"type": "hello"})
+		connects <- conn
+		for {
+			var msg interface{}
+			if err := websocket.JSON.Receive(conn, &msg); err != nil {
+				return
+			}
+		}
+	}))
+	var wsURL string
+	mux.HandleFunc("/api/rtm.start", func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":   true,
+			"url":  wsURL,
+			"self": map[string]interface{}{"id": "U0"},
+			"team": map[string]interface{}{"domain": "test"},
+			"users": []interface{}{
+				map[string]interface{}{"id": "U0", "name": "bot"},
+				map[string]interface{}{"id": "U1", "name": "alice"},
+			},
+		})
+	})
+	mux.HandleFunc("/api/rtm.connect", func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "url": wsURL})
+	})
+	mux.HandleFunc("/api/emoji.list", func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "emoji": map[string]string{}})
+	})
+	mux.HandleFunc("/api/channels.list", func(w http.ResponseWriter, _ *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok":       true,
+			"channels": []interface{}{map[string]interface{}{"id": "C1", "name": "general"}},
+		})
+	})
+	mux.HandleFunc("/api/conversations.history", func(w http.ResponseWriter, req *http.Request) {
+		oldest <- req.URL.Query().Get("oldest")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"ok": true,
+			"messages": []interface{}{
+				message("3.000000", "missed 2"),
+				message("2.000000", "missed 1"),
+			},
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	wsURL = "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	defer func(orig url.URL) { api = orig }(api)
+	serverURL, err := url.Parse(server.URL + "/api")
+	if err != nil {
+		t.Fatalf("url.Parse(%q)=_,%v", server.URL, err)
+	}
+	api = *serverURL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, "token")
+	if err != nil {
+		t.Fatalf("Dial(_, _)=_,%v", err)
+	}
+	defer c.Close(ctx)
+	ch, err := c.Join(ctx, "general")
+	if err != nil {
+		t.Fatalf("c.Join(_, general)=_,%v", err)
+	}
+
+	conn := <-connects
+	websocket.JSON.Send(conn, message("1.000000", "before"))
+	expectMessage(ctx, t, ch, "before")
+
+	websocket.JSON.Send(conn, map[string]interface{}{"type": "goodbye"})
+	conn.Close()
+	conn = <-connects
+	// The last message is also returned by conversations.history.
+	// It should only be received once.
+	websocket.JSON.Send(conn, message("3.000000", "missed 2"))
+	websocket.JSON.Send(conn, message("4.000000", "after"))
+
+	if got := <-oldest; got != "1.000000" {
+		t.Errorf("conversations.history oldest=%q, want 1.000000", got)
+	}
+	expectMessage(ctx, t, ch, "missed 1")
+	expectMessage(ctx, t, ch, "missed 2")
+	expectMessage(ctx, t, ch, "after")
+}
+
+func expectMessage(ctx context.Context, t *testing.T, ch chat.Channel, text string) {
+	t.Helper()
+	ev, err := ch.Receive(ctx)
+	if err != nil {
+		t.Fatalf("ch.Receive(_)=_,%v, want message %q", err, text)
+	}
+	if msg, ok := ev.(chat.Message); !ok || msg.Text != text {
+		t.Fatalf("ch.Receive(_)=%#v, want message %q", ev, text)
+	}
+}
+
+func TestChatEventSender(t *testing.T) {
+	me := &User{ID: "U0", Name: "bridge"}
+	me.Profile.BotID = "B0"
+	c := &Client{
+		me:       me,
+		channels: make(map[string]*channel),
+		users:    map[chat.UserID]chat.User{"U1": {ID: "U1", Nick: "alice"}},
+	}
+	ch := &channel{ID: "C1", ChannelName: "general", client: c, replies: make(map[string]*chat.Message)}
+	tests := []struct {
+		name string
+		u    Update
+		want *chat.Message
+	}{
+		{
+			name: "Image attachment",
+			u: Update{
+				Type: "message",
+				User: "U1",
+				Ts:   "1.0",
+				Text: "look",
+				Attachments: []Attachment{
+					{Title: "Cat", ImageURL: "https://cat.png"},
+					{Title: "Dog", Fields: []struct {
+						Title string `json:"title"`
+						Value string `json:"value"`
+					}{{Title: "Breed", Value: "corgi"}}},
+				},
+			},
+			want: &chat.Message{
+				ID:   "1.0",
+				From: &chat.User{ID: "U1", Nick: "alice", Channel: ch},
+				Text: "look\nCat\nhttps://cat.png\nDog\nBreed: corgi",
+			},
+		},
+		{
+			name: "Bot message",
+			u:    Update{Type: "message", SubType: "bot_message", BotID: "B1", Username: "ci", Ts: "2.0", Text: "build passed"},
+			want: &chat.Message{
+				ID:   "2.0",
+				From: &chat.User{ID: "B1", Nick: "ci", FullName: "ci", DisplayName: "ci", Channel: ch},
+				Text: "build passed",
+			},
+		},
+		{
+			name: "Bot message without a username",
+			u:    Update{Type: "message", SubType: "bot_message", BotID: "B1", Ts: "3.0", Text: "build passed"},
+			want: &chat.Message{
+				ID:   "3.0",
+				From: &chat.User{ID: "B1", Nick: "B1", FullName: "B1", DisplayName: "B1", Channel: ch},
+				Text: "build passed",
+			},
+		},
+		{
+			name: "Own bot message",
+			u:    Update{Type: "message", SubType: "bot_message", BotID: "B0", Username: "alice", Ts: "4.0", Text: "hi"},
+			want: nil,
+		},
+	}
+	for _, test := range tests {
+		ev, err := ch.chatEvent(context.Background(), &test.u)
+		if err != nil {
+			t.Errorf("%s: chatEvent(…)=_,%v", test.name, err)
+			continue
+		}
+		if test.want == nil {
+			if ev != nil {
+				t.Errorf("%s: chatEvent(…)=%#v, want nil", test.name, ev)
+			}
+			continue
+		}
+		if m, ok := ev.(chat.Message); !ok || !reflect.DeepEqual(m, *test.want) {
+			t.Errorf("%s: chatEvent(…)=%#v, want %#v", test.name, ev, *test.want)
+		}
+	}
+}
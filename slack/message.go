@@ -1,6 +1,10 @@
 package slack
 
-import "github.com/velour/chat"
+import (
+	"encoding/json"
+
+	"github.com/velour/chat"
+)
 
 // Update represents a RTS update message.
 type Update struct {
@@ -22,17 +26,99 @@ type Update struct {
 	Replies   []struct {
 		TS string `json:"ts"`
 	} `json:"replies"`
-	Attachments []struct {
-		Title      string `json:"title"`
-		ImageURL   string `json:"image_url"`
-		Footer     string `json:"footer"`
-		AuthorName string `json:"author_name"`
-	} `json:"attachments"`
+	Attachments []Attachment `json:"attachments"`
+	Blocks      []Block      `json:"blocks"`
+
+	// BotID and Username are the bot and its display name
+	// for messages posted by apps and integrations.
+	BotID    string `json:"bot_id"`
+	Username string `json:"username"`
+}
+
+// An Attachment is a secondary message attachment.
+type Attachment struct {
+	Fallback   string `json:"fallback"`
+	Pretext    string `json:"pretext"`
+	AuthorName string `json:"author_name"`
+	Title      string `json:"title"`
+	TitleLink  string `json:"title_link"`
+	Text       string `json:"text"`
+	Fields     []struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+	} `json:"fields"`
+	ImageURL string `json:"image_url"`
+	Footer   string `json:"footer"`
+
+	// FromURL is the URL of an unfurled link.
+	// It is empty if the Attachment is not a link unfurl.
+	FromURL string `json:"from_url"`
+}
+
+// A Block is a Block Kit layout block or block element.
+//
+// Blocks, block elements, composition objects, and rich text elements
+// share a single type, with only the fields relevant to the Type set.
+type Block struct {
+	Type string `json:"type"`
+
+	// Text is the text of text objects, text elements,
+	// section and header blocks, and buttons.
+	Text BlockText `json:"text"`
+
+	// Fields are the fields of a section block.
+	Fields []BlockText `json:"fields"`
+
+	// Elements are the elements of
+	// context, actions, and rich text blocks and elements.
+	Elements []Block `json:"elements"`
+
+	// Title is the title of an image block.
+	Title BlockText `json:"title"`
+
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+	URL      string `json:"url"`
+
+	// UserID, ChannelID, Name, Unicode, and Range
+	// are set for user, channel, emoji, and broadcast rich text elements.
+	UserID    string `json:"user_id"`
+	ChannelID string `json:"channel_id"`
+	Name      string `json:"name"`
+	Unicode   string `json:"unicode"`
+	Range     string `json:"range"`
+
+	// Style is "bullet" or "ordered" for a rich_text_list.
+	// For other rich text elements, it is an object of text styles.
+	Style json.RawMessage `json:"style"`
+}
+
+// BlockText is Block Kit text.
+// In JSON, it is either a string or a text object.
+type BlockText string
+
+// UnmarshalJSON unmarshals either a JSON string or a text object.
+func (t *BlockText) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*t = BlockText(s)
+		return nil
+	}
+	var obj struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	*t = BlockText(obj.Text)
+	return nil
 }
 
 // File represents a shared file.
 type File struct {
 	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	Size               int64  `json:"size"`
 	URLPrivateDownload string `json:"url_private_download"`
 	Mimetype           string `json:"mimetype"`
 }
@@ -54,6 +140,8 @@ type User struct {
 	Name    string `json:"name"`
 	Profile struct {
 		RealName string `json:"real_name"`
+		// BotID is the bot of a bot user.
+		BotID string `json:"bot_id"`
 
 		// Image is the largest profile icon available
 		Image string `json:"image_192"`
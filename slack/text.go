@@ -1,6 +1,7 @@
 package slack
 
 import (
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -13,8 +14,10 @@ import (
 // • Replaces user mentions like <U123456|nick> with the user's nick.
 // • Strips < and > surrounding links.
 // • :default-emoji: is replaced by the UTF-8 of the emoji.
-// This works for the default emoji set, but not custom emoji.
-func fixText(findUser func(id string) (string, bool), text string) string {
+// • :custom-emoji: is replaced by the UTF-8 of the emoji
+// if the findEmoji function maps it to UTF-8 (for example, an alias of a default emoji).
+// Otherwise custom emoji are left as is.
+func fixText(findUser, findEmoji func(string) (string, bool), text string) string {
 	var output []rune
 	for len(text) > 0 {
 		r, i := utf8.DecodeRuneInString(text)
@@ -53,6 +56,12 @@ func fixText(findUser func(id string) (string, bool), text string) string {
 						output = append(output, []rune(e)...)
 						break
 					}
+					if findEmoji != nil {
+						if e, ok := findEmoji(string(emoji)); ok {
+							output = append(output, []rune(e)...)
+							break
+						}
+					}
 					fallthrough
 				case unicode.IsSpace(r) || len(text) == 0:
 					output = append(output, ':')
@@ -99,6 +108,143 @@ func fixTag(findUser func(string) (string, bool), tag []rune) ([]rune, bool) {
 	return nil, false
 }
 
+// encodeText is the inverse of fixText.
+// It encodes display text as Slack message text.
+// Currently it:
+// • Escapes &, <, and > as HTML entities.
+// • Replaces @nick with a user mention like <@U123456>.
+// This uses the findUser function, which must map nick to U123456.
+// If the nick is not found, the text is left as is.
+// • Replaces #channel with a channel mention like <#C123456>.
+// This uses the findChannel function, which must map channel to C123456.
+// If the channel is not found, the text is left as is.
+// • Replaces @here, @channel, and @everyone with <!here>, <!channel>, and <!everyone>
+// if broadcast is true, otherwise they are left as is, and do not notify anyone.
+// • Replaces UTF-8 emoji in the default emoji set with :default-emoji:.
+func encodeText(findUser, findChannel func(name string) (string, bool), broadcast bool, text string) string {
+	var s strings.Builder
+	prev := ' '
+	for len(text) > 0 {
+		if e, name, ok := emojiPrefix(text); ok {
+			s.WriteString(":" + name + ":")
+			text = text[len(e):]
+			prev = ':'
+			continue
+		}
+		r, i := utf8.DecodeRuneInString(text)
+		switch {
+		case r == '&':
+			s.WriteString("&amp;")
+		case r == '<':
+			s.WriteString("&lt;")
+		case r == '>':
+			s.WriteString("&gt;")
+		case (r == '@' || r == '#') && !isNameRune(prev):
+			name := namePrefix(text[i:])
+			if tag, ok := encodeTag(findUser, findChannel, broadcast, r, name); ok {
+				s.WriteString(tag)
+				text = text[i+len(name):]
+				prev = '>'
+				continue
+			}
+			s.WriteRune(r)
+		default:
+			s.WriteRune(r)
+		}
+		text = text[i:]
+		prev = r
+	}
+	return s.String()
+}
+
+func encodeTag(findUser, findChannel func(string) (string, bool), broadcast bool, sigil rune, name string) (string, bool) {
+	switch {
+	case name == "":
+		return "", false
+
+	case sigil == '@' && (name == "here" || name == "channel" || name == "everyone"):
+		if broadcast {
+			return "<!" + name + ">", true
+		}
+
+	case sigil == '@' && findUser != nil:
+		if id, ok := findUser(name); ok {
+			return "<@" + id + ">", true
+		}
+
+	case sigil == '#' && findChannel != nil:
+		if id, ok := findChannel(name); ok {
+			return "<#" + id + ">", true
+		}
+	}
+	return "", false
+}
+
+// namePrefix returns the longest prefix of text
+// that is a valid Slack user or channel name,
+// not counting any trailing periods.
+func namePrefix(text string) string {
+	i := strings.IndexFunc(text, func(r rune) bool { return !isNameRune(r) })
+	if i < 0 {
+		i = len(text)
+	}
+	return strings.TrimRight(text[:i], ".")
+}
+
+// isNameRune returns whether r can appear in a Slack user or channel name.
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_'
+}
+
+// emojiPrefix returns the longest prefix of text
+// that is a UTF-8 emoji in the default emoji set,
+// along with the emoji's name.
+// A trailing variation selector is considered part of the emoji.
+func emojiPrefix(text string) (string, string, bool) {
+	if len(text) < 2 || text[0] < utf8.RuneSelf && text[1] < utf8.RuneSelf {
+		// No emoji begins with two ASCII bytes.
+		return "", "", false
+	}
+	n := maxEmojiLen
+	if n > len(text) {
+		n = len(text)
+	}
+	for ; n > 0; n-- {
+		if name, ok := emojiNames[text[:n]]; ok {
+			e := text[:n]
+			if strings.HasPrefix(text[n:], variationSelector) {
+				e += variationSelector
+			}
+			return e, name, true
+		}
+	}
+	return "", "", false
+}
+
+// variationSelector requests emoji presentation of the preceding character.
+const variationSelector = "\ufe0f"
+
+var (
+	// emojiNames maps UTF-8 emoji to their name in defaultEmoji.
+	// If an emoji has multiple names, the shortest name is used.
+	emojiNames = make(map[string]string, len(defaultEmoji))
+
+	// maxEmojiLen is the length in bytes of the longest emoji in emojiNames.
+	maxEmojiLen int
+)
+
+func init() {
+	for name, e := range defaultEmoji {
+		if n, ok := emojiNames[e]; ok && (len(n) < len(name) || len(n) == len(name) && n < name) {
+			continue
+		}
+		emojiNames[e] = name
+		if len(e) > maxEmojiLen {
+			maxEmojiLen = len(e)
+		}
+	}
+}
+
 func hasPrefix(text []rune, prefix string) bool {
 	for _, r := range prefix {
 		if len(text) == 0 || text[0] != r {
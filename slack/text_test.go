@@ -1,11 +1,12 @@
 package slack
 
 import (
+	"html"
 	"testing"
 )
 
 func testFixTest(t *testing.T, msg, expected string) {
-	actual := fixText(nil, msg)
+	actual := fixText(nil, nil, msg)
 	if len(actual) == 0 {
 		t.Errorf("Did not get any results.")
 	}
@@ -21,6 +22,12 @@ func TestFixTxt(t *testing.T) {
 		}
 		return "", false
 	}
+	findEmoji := func(name string) (string, bool) {
+		if name == "thumbsup_all" {
+			return "👍", true
+		}
+		return "", false
+	}
 	tests := []struct {
 		name, text, want string
 	}{
@@ -71,11 +78,87 @@ func TestFixTxt(t *testing.T) {
 			text: "prefix :copyright: mid:interrobang::relaxed:suffix",
 			want: "prefix © mid⁉☺suffix",
 		},
+		{
+			name: "Custom emoji alias",
+			text: "nice :thumbsup_all:",
+			want: "nice 👍",
+		},
+		{
+			name: "Custom emoji",
+			text: "nice :partyparrot:",
+			want: "nice :partyparrot:",
+		},
 	}
 	for _, test := range tests {
-		if got := fixText(findUser, test.text); got != test.want {
+		if got := fixText(findUser, findEmoji, test.text); got != test.want {
 			t.Errorf("%s fixText(_, %q)=%q, want %q",
 				test.name, test.text, got, test.want)
 		}
 	}
 }
+
+func TestEncodeText(t *testing.T) {
+	findUser := func(nick string) (string, bool) {
+		if nick == "found" {
+			return "Ufound", true
+		}
+		return "", false
+	}
+	findChannel := func(name string) (string, bool) {
+		if name == "theclub" {
+			return "C1A2B3C4D", true
+		}
+		return "", false
+	}
+	tests := []struct {
+		name, text, want string
+		broadcast        bool
+	}{
+		{name: "Empty", text: "", want: ""},
+		{name: "Plain", text: "hello, world", want: "hello, world"},
+		{name: "Escape", text: "a < b && b > c", want: "a &lt; b &amp;&amp; b &gt; c"},
+		{name: "Escape tag", text: "<!channel>", want: "&lt;!channel&gt;"},
+		{name: "User mention found", text: "hi @found", want: "hi <@Ufound>"},
+		{name: "User mention found, period", text: "hi @found.", want: "hi <@Ufound>."},
+		{name: "User mention unfound", text: "hi @notfound", want: "hi @notfound"},
+		{name: "Not a mention", text: "me@found.com", want: "me@found.com"},
+		{name: "Channel mention found", text: "see #theclub!", want: "see <#C1A2B3C4D>!"},
+		{name: "Channel mention unfound", text: "see #nowhere", want: "see #nowhere"},
+		{name: "Not a channel", text: "C#theclub", want: "C#theclub"},
+		{name: "Here", text: "@here look", want: "@here look"},
+		{name: "Channel", text: "@channel look", want: "@channel look"},
+		{name: "Everyone", text: "@everyone look", want: "@everyone look"},
+		{name: "Here broadcast", text: "@here look", want: "<!here> look", broadcast: true},
+		{name: "Channel broadcast", text: "@channel look", want: "<!channel> look", broadcast: true},
+		{name: "Emoji", text: "prefix © mid⁉☺suffix", want: "prefix :copyright: mid:interrobang::relaxed:suffix"},
+		{name: "Emoji variation selector", text: "☺️!", want: ":relaxed:!"},
+		{name: "Emoji flag", text: "🇺🇸", want: ":flag-us:"},
+		{name: "Emoji keycap", text: "#⃣ #theclub", want: ":hash: <#C1A2B3C4D>"},
+	}
+	for _, test := range tests {
+		got := encodeText(findUser, findChannel, test.broadcast, test.text)
+		if got != test.want {
+			t.Errorf("%s encodeText(_, _, %v, %q)=%q, want %q",
+				test.name, test.broadcast, test.text, got, test.want)
+		}
+	}
+}
+
+func TestEncodeTextRoundTrip(t *testing.T) {
+	findUser := func(string) (string, bool) { return "Ufound", true }
+	findNick := func(string) (string, bool) { return "found", true }
+	findChannel := func(string) (string, bool) { return "", false }
+	for _, text := range []string{
+		"hello",
+		"a < b && b > c",
+		"hi @found, how are you?",
+		"<!channel> &amp; friends",
+		"© ⁉ ☺",
+		"https://www.example.com/?a=1&b=2",
+	} {
+		enc := encodeText(findUser, findChannel, false, text)
+		if got := html.UnescapeString(fixText(findNick, nil, enc)); got != text {
+			t.Errorf("fixText(encodeText(%q))=%q", text, got)
+		}
+	}
+}
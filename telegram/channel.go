@@ -4,11 +4,13 @@ import (
 	"context"
 	"errors"
 	"io"
+	"log"
 	"net/url"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/velour/chat"
@@ -18,6 +20,10 @@ type channel struct {
 	client *Client
 	chat   Chat
 
+	// threadID is the thread ID of the channel's forum topic.
+	// If threadID is 0, the channel is the entire chat.
+	threadID uint64
+
 	// In simulates an infinite buffered channel
 	// of Updates from the Client to this channel.
 	// The Client publishes Updates without blocking.
@@ -35,6 +41,20 @@ type channel struct {
 	// and if it matches then web page preview is disabled
 	// for the message. If it is nil, nothing is matched.
 	noWebPreview *regexp.Regexp
+
+	sync.Mutex
+	// deleteDelay is the time that deleted message IDs are batched
+	// before they are deleted with a single deleteMessages call.
+	// If it is zero, messages are deleted immediately.
+	deleteDelay time.Duration
+	// pendingDeletes are message IDs waiting to be deleted.
+	pendingDeletes []int64
+	// deleteTimer flushes the pendingDeletes deleteDelay after the first.
+	deleteTimer *time.Timer
+	// flushes are the in-flight background flushes of pendingDeletes.
+	flushes sync.WaitGroup
+	// topic is the name of the forum topic, if known.
+	topic string
 }
 
 func newChannel(client *Client, chat Chat) *channel {
@@ -61,10 +81,50 @@ func (ch *channel) PrettyPrint() string {
 }
 
 func (ch *channel) Name() string {
+	ch.Lock()
+	var name string
 	if ch.chat.Title != nil {
-		return *ch.chat.Title
+		name = *ch.chat.Title
+	}
+	topic := ch.topic
+	ch.Unlock()
+	if ch.threadID != 0 {
+		if topic == "" {
+			topic = strconv.FormatUint(ch.threadID, 10)
+		}
+		name += "/" + topic
+	}
+	return name
+}
+
+// chatID returns the ID of the channel's chat.
+// The ID changes if the chat is migrated to a supergroup.
+func (ch *channel) chatID() int64 {
+	ch.Lock()
+	defer ch.Unlock()
+	return ch.chat.ID
+}
+
+// updateTopic updates the forum topic name
+// from a forum topic service message.
+func (ch *channel) updateTopic(msg *Message) {
+	ch.Lock()
+	defer ch.Unlock()
+	switch {
+	case msg.ForumTopicCreated != nil:
+		ch.topic = msg.ForumTopicCreated.Name
+	case msg.ForumTopicEdited != nil && msg.ForumTopicEdited.Name != nil:
+		ch.topic = *msg.ForumTopicEdited.Name
+	}
+}
+
+// sendRequest returns a new request for a send method to the channel.
+func (ch *channel) sendRequest() map[string]interface{} {
+	req := map[string]interface{}{"chat_id": ch.chatID()}
+	if ch.threadID != 0 {
+		req["message_thread_id"] = ch.threadID
 	}
-	return ""
+	return req
 }
 
 func (ch *channel) ServiceName() string { return "Telegram" }
@@ -104,119 +164,344 @@ func (ch *channel) Receive(ctx context.Context) (chat.Event, error) {
 // This signifies an Update that sholud be ignored.
 func chatEvent(ch *channel, u *Update) (chat.Event, error) {
 	switch {
+	case u.Message != nil && u.Message.MigrateFromChatID != nil:
+		// Migrations are reported by the Client when they happen,
+		// so they are never from before the channel was created.
+		return messageEvent(ch, u.Message), nil
+
 	case u.Message != nil && u.Message.Time().Before(ch.created):
 	case u.EditedMessage != nil && u.EditedMessage.Time().Before(ch.created):
+	case u.ChannelPost != nil && u.ChannelPost.Time().Before(ch.created):
+	case u.EditedChannelPost != nil && u.EditedChannelPost.Time().Before(ch.created):
 		// Ignore messages that originated before the channel was created.
 
-	case u.Message != nil && u.Message.From == nil:
-		// Ignore messages without a From field; chat.Message needs a From.
-
 	case u.Message != nil:
-		switch msg := u.Message; {
-		case msg.NewChatMember != nil:
-			who := chatUser(ch, *msg.NewChatMember)
-			return chat.Join{Who: *who}, nil
-
-		case msg.LeftChatMember != nil:
-			who := chatUser(ch, *msg.NewChatMember)
-			return chat.Leave{Who: *who}, nil
-
-		case msg.Document != nil:
-			if url := mediaURL(ch.client, msg.Document.FileID); url != "" {
-				return chat.Message{
-					ID:   chatMessageID(msg),
-					From: chatUser(ch, *msg.From),
-					Text: "/me shared a file: " + url,
-				}, nil
-			}
+		return messageEvent(ch, u.Message), nil
 
-		case msg.Photo != nil:
-			if url := mediaURL(ch.client, largestPhoto(*msg.Photo)); url != "" {
-				return chat.Message{
-					ID:   chatMessageID(msg),
-					From: chatUser(ch, *msg.From),
-					Text: "/me shared a photo: " + url,
-				}, nil
-			}
+	case u.ChannelPost != nil:
+		return messageEvent(ch, u.ChannelPost), nil
 
-		case msg.Sticker != nil:
-			fileID := msg.Sticker.FileID
-			if msg.Sticker.Thumb != nil {
-				fileID = msg.Sticker.Thumb.FileID
-			}
-			var icon string
-			if msg.Sticker.Emoji != nil {
-				icon = *msg.Sticker.Emoji
-			}
-			url := mediaURL(ch.client, fileID)
-			if url != "" {
-				// Slack does not unfurl URLs posted within the last hour.
-				// But we want stickers to unfurl each time they are posted.
-				// So, we add a nonce to the end, makeing each unique.
-				url = url + "?nonce=" + strconv.FormatInt(time.Now().UnixNano(), 16)
-			}
-			var text string
-			switch {
-			case icon != "" && url != "":
-				text = "/me sent a sticker " + icon + ": " + url
-			case icon != "" && url == "":
-				text = "/me sent a sticker " + icon
-			case icon == "" && url != "":
-				text = "/me sent a sticker: " + url
-			}
-			if text != "" {
-				return chat.Message{
-					ID:   chatMessageID(msg),
-					From: chatUser(ch, *msg.From),
-					Text: text,
-				}, nil
-			}
+	case u.EditedMessage != nil:
+		return editEvent(ch, u.EditedMessage), nil
 
-		case msg.Text != nil:
-			return *chatMessage(ch, msg), nil
-		}
+	case u.EditedChannelPost != nil:
+		return editEvent(ch, u.EditedChannelPost), nil
 
-	case u.EditedMessage != nil:
-		msg := u.EditedMessage
-		id := chatMessageID(msg)
-		return chat.Edit{OrigID: id, New: *chatMessage(ch, msg)}, nil
+	case u.CallbackQuery != nil && u.CallbackQuery.Message != nil:
+		q := u.CallbackQuery
+		cb := Callback{
+			Channel:   ch,
+			ID:        q.ID,
+			From:      chatUser(ch, q.From),
+			MessageID: chatMessageID(q.Message),
+			client:    ch.client,
+		}
+		if q.Data != nil {
+			cb.Data = *q.Data
+		}
+		return cb, nil
 	}
 	return nil, nil
 }
 
-func (ch *channel) Send(ctx context.Context, msg chat.Message) (chat.Message, error) {
+// A Callback is an event describing
+// a user pressing a callback button of an inline keyboard.
+// The Telegram client shows a progress bar
+// until the Callback is Answered.
+type Callback struct {
+	// Channel is the Channel of the message with the keyboard.
+	Channel chat.Channel
+
+	// ID is the unique identifier of the callback query.
+	ID string
+
+	// From is the User who pressed the button.
+	From *chat.User
+
+	// MessageID is the ID of the message with the keyboard.
+	MessageID chat.MessageID
+
+	// Data is the callback data of the button.
+	Data string
+
+	client *Client
+}
+
+func (e Callback) Origin() chat.Channel { return e.Channel }
+
+// Answer answers the Callback.
+// If text is non-empty, it is shown to the user
+// as a notification at the top of the chat screen,
+// or if alert is true, as an alert.
+func (e Callback) Answer(ctx context.Context, text string, alert bool) error {
 	req := map[string]interface{}{
-		"chat_id":                  ch.chat.ID,
-		"text":                     formatText(msg),
-		"parse_mode":               "HTML",
-		"disable_web_page_preview": ch.noWebPreview != nil && ch.noWebPreview.MatchString(msg.Text),
+		"callback_query_id": e.ID,
+		"show_alert":        alert,
+	}
+	if text != "" {
+		req["text"] = text
 	}
+	return rpc(ctx, e.client, "answerCallbackQuery", req, nil)
+}
+
+// messageEvent returns the chat event corresponding to a new Message,
+// or nil if the Message should be ignored.
+func messageEvent(ch *channel, msg *Message) chat.Event {
+	switch {
+	case msg.MigrateFromChatID != nil:
+		return Migrate{Channel: ch, FromID: *msg.MigrateFromChatID, ToID: msg.Chat.ID}
+
+	case msg.NewChatMember != nil:
+		who := chatUser(ch, *msg.NewChatMember)
+		return chat.Join{Who: *who}
+
+	case msg.LeftChatMember != nil:
+		who := chatUser(ch, *msg.LeftChatMember)
+		return chat.Leave{Who: *who}
+	}
+	if m := chatMessage(ch, msg); m != nil && m.Text != "" {
+		return *m
+	}
+	return nil
+}
+
+// editEvent returns the chat event corresponding to an edited Message,
+// or nil if the Message should be ignored.
+func editEvent(ch *channel, msg *Message) chat.Event {
+	if m := chatMessage(ch, msg); m != nil && m.Text != "" {
+		return chat.Edit{OrigID: chatMessageID(msg), New: *m}
+	}
+	return nil
+}
+
+// Send sends a message.
+//
+// If the message has Attachments, or its text is a link to an image or video,
+// the media are uploaded to Telegram, with the text as the caption.
+// If the media cannot be uploaded, for example, because they are too large,
+// the message is sent as text.
+func (ch *channel) Send(ctx context.Context, msg chat.Message) (chat.Message, error) {
+	if attachments := mediaAttachments(ctx, ch.client, msg); len(attachments) > 0 {
+		sent, err := sendMedia(ctx, ch, msg, attachments)
+		if err == nil {
+			return sent, nil
+		}
+		log.Printf("Failed to send Telegram media, sending as text: %s\n", err)
+	}
+	return sendText(ctx, ch, msg, nil)
+}
+
+// SendKeyboard sends a text message with an inline keyboard.
+// When a user presses a callback button of the keyboard,
+// a Callback event is received on the channel.
+func (ch *channel) SendKeyboard(ctx context.Context, msg chat.Message, keyboard InlineKeyboardMarkup) (chat.Message, error) {
+	return sendText(ctx, ch, msg, &keyboard)
+}
+
+// sendText sends a text message, with an optional inline keyboard.
+func sendText(ctx context.Context, ch *channel, msg chat.Message, keyboard *InlineKeyboardMarkup) (chat.Message, error) {
+	req := ch.sendRequest()
+	req["text"] = formatText(msg)
+	req["parse_mode"] = "HTML"
+	req["disable_web_page_preview"] = ch.noWebPreview != nil && ch.noWebPreview.MatchString(msg.Text)
 	if msg.ReplyTo != nil {
 		req["reply_to_message_id"] = msg.ReplyTo.ID
 	}
+	if keyboard != nil {
+		req["reply_markup"] = keyboard
+	}
 	var resp Message
-	if err := rpc(ctx, ch.client, "sendMessage", req, &resp); err != nil {
+	err := rpc(ctx, ch.client, "sendMessage", req, &resp)
+	if to, ok := migratedTo(err); ok {
+		// The group was upgraded to a supergroup.
+		// Resend to the supergroup.
+		migrate(ch.client, req["chat_id"].(int64), to)
+		req["chat_id"] = to
+		err = rpc(ctx, ch.client, "sendMessage", req, &resp)
+	}
+	if err != nil {
 		return chat.Message{}, err
 	}
 	msg.ID = chatMessageID(&resp)
 	return msg, nil
 }
 
-// Delete is a no-op for Telegram, as it's bot API doesn't support message deletion.
-func (ch *channel) Delete(context.Context, chat.Message) error { return nil }
+const (
+	// maxDeleteBatch is the maximum number of messages
+	// that can be deleted by a single deleteMessages call.
+	maxDeleteBatch = 100
+	// deleteTimeout is the timeout for deleting a batch of messages.
+	deleteTimeout = time.Minute
+	// deletedText replaces the text of messages that are too old to delete.
+	deletedText = "<i>This message was deleted.</i>"
+)
+
+// BatchDeletes sets the time that deleted messages are batched.
+//
+// If d is non-zero, Delete returns immediately,
+// and the messages are deleted together, in bulk,
+// d after the first message of the batch was Deleted.
+// Errors deleting batched messages are logged.
+// Messages still pending are deleted when the Client is closed.
+// If d is zero, messages are deleted at the call to Delete.
+func (ch *channel) BatchDeletes(d time.Duration) {
+	ch.Lock()
+	ch.deleteDelay = d
+	ch.Unlock()
+}
+
+// Delete deletes a message.
+// Deleting a message that is not found is not an error.
+//
+// Telegram only allows deleting messages sent in the last 48 hours.
+// Older messages are instead edited to say that they were deleted.
+func (ch *channel) Delete(ctx context.Context, msg chat.Message) error {
+	id, err := strconv.ParseInt(string(msg.ID), 10, 64)
+	if err != nil {
+		return errors.New("invalid message ID: " + string(msg.ID))
+	}
+
+	ch.Lock()
+	if ch.deleteDelay == 0 {
+		ch.Unlock()
+		return deleteMessage(ctx, ch, id)
+	}
+	ch.pendingDeletes = append(ch.pendingDeletes, id)
+	switch len(ch.pendingDeletes) {
+	case 1:
+		ch.deleteTimer = time.AfterFunc(ch.deleteDelay, ch.flushDeletes)
+	case maxDeleteBatch:
+		ch.deleteTimer.Stop()
+		go ch.flushDeletes()
+	}
+	ch.Unlock()
+	return nil
+}
+
+// flushDeletes deletes all pending deletes in the background,
+// until the Client is closed.
+func (ch *channel) flushDeletes() {
+	ch.Lock()
+	ids := ch.pendingDeletes
+	ch.pendingDeletes = nil
+	if len(ids) == 0 {
+		ch.Unlock()
+		return
+	}
+	ch.flushes.Add(1)
+	ch.Unlock()
+	defer ch.flushes.Done()
+
+	ctx, cancel := context.WithTimeout(ch.client.ctx, deleteTimeout)
+	defer cancel()
+	deleteBatches(ctx, ch, ids)
+}
+
+// closeDeletes stops batching deletes,
+// deletes all pending deletes,
+// and waits for any background flushes to finish.
+func (ch *channel) closeDeletes(ctx context.Context) {
+	ch.Lock()
+	if ch.deleteTimer != nil {
+		ch.deleteTimer.Stop()
+	}
+	ch.deleteDelay = 0
+	ids := ch.pendingDeletes
+	ch.pendingDeletes = nil
+	ch.Unlock()
+
+	deleteBatches(ctx, ch, ids)
+	ch.flushes.Wait()
+}
+
+// deleteBatches deletes the messages in batches of up to maxDeleteBatch.
+// Errors are logged.
+func deleteBatches(ctx context.Context, ch *channel, ids []int64) {
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > maxDeleteBatch {
+			n = maxDeleteBatch
+		}
+		if err := deleteMessages(ctx, ch, ids[:n]); err != nil {
+			log.Printf("Failed to delete Telegram messages %v: %s\n", ids[:n], err)
+		}
+		ids = ids[n:]
+	}
+}
+
+// deleteMessages deletes messages with a single deleteMessages call.
+// If that fails, for example because some messages are too old,
+// the messages are deleted one at a time.
+func deleteMessages(ctx context.Context, ch *channel, ids []int64) error {
+	req := map[string]interface{}{
+		"chat_id":     ch.chatID(),
+		"message_ids": ids,
+	}
+	if err := rpc(ctx, ch.client, "deleteMessages", req, nil); err == nil {
+		return nil
+	}
+	var firstErr error
+	for _, id := range ids {
+		if err := deleteMessage(ctx, ch, id); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func deleteMessage(ctx context.Context, ch *channel, id int64) error {
+	req := map[string]interface{}{
+		"chat_id":    ch.chatID(),
+		"message_id": id,
+	}
+	err := rpc(ctx, ch.client, "deleteMessage", req, nil)
+	switch {
+	case err == nil:
+		return nil
+	case strings.Contains(err.Error(), "message to delete not found"):
+		// It's already gone.
+		return nil
+	case strings.Contains(err.Error(), "message can't be deleted"):
+		// The message is too old to delete.
+		// The best we can do is to blank it out.
+		req["text"] = deletedText
+		req["parse_mode"] = "HTML"
+		if editErr := rpc(ctx, ch.client, "editMessageText", req, nil); editErr != nil &&
+			!strings.Contains(editErr.Error(), "message is not modified") {
+			return err
+		}
+		return nil
+	default:
+		return err
+	}
+}
 
 func (ch *channel) Edit(ctx context.Context, msg chat.Message) (chat.Message, error) {
 	if msg.ID == "" {
 		return chat.Message{}, errors.New("invalid, empty message ID")
 	}
 	req := map[string]interface{}{
-		"chat_id":    ch.chat.ID,
+		"chat_id":    ch.chatID(),
 		"message_id": msg.ID,
 		"text":       formatText(msg),
 		"parse_mode": "HTML",
 	}
 	var resp Message
-	if err := rpc(ctx, ch.client, "editMessageText", req, &resp); err != nil {
+	err := rpc(ctx, ch.client, "editMessageText", req, &resp)
+	if err != nil && strings.Contains(err.Error(), "no text in the message to edit") {
+		// It is a media message, so edit its caption.
+		delete(req, "text")
+		attachments := msg.Attachments
+		if len(attachments) == 0 {
+			// The media may have been sent for the sole link of the text.
+			if link, ok := soleLink(msg.Text); ok {
+				attachments = []chat.Attachment{{URL: link}}
+			}
+		}
+		req["caption"] = mediaCaption(msg, attachments)
+		err = rpc(ctx, ch.client, "editMessageCaption", req, &resp)
+	}
+	if err != nil {
 		if strings.Contains(err.Error(), "message is not modified") {
 			// Ignore this. It is not an error.
 			return msg, nil
@@ -231,27 +516,267 @@ func chatMessageID(m *Message) chat.MessageID {
 	return chat.MessageID(strconv.FormatUint(m.MessageID, 10))
 }
 
+// messageText returns the text or caption of a Message,
+// with its entities rendered.
 func messageText(m *Message) string {
-	var text string
-	if m.Text != nil {
-		text = *m.Text
+	switch {
+	case m.Text != nil && m.Entities != nil:
+		return entityText(*m.Text, *m.Entities)
+	case m.Text != nil:
+		return *m.Text
+	case m.Caption != nil && m.CaptionEntities != nil:
+		return entityText(*m.Caption, *m.CaptionEntities)
+	case m.Caption != nil:
+		return *m.Caption
+	default:
+		return ""
 	}
-	return text
 }
 
-// chatMessage assumes that m.From != nil.
+// chatMessage returns the chat.Message for a Message,
+// or nil if the Message has no sender.
+//
+// Media, and other non-text messages, are rendered as text,
+// followed by the caption, if any,
+// and their files are added as Attachments.
 func chatMessage(ch *channel, m *Message) *chat.Message {
+	from := sender(ch, m)
+	if from == nil {
+		return nil
+	}
 	msg := &chat.Message{
 		ID:   chatMessageID(m),
-		From: chatUser(ch, *m.From),
+		From: from,
 		Text: messageText(m),
 	}
-	if m.ReplyToMessage != nil && m.ReplyToMessage.From != nil {
+	if text, attachments := mediaText(ch, m); text != "" {
+		if msg.Text != "" {
+			text += "\n" + msg.Text
+		}
+		msg.Text = text
+		msg.Attachments = attachments
+	}
+	if m.ReplyToMessage != nil && m.ReplyToMessage.ForumTopicCreated == nil {
+		// Messages in a forum topic that are not replies
+		// are replies to the topic's creation message.
+		// They are not considered replies.
 		msg.ReplyTo = chatMessage(ch, m.ReplyToMessage)
 	}
 	return msg
 }
 
+// sender returns the chat.User that sent a Message,
+// or nil if the sender is unknown.
+//
+// Messages sent on behalf of a chat,
+// such as channel posts and messages from anonymous group administrators,
+// are from a chat.User representing the chat,
+// named by the author signature, if any.
+func sender(ch *channel, m *Message) *chat.User {
+	if m.SenderChat == nil {
+		if m.From == nil {
+			return nil
+		}
+		return chatUser(ch, *m.From)
+	}
+	var nick, name string
+	switch sc := m.SenderChat; {
+	case sc.Username != nil:
+		nick = *sc.Username
+	case sc.Title != nil:
+		nick = *sc.Title
+	default:
+		nick = strconv.FormatInt(sc.ID, 10)
+	}
+	name = nick
+	if sc := m.SenderChat; sc.Title != nil {
+		name = *sc.Title
+	}
+	if m.AuthorSignature != nil && *m.AuthorSignature != "" {
+		name = *m.AuthorSignature
+	}
+	return &chat.User{
+		ID:          chat.UserID(strconv.FormatInt(m.SenderChat.ID, 10)),
+		Nick:        nick,
+		FullName:    name,
+		DisplayName: name,
+		Channel:     ch,
+	}
+}
+
+// mediaText returns the text describing
+// media and other non-text content of a Message,
+// and Attachments for any files.
+// If the Message has only text, mediaText returns the empty string.
+func mediaText(ch *channel, m *Message) (string, []chat.Attachment) {
+	switch {
+	case m.Animation != nil:
+		a := m.Animation
+		return sharedFile(ch, "a GIF", a.FileID, a.FileName, a.MimeType, a.FileSize)
+
+	case m.Document != nil:
+		d := m.Document
+		return sharedFile(ch, "a file", d.FileID, d.FileName, d.MimeType, d.FileSize)
+
+	case m.Photo != nil:
+		jpeg := "image/jpeg"
+		return sharedFile(ch, "a photo", largestPhoto(*m.Photo), nil, &jpeg, nil)
+
+	case m.Video != nil:
+		v := m.Video
+		return sharedFile(ch, "a video", v.FileID, v.FileName, v.MimeType, v.FileSize)
+
+	case m.Voice != nil:
+		v := m.Voice
+		return sharedFile(ch, "a voice message", v.FileID, nil, v.MimeType, v.FileSize)
+
+	case m.Audio != nil:
+		a := m.Audio
+		what := "audio"
+		var performer, title string
+		if a.Performer != nil {
+			performer = *a.Performer
+		}
+		if a.Title != nil {
+			title = *a.Title
+		}
+		if t := joinNonEmpty(" — ", performer, title); t != "" {
+			what += " " + t
+		}
+		return sharedFile(ch, what, a.FileID, a.FileName, a.MimeType, a.FileSize)
+
+	case m.Sticker != nil:
+		return stickerText(ch, m.Sticker), nil
+
+	case m.Venue != nil:
+		v := m.Venue
+		what := joinNonEmpty(", ", v.Title, v.Address)
+		return "/me shared a location " + what + ": " + mapURL(v.Location), nil
+
+	case m.Location != nil:
+		return "/me shared a location: " + mapURL(*m.Location), nil
+
+	case m.Contact != nil:
+		c := m.Contact
+		name := c.FirstName
+		if c.LastName != nil {
+			name = strings.TrimSpace(name + " " + *c.LastName)
+		}
+		return "/me shared a contact: " + joinNonEmpty(", ", name, c.PhoneNumber), nil
+
+	case m.Poll != nil:
+		p := m.Poll
+		what := "a poll"
+		if p.Type == "quiz" {
+			what = "a quiz"
+		}
+		lines := []string{"/me started " + what + ": " + p.Question}
+		for _, o := range p.Options {
+			lines = append(lines, "• "+o.Text)
+		}
+		return strings.Join(lines, "\n"), nil
+
+	case m.Dice != nil:
+		return "/me rolled " + m.Dice.Emoji + " " + strconv.Itoa(m.Dice.Value), nil
+
+	case m.Game != nil:
+		return "/me shared a game: " + m.Game.Title, nil
+
+	case m.ForumTopicCreated != nil:
+		return "/me created the topic " + m.ForumTopicCreated.Name, nil
+
+	case m.ForumTopicEdited != nil && m.ForumTopicEdited.Name != nil:
+		return "/me renamed the topic to " + *m.ForumTopicEdited.Name, nil
+
+	case m.ForumTopicEdited != nil:
+		return "/me changed the topic icon", nil
+
+	case m.ForumTopicClosed != nil:
+		return "/me closed the topic", nil
+
+	case m.ForumTopicReopened != nil:
+		return "/me reopened the topic", nil
+
+	case m.PinnedMessage != nil:
+		text := messageText(m.PinnedMessage)
+		if t, _ := mediaText(ch, m.PinnedMessage); t != "" {
+			text = joinNonEmpty("\n", strings.TrimPrefix(t, "/me "), text)
+		}
+		if text == "" {
+			return "/me pinned a message", nil
+		}
+		return "/me pinned a message: " + text, nil
+	}
+	return "", nil
+}
+
+// sharedFile returns the text and Attachment for a shared file.
+// If the Client has no local URL set, the text has no link,
+// and there is no Attachment.
+func sharedFile(ch *channel, what, fileID string, name, mimeType *string, size *int) (string, []chat.Attachment) {
+	url := mediaURL(ch.client, fileID)
+	if url == "" {
+		return "/me shared " + what, nil
+	}
+	a := chat.Attachment{URL: url}
+	if name != nil {
+		a.Name = *name
+	}
+	if mimeType != nil {
+		a.MimeType = *mimeType
+	}
+	if size != nil {
+		a.Size = int64(*size)
+	}
+	return "/me shared " + what + ": " + url, []chat.Attachment{a}
+}
+
+func stickerText(ch *channel, sticker *Sticker) string {
+	fileID := sticker.FileID
+	if sticker.Thumb != nil {
+		fileID = sticker.Thumb.FileID
+	}
+	var icon string
+	if sticker.Emoji != nil {
+		icon = *sticker.Emoji
+	}
+	url := mediaURL(ch.client, fileID)
+	if url != "" {
+		// Slack does not unfurl URLs posted within the last hour.
+		// But we want stickers to unfurl each time they are posted.
+		// So, we add a nonce to the end, makeing each unique.
+		url = url + "?nonce=" + strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	switch {
+	case icon != "" && url != "":
+		return "/me sent a sticker " + icon + ": " + url
+	case icon != "" && url == "":
+		return "/me sent a sticker " + icon
+	case icon == "" && url != "":
+		return "/me sent a sticker: " + url
+	default:
+		return "/me sent a sticker"
+	}
+}
+
+// mapURL returns an OpenStreetMap URL showing the Location.
+func mapURL(l Location) string {
+	lat := strconv.FormatFloat(l.Latitude, 'f', -1, 64)
+	lon := strconv.FormatFloat(l.Longitude, 'f', -1, 64)
+	return "https://www.openstreetmap.org/?mlat=" + lat + "&mlon=" + lon
+}
+
+// joinNonEmpty joins the non-empty strings with the separator.
+func joinNonEmpty(sep string, strs ...string) string {
+	var nonEmpty []string
+	for _, s := range strs {
+		if s != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	return strings.Join(nonEmpty, sep)
+}
+
 // chatUser returns a chat.User from a User.
 // Must not be called with the ch.client Lock held.
 func chatUser(ch *channel, user User) *chat.User {
@@ -274,10 +799,11 @@ func chatUser(ch *channel, user User) *chat.User {
 func userPhotoURL(c *Client, userID int64) (string, bool) {
 	c.Lock()
 	defer c.Unlock()
-	u, ok := c.users[userID]
+	v, ok := c.users.Get(userID)
 	if c.localURL == nil || !ok {
 		return "", false
 	}
+	u := v.(*user)
 	u.Lock()
 	defer u.Unlock()
 	newURL, _ := url.Parse(c.localURL.String())
@@ -0,0 +1,197 @@
+package telegram
+
+import (
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/velour/chat"
+	"github.com/velour/chat/cache"
+)
+
+func TestChatEvent(t *testing.T) {
+	localURL, _ := url.Parse("http://localhost/media")
+	ch := &channel{
+		client: &Client{
+			users:    cache.New(0, 0),
+			localURL: localURL,
+		},
+	}
+	alice := &chat.User{ID: "2", Nick: "alice", FullName: "Alice", DisplayName: "Alice", Channel: ch}
+	bob := &chat.User{ID: "3", Nick: "Bob", FullName: "Bob", DisplayName: "Bob", Channel: ch}
+
+	tests := []struct {
+		name   string
+		update string
+		want   chat.Event
+	}{
+		{
+			name:   "text",
+			update: `{"message":{"message_id":1,"from":{"id":2,"first_name":"Alice","username":"alice"},"text":"hello"}}`,
+			want:   chat.Message{ID: "1", From: alice, Text: "hello"},
+		},
+		{
+			name:   "no sender",
+			update: `{"message":{"message_id":1,"text":"hello"}}`,
+			want:   nil,
+		},
+		{
+			name:   "left",
+			update: `{"message":{"message_id":1,"from":{"id":2,"first_name":"Alice","username":"alice"},"left_chat_member":{"id":3,"first_name":"Bob"}}}`,
+			want:   chat.Leave{Who: *bob},
+		},
+		{
+			name:   "photo with caption",
+			update: `{"message":{"message_id":1,"from":{"id":2,"first_name":"Alice","username":"alice"},"photo":[{"file_id":"small","width":1,"height":1},{"file_id":"big","width":10,"height":10}],"caption":"my cat"}}`,
+			want: chat.Message{
+				ID:          "1",
+				From:        alice,
+				Text:        "/me shared a photo: http://localhost/media/big\nmy cat",
+				Attachments: []chat.Attachment{{URL: "http://localhost/media/big", MimeType: "image/jpeg"}},
+			},
+		},
+		{
+			name:   "animation",
+			update: `{"message":{"message_id":1,"from":{"id":2,"first_name":"Alice","username":"alice"},"animation":{"file_id":"anim","file_name":"a.mp4","mime_type":"video/mp4","file_size":5},"document":{"file_id":"anim"}}}`,
+			want: chat.Message{
+				ID:          "1",
+				From:        alice,
+				Text:        "/me shared a GIF: http://localhost/media/anim",
+				Attachments: []chat.Attachment{{URL: "http://localhost/media/anim", Name: "a.mp4", MimeType: "video/mp4", Size: 5}},
+			},
+		},
+		{
+			name:   "video",
+			update: `{"message":{"message_id":1,"from":{"id":2,"first_name":"Alice","username":"alice"},"video":{"file_id":"vid","mime_type":"video/mp4"}}}`,
+			want: chat.Message{
+				ID:          "1",
+				From:        alice,
+				Text:        "/me shared a video: http://localhost/media/vid",
+				Attachments: []chat.Attachment{{URL: "http://localhost/media/vid", MimeType: "video/mp4"}},
+			},
+		},
+		{
+			name:   "voice",
+			update: `{"message":{"message_id":1,"from":{"id":2,"first_name":"Alice","username":"alice"},"voice":{"file_id":"v","mime_type":"audio/ogg"}}}`,
+			want: chat.Message{
+				ID:          "1",
+				From:        alice,
+				Text:        "/me shared a voice message: http://localhost/media/v",
+				Attachments: []chat.Attachment{{URL: "http://localhost/media/v", MimeType: "audio/ogg"}},
+			},
+		},
+		{
+			name:   "audio",
+			update: `{"message":{"message_id":1,"from":{"id":2,"first_name":"Alice","username":"alice"},"audio":{"file_id":"a","performer":"Band","title":"Song"}}}`,
+			want: chat.Message{
+				ID:          "1",
+				From:        alice,
+				Text:        "/me shared audio Band — Song: http://localhost/media/a",
+				Attachments: []chat.Attachment{{URL: "http://localhost/media/a"}},
+			},
+		},
+		{
+			name:   "location",
+			update: `{"message":{"message_id":1,"from":{"id":2,"first_name":"Alice","username":"alice"},"location":{"latitude":40.5,"longitude":-74.25}}}`,
+			want:   chat.Message{ID: "1", From: alice, Text: "/me shared a location: https://www.openstreetmap.org/?mlat=40.5&mlon=-74.25"},
+		},
+		{
+			name:   "venue",
+			update: `{"message":{"message_id":1,"from":{"id":2,"first_name":"Alice","username":"alice"},"location":{"latitude":1,"longitude":2},"venue":{"location":{"latitude":1,"longitude":2},"title":"Cafe","address":"1 Main St"}}}`,
+			want:   chat.Message{ID: "1", From: alice, Text: "/me shared a location Cafe, 1 Main St: https://www.openstreetmap.org/?mlat=1&mlon=2"},
+		},
+		{
+			name:   "contact",
+			update: `{"message":{"message_id":1,"from":{"id":2,"first_name":"Alice","username":"alice"},"contact":{"phone_number":"+1 555 1234","first_name":"Carol","last_name":"C"}}}`,
+			want:   chat.Message{ID: "1", From: alice, Text: "/me shared a contact: Carol C, +1 555 1234"},
+		},
+		{
+			name:   "poll",
+			update: `{"message":{"message_id":1,"from":{"id":2,"first_name":"Alice","username":"alice"},"poll":{"id":"p","question":"Lunch?","options":[{"text":"Yes"},{"text":"No"}],"type":"regular"}}}`,
+			want:   chat.Message{ID: "1", From: alice, Text: "/me started a poll: Lunch?\n• Yes\n• No"},
+		},
+		{
+			name:   "dice",
+			update: `{"message":{"message_id":1,"from":{"id":2,"first_name":"Alice","username":"alice"},"dice":{"emoji":"🎲","value":4}}}`,
+			want:   chat.Message{ID: "1", From: alice, Text: "/me rolled 🎲 4"},
+		},
+		{
+			name:   "pinned",
+			update: `{"message":{"message_id":2,"from":{"id":2,"first_name":"Alice","username":"alice"},"pinned_message":{"message_id":1,"from":{"id":3,"first_name":"Bob"},"text":"important"}}}`,
+			want:   chat.Message{ID: "2", From: alice, Text: "/me pinned a message: important"},
+		},
+		{
+			name:   "topic created",
+			update: `{"message":{"message_id":5,"message_thread_id":5,"is_topic_message":true,"from":{"id":2,"first_name":"Alice","username":"alice"},"forum_topic_created":{"name":"Lunch","icon_color":7322096}}}`,
+			want:   chat.Message{ID: "5", From: alice, Text: "/me created the topic Lunch"},
+		},
+		{
+			name:   "topic renamed",
+			update: `{"message":{"message_id":6,"message_thread_id":5,"is_topic_message":true,"from":{"id":2,"first_name":"Alice","username":"alice"},"forum_topic_edited":{"name":"Dinner"}}}`,
+			want:   chat.Message{ID: "6", From: alice, Text: "/me renamed the topic to Dinner"},
+		},
+		{
+			name:   "topic closed",
+			update: `{"message":{"message_id":7,"message_thread_id":5,"is_topic_message":true,"from":{"id":2,"first_name":"Alice","username":"alice"},"forum_topic_closed":{}}}`,
+			want:   chat.Message{ID: "7", From: alice, Text: "/me closed the topic"},
+		},
+		{
+			name:   "topic reopened",
+			update: `{"message":{"message_id":8,"message_thread_id":5,"is_topic_message":true,"from":{"id":2,"first_name":"Alice","username":"alice"},"forum_topic_reopened":{}}}`,
+			want:   chat.Message{ID: "8", From: alice, Text: "/me reopened the topic"},
+		},
+		{
+			name:   "topic message is not a reply to the topic root",
+			update: `{"message":{"message_id":9,"message_thread_id":5,"is_topic_message":true,"from":{"id":2,"first_name":"Alice","username":"alice"},"text":"hi","reply_to_message":{"message_id":5,"from":{"id":2,"first_name":"Alice","username":"alice"},"forum_topic_created":{"name":"Lunch"}}}}`,
+			want:   chat.Message{ID: "9", From: alice, Text: "hi"},
+		},
+		{
+			name:   "topic reply",
+			update: `{"message":{"message_id":10,"message_thread_id":5,"is_topic_message":true,"from":{"id":2,"first_name":"Alice","username":"alice"},"text":"yes","reply_to_message":{"message_id":9,"from":{"id":2,"first_name":"Alice","username":"alice"},"text":"hi"}}}`,
+			want: chat.Message{
+				ID:      "10",
+				From:    alice,
+				Text:    "yes",
+				ReplyTo: &chat.Message{ID: "9", From: alice, Text: "hi"},
+			},
+		},
+		{
+			name:   "channel post",
+			update: `{"channel_post":{"message_id":1,"sender_chat":{"id":-100,"type":"channel","title":"News","username":"news"},"author_signature":"Dana","text":"extra"}}`,
+			want: chat.Message{
+				ID:   "1",
+				From: &chat.User{ID: "-100", Nick: "news", FullName: "Dana", DisplayName: "Dana", Channel: ch},
+				Text: "extra",
+			},
+		},
+		{
+			name:   "edited caption",
+			update: `{"edited_message":{"message_id":1,"from":{"id":2,"first_name":"Alice","username":"alice"},"document":{"file_id":"doc","file_name":"a.pdf"},"caption":"new"}}`,
+			want: chat.Edit{
+				OrigID: "1",
+				New: chat.Message{
+					ID:          "1",
+					From:        alice,
+					Text:        "/me shared a file: http://localhost/media/doc\nnew",
+					Attachments: []chat.Attachment{{URL: "http://localhost/media/doc", Name: "a.pdf"}},
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		var u Update
+		if err := json.Unmarshal([]byte(test.update), &u); err != nil {
+			t.Errorf("%s: failed to unmarshal update: %s", test.name, err)
+			continue
+		}
+		got, err := chatEvent(ch, &u)
+		if err != nil {
+			t.Errorf("%s: chatEvent(_, %s)=_,%v", test.name, test.update, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: chatEvent(_, %s)=%#v, want %#v", test.name, test.update, got, test.want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ package telegram
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"image/png"
@@ -14,19 +15,29 @@ import (
 	"net/url"
 	"path"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/velour/chat"
+	"github.com/velour/chat/cache"
 	"golang.org/x/image/webp"
 )
 
 const (
+	defaultAPIURL      = "https://api.telegram.org"
 	longPollSeconds    = 100
 	minPhotoUpdateTime = 30 * time.Minute
 	megabyte           = 1000000
 	// Telegram's filesize limit for bots is 20 megabytes.
 	fileSizeLimit = 20 * megabyte
+	// maxUsers is the maximum number of users to remember.
+	maxUsers = 10000
+	// maxMedia is the maximum number of file URLs to remember.
+	maxMedia = 1000
+	// mediaTTL is how long to remember a file URL.
+	// The URL is valid for an hour; expire it a bit before to be safe.
+	mediaTTL = 50 * time.Minute
 )
 
 var _ chat.Client = &Client{}
@@ -35,17 +46,45 @@ var _ chat.Client = &Client{}
 type Client struct {
 	token string
 	me    User
+	// apiURL is the base URL of the Bot API server.
+	apiURL string
+	// webhookSecret is the secret token for webhook requests.
+	webhookSecret string
+	// updates publishes Updates, from either polling or the webhook,
+	// to the demux goroutine.
+	updates chan []Update
 	// pollError communicates any errors during getUpdate polling
 	// to the Close method.
 	pollError chan error
+	// ctx is the Context of the background goroutines.
+	ctx context.Context
 	// Cancel cancels the background goroutines.
 	cancel context.CancelFunc
+	// done is closed when the background goroutines are cancelled.
+	done <-chan struct{}
+	// httpClient makes all HTTP requests of the Client,
+	// using its own transport.
+	httpClient *http.Client
+	transport  *http.Transport
+	// limiter rate limits sends.
+	limiter *limiter
 
 	sync.Mutex
-	channels map[int64]*channel
-	users    map[int64]*user
-	media    map[string]*media
+	channels map[channelKey]*channel
 	localURL *url.URL
+
+	// users caches *user, keyed by the int64 user ID.
+	users *cache.Cache
+	// media caches File, keyed by the string file ID.
+	media *cache.Cache
+}
+
+// A channelKey identifies a channel.
+type channelKey struct {
+	chatID int64
+	// threadID is the forum topic of the channel.
+	// If threadID is 0, the channel is the entire chat.
+	threadID uint64
 }
 
 type user struct {
@@ -57,21 +96,54 @@ type user struct {
 	photoTime time.Time
 }
 
-type media struct {
-	sync.Mutex
-	File
-	// Expires is the time that the URL expires.
-	expires time.Time
+// Options are optional settings for a Client.
+type Options struct {
+	// APIURL is the base URL of the Bot API server.
+	// If empty, https://api.telegram.org is used.
+	APIURL string
+
+	// Webhook, if true, disables long polling for updates.
+	// Instead, updates must be delivered to the Client's WebhookHandler.
+	Webhook bool
+
+	// WebhookSecret, if non-empty, is the secret token
+	// registered with Telegram by SetWebhook.
+	// The WebhookHandler rejects requests
+	// without the secret token in their
+	// X-Telegram-Bot-Api-Secret-Token header.
+	WebhookSecret string
 }
 
 // Dial returns a new Client using the given token.
+// The Client long-polls Telegram for updates.
 func Dial(ctx context.Context, token string) (*Client, error) {
+	return DialOptions(ctx, token, Options{})
+}
+
+// DialOptions returns a new Client using the given token and Options.
+func DialOptions(ctx context.Context, token string, opts Options) (*Client, error) {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
 	c := &Client{
-		token:     token,
-		pollError: make(chan error, 1),
-		channels:  make(map[int64]*channel),
-		users:     make(map[int64]*user),
-		media:     make(map[string]*media),
+		httpClient:    &http.Client{Transport: transport},
+		transport:     transport,
+		limiter:       newLimiter(),
+		token:         token,
+		apiURL:        strings.TrimSuffix(opts.APIURL, "/"),
+		webhookSecret: opts.WebhookSecret,
+		updates:       make(chan []Update, 1),
+		pollError:     make(chan error, 1),
+		channels:      make(map[channelKey]*channel),
+		users:         cache.New(maxUsers, 0),
+		media:         cache.New(maxMedia, mediaTTL),
+	}
+	if c.apiURL == "" {
+		c.apiURL = defaultAPIURL
 	}
 	if err := rpc(ctx, c, "getMe", nil, &c.me); err != nil {
 		return nil, err
@@ -79,21 +151,37 @@ func Dial(ctx context.Context, token string) (*Client, error) {
 
 	bkg := context.Background()
 	bkg, c.cancel = context.WithCancel(bkg)
-	updates := make(chan []Update, 1)
-	go poll(bkg, c, updates)
-	go demux(bkg, c, updates)
+	c.ctx = bkg
+	c.done = bkg.Done()
+	if !opts.Webhook {
+		go poll(bkg, c, c.updates)
+	}
+	go demux(bkg, c, c.updates)
 
 	return c, nil
 }
 
 // Join returns a chat.Channel corresponding to
-// a Telegram group, supergroup, chat, or channel ID.
-// The ID string must be the base 10 chat ID number.
+// a Telegram group, supergroup, chat, or channel ID,
+// or to a forum topic of a supergroup.
+// The ID string must be the base 10 chat ID number,
+// or for a forum topic, the chat ID number and the topic's thread ID number
+// separated by a slash: chatID/threadID.
+//
+// Messages in forum topics are received on the forum topic's Channel,
+// if it has been joined, and otherwise on the chat's Channel.
 func (c *Client) Join(ctx context.Context, idString string) (chat.Channel, error) {
 	var err error
 	var req struct {
 		ChatID int64 `json:"chat_id"`
 	}
+	var threadID uint64
+	if i := strings.IndexRune(idString, '/'); i >= 0 {
+		if threadID, err = strconv.ParseUint(idString[i+1:], 10, 64); err != nil {
+			return nil, err
+		}
+		idString = idString[:i]
+	}
 	if req.ChatID, err = strconv.ParseInt(idString, 10, 64); err != nil {
 		return nil, err
 	}
@@ -104,16 +192,29 @@ func (c *Client) Join(ctx context.Context, idString string) (chat.Channel, error
 
 	c.Lock()
 	defer c.Unlock()
+	key := channelKey{chatID: chat.ID, threadID: threadID}
 	var ch *channel
-	if ch = c.channels[chat.ID]; ch == nil {
+	if ch = c.channels[key]; ch == nil {
 		ch = newChannel(c, chat)
-		c.channels[chat.ID] = ch
+		ch.threadID = threadID
+		c.channels[key] = ch
 	}
 	return ch, nil
 }
 
-func (c *Client) Close(context.Context) error {
+func (c *Client) Close(ctx context.Context) error {
+	c.Lock()
+	var chs []*channel
+	for _, ch := range c.channels {
+		chs = append(chs, ch)
+	}
+	c.Unlock()
+	for _, ch := range chs {
+		ch.closeDeletes(ctx)
+	}
+
 	c.cancel()
+	c.transport.CloseIdleConnections()
 	select {
 	case err := <-c.pollError:
 		return err
@@ -162,6 +263,59 @@ func poll(ctx context.Context, c *Client, updates chan<- []Update) {
 	}
 }
 
+// SetWebhook registers the URL of the Client's WebhookHandler with Telegram.
+// Once set, Telegram sends updates to the webhook instead of getUpdates,
+// so the Client must have been dialed with Options.Webhook.
+// The URL must be HTTPS.
+func (c *Client) SetWebhook(ctx context.Context, url string) error {
+	req := map[string]interface{}{"url": url}
+	if c.webhookSecret != "" {
+		req["secret_token"] = c.webhookSecret
+	}
+	return rpc(ctx, c, "setWebhook", req, nil)
+}
+
+// DeleteWebhook removes the webhook registered with Telegram,
+// if any, allowing updates to be long-polled again.
+// If dropPending is true, any updates not yet delivered to the webhook are dropped.
+func (c *Client) DeleteWebhook(ctx context.Context, dropPending bool) error {
+	req := map[string]interface{}{"drop_pending_updates": dropPending}
+	return rpc(ctx, c, "deleteWebhook", req, nil)
+}
+
+// WebhookHandler returns an http.Handler that receives
+// Updates from Telegram sent to the URL registered with SetWebhook.
+// It should only be used for Clients dialed with Options.Webhook.
+func (c *Client) WebhookHandler() http.Handler {
+	return http.HandlerFunc(c.serveWebhook)
+}
+
+// maxUpdateSize is the maximum size of an Update request body.
+const maxUpdateSize = 10 * megabyte
+
+func (c *Client) serveWebhook(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+		return
+	}
+	secret := req.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(c.webhookSecret)) != 1 {
+		http.Error(w, "bad secret token", http.StatusForbidden)
+		return
+	}
+	var u Update
+	if err := json.NewDecoder(io.LimitReader(req.Body, maxUpdateSize)).Decode(&u); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	select {
+	case c.updates <- []Update{u}:
+	case <-req.Context().Done():
+	case <-c.done:
+		http.Error(w, "client closed", http.StatusServiceUnavailable)
+	}
+}
+
 // Demux de-multiplexes Updates, sending them to the appropriate Channel.
 // If either updates is closed or the context is cancelled,
 // demux closes all Channel.in channels and returns.
@@ -187,37 +341,54 @@ func demux(ctx context.Context, c *Client, updates <-chan []Update) {
 }
 
 func update(ctx context.Context, c *Client, u Update) {
-	var chat *Chat
-	var from *User
+	var msg *Message
 	switch {
 	case u.Message != nil:
-		chat = &u.Message.Chat
-		from = u.Message.From
+		msg = u.Message
 	case u.EditedMessage != nil:
-		chat = &u.EditedMessage.Chat
-		from = u.EditedMessage.From
-	}
-	if chat == nil || chat.Title == nil {
+		msg = u.EditedMessage
+	case u.ChannelPost != nil:
+		msg = u.ChannelPost
+	case u.EditedChannelPost != nil:
+		msg = u.EditedChannelPost
+	case u.CallbackQuery != nil:
+		msg = u.CallbackQuery.Message
+	}
+	if msg == nil || msg.Chat.Title == nil {
 		// Ignore messages not sent to supergroups, channels, or groups.
 		return
 	}
+	switch {
+	case msg.MigrateToChatID != nil:
+		migrate(c, msg.Chat.ID, *msg.MigrateToChatID)
+		return
+	case msg.MigrateFromChatID != nil:
+		migrate(c, *msg.MigrateFromChatID, msg.Chat.ID)
+		return
+	}
+	chat, from := &msg.Chat, msg.From
+	if u.CallbackQuery != nil {
+		from = &u.CallbackQuery.From
+	}
 
 	c.Lock()
 	defer c.Unlock()
 
 	if from != nil {
-		u, ok := c.users[from.ID]
-		if !ok {
-			u = &user{User: *from}
-			c.users[from.ID] = u
-		}
-		updateUser(ctx, c, u, *from)
+		updateUser(ctx, c, cachedUser(c, *from), *from)
 	}
 
+	key := channelKey{chatID: chat.ID}
+	if threadID := topicID(msg); threadID != 0 {
+		if ch, ok := c.channels[channelKey{chatID: chat.ID, threadID: threadID}]; ok {
+			key.threadID = threadID
+			ch.updateTopic(msg)
+		}
+	}
 	var ch *channel
-	if ch = c.channels[chat.ID]; ch == nil {
+	if ch = c.channels[key]; ch == nil {
 		ch = newChannel(c, *chat)
-		c.channels[chat.ID] = ch
+		c.channels[key] = ch
 	}
 	select {
 	case ch.in <- []*Update{&u}:
@@ -226,8 +397,76 @@ func update(ctx context.Context, c *Client, u Update) {
 	}
 }
 
+// A Migrate is an event describing a group upgraded to a supergroup.
+// The Channel of the group is updated in place,
+// and continues to work with the supergroup.
+// However, the chat ID used to Join the Channel has changed.
+type Migrate struct {
+	// Channel is the Channel of the migrated group.
+	Channel chat.Channel
+
+	// FromID is the old chat ID of the group.
+	FromID int64
+
+	// ToID is the new chat ID of the supergroup.
+	ToID int64
+}
+
+func (e Migrate) Origin() chat.Channel { return e.Channel }
+
+// migrate moves the channels of a group migrated to a supergroup
+// to the supergroup's chat ID, updating them in place.
+// A Migrate event is sent to the group's Channel, if any.
+func migrate(c *Client, from, to int64) {
+	c.Lock()
+	defer c.Unlock()
+	for key, ch := range c.channels {
+		if key.chatID != from {
+			continue
+		}
+		newKey := channelKey{chatID: to, threadID: key.threadID}
+		if _, ok := c.channels[newKey]; ok {
+			log.Printf("Telegram chat %d migrated to %d, but it is already joined\n", from, to)
+			continue
+		}
+		delete(c.channels, key)
+		c.channels[newKey] = ch
+
+		ch.Lock()
+		ch.chat.ID = to
+		ch.chat.Type = "supergroup"
+		chat := ch.chat
+		ch.Unlock()
+
+		if key.threadID != 0 {
+			continue
+		}
+		u := &Update{
+			Message: &Message{
+				Date:              time.Now().Unix(),
+				Chat:              chat,
+				MigrateFromChatID: &from,
+			},
+		}
+		select {
+		case ch.in <- []*Update{u}:
+		case us := <-ch.in:
+			ch.in <- append(us, u)
+		}
+	}
+}
+
+// topicID returns the thread ID of the forum topic of a Message,
+// or 0 if the Message is not in a forum topic.
+func topicID(msg *Message) uint64 {
+	if msg.IsTopicMessage == nil || !*msg.IsTopicMessage || msg.MessageThreadID == nil {
+		return 0
+	}
+	return *msg.MessageThreadID
+}
+
 // getChatAdministrators returns ChatMembers for each administrator in the group,
-// adding newly discovered Users to the users map.
+// adding newly discovered Users to the users cache.
 func getChatAdministrators(ctx context.Context, c *Client, chatID int64) ([]ChatMember, error) {
 	req := map[string]interface{}{"chat_id": chatID}
 	var resp []ChatMember
@@ -237,16 +476,23 @@ func getChatAdministrators(ctx context.Context, c *Client, chatID int64) ([]Chat
 	c.Lock()
 	defer c.Unlock()
 	for _, cm := range resp {
-		u, ok := c.users[cm.User.ID]
-		if !ok {
-			u = &user{User: cm.User}
-			c.users[cm.User.ID] = u
-		}
-		updateUser(ctx, c, u, cm.User)
+		updateUser(ctx, c, cachedUser(c, cm.User), cm.User)
 	}
 	return resp, nil
 }
 
+// cachedUser returns the cached user for a User,
+// adding a new user to the cache if it's not already cached.
+// The caller must hold c.Lock.
+func cachedUser(c *Client, latest User) *user {
+	if u, ok := c.users.Get(latest.ID); ok {
+		return u.(*user)
+	}
+	u := &user{User: latest}
+	c.users.Put(latest.ID, u)
+	return u
+}
+
 func updateUser(ctx context.Context, c *Client, u *user, latest User) {
 	u.Lock()
 	defer u.Unlock()
@@ -315,7 +561,12 @@ func (c *Client) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Telegram file path missing", http.StatusBadRequest)
 		return
 	}
-	resp, err := http.Get(url)
+	getReq, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp, err := c.httpClient.Do(getReq.WithContext(ctx))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -334,7 +585,7 @@ func (c *Client) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func copyResponse(w io.Writer, body io.Reader, header map[string][]string) error {
+func copyResponse(w http.ResponseWriter, body io.Reader, header map[string][]string) error {
 	var mime string
 	if ms, ok := header["Content-Type"]; ok && len(ms) > 0 {
 		mime = ms[0]
@@ -355,36 +606,28 @@ func copyResponse(w io.Writer, body io.Reader, header map[string][]string) error
 		// Re-encode webp images as PNG, because Slack won't inline webp.
 		img, err := webp.Decode(body)
 		if err == nil {
+			w.Header().Set("Content-Type", "image/png")
 			return png.Encode(w, img)
 		}
 		log.Printf("Failed to decode webp image: %s", err)
 	}
+	if mime != "" {
+		w.Header().Set("Content-Type", mime)
+	}
 	_, err := io.Copy(w, body)
 	return err
 }
 
 func getMediaURL(ctx context.Context, c *Client, fileID string) (string, error) {
-	c.Lock()
-	m, ok := c.media[fileID]
-	if !ok {
-		m = new(media)
-		c.media[fileID] = m
-	}
-	m.Lock()
-	defer m.Unlock()
-	c.Unlock()
-	if !ok || time.Now().Before(m.expires) {
-		var err error
-		if m.File, err = getFile(ctx, c, fileID); err != nil {
-			return "", err
-		}
-		// The URL is valid for an hour; expire it a bit before to be safe.
-		m.expires = time.Now().Add(50 * time.Minute)
-		c.media[fileID] = m
+	v, err := c.media.Fetch(ctx, fileID, func(ctx context.Context) (interface{}, error) {
+		return getFile(ctx, c, fileID)
+	})
+	if err != nil {
+		return "", err
 	}
 	var url string
-	if m.FilePath != nil {
-		url = "https://api.telegram.org/file/bot" + c.token + "/" + *m.FilePath
+	if f := v.(File); f.FilePath != nil {
+		url = c.apiURL + "/file/bot" + c.token + "/" + *f.FilePath
 	}
 	return url, nil
 }
@@ -399,39 +642,67 @@ func getFile(ctx context.Context, c *Client, fileID string) (File, error) {
 }
 
 func rpc(ctx context.Context, c *Client, method string, req interface{}, resp interface{}) error {
-	err := make(chan error, 1)
-	go func() { err <- _rpc(c, method, req, resp) }()
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case err := <-err:
-		if err != nil {
-			log.Printf("Telegram RPC %s %+v failed: %s\n", method, req, err)
-		}
-		return err
+	err := _rpc(ctx, c, method, req, resp)
+	if err != nil {
+		log.Printf("Telegram RPC %s %+v failed: %s\n", method, req, err)
 	}
+	return err
 }
 
-func _rpc(c *Client, method string, req interface{}, resp interface{}) error {
-	url := "https://api.telegram.org/bot" + c.token + "/" + method
+func _rpc(ctx context.Context, c *Client, method string, req interface{}, resp interface{}) error {
+	var err error
+	var data []byte
+	if req != nil {
+		if data, err = json.Marshal(req); err != nil {
+			return err
+		}
+	}
+	url := c.apiURL + "/bot" + c.token + "/" + method
+	newReq := func() (*http.Request, error) {
+		if data == nil {
+			return http.NewRequest(http.MethodGet, url, nil)
+		}
+		httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		return httpReq, nil
+	}
+	var chatID int64
+	if m, ok := req.(map[string]interface{}); ok {
+		chatID, _ = m["chat_id"].(int64)
+	}
 
-	httpResp, err := reqWithRetry(url, method, req)
+	httpResp, err := reqWithRetry(ctx, c, method, chatID, newReq)
 	if err != nil {
 		return err
 	}
 	defer httpResp.Body.Close()
+	return decodeResult(httpResp, resp)
+}
 
+// decodeResult decodes the result of a Bot API method call into resp.
+// If resp is nil, the result is ignored.
+// An error is returned if the call failed.
+func decodeResult(httpResp *http.Response, resp interface{}) error {
 	result := struct {
-		OK          bool        `json:"ok"`
-		Description *string     `json:"description"`
-		Result      interface{} `json:"result"`
+		OK          bool                `json:"ok"`
+		Description *string             `json:"description"`
+		ErrorCode   int                 `json:"error_code"`
+		Parameters  *ResponseParameters `json:"parameters"`
+		Result      interface{}         `json:"result"`
 	}{}
 	if resp != nil {
 		result.Result = resp
 	}
-	switch err = json.NewDecoder(httpResp.Body).Decode(&result); {
+	switch json.NewDecoder(httpResp.Body).Decode(&result); {
 	case !result.OK && result.Description != nil:
-		return errors.New(*result.Description)
+		return &apiError{
+			Description: *result.Description,
+			Code:        result.ErrorCode,
+			Parameters:  result.Parameters,
+		}
 	case httpResp.StatusCode != http.StatusOK:
 		return errors.New(httpResp.Status)
 	case !result.OK:
@@ -441,51 +712,99 @@ func _rpc(c *Client, method string, req interface{}, resp interface{}) error {
 	}
 }
 
+// An apiError is an unsuccessful Bot API method call.
+type apiError struct {
+	// Description is the human-readable description of the error.
+	Description string
+	// Code is the error code.
+	Code int
+	// Parameters, if non-nil, describe why the request was unsuccessful.
+	Parameters *ResponseParameters
+}
+
+func (err *apiError) Error() string { return err.Description }
+
+// migratedTo returns the ID of the supergroup
+// to which the chat of a failed request was migrated, if any.
+func migratedTo(err error) (int64, bool) {
+	apiErr, ok := err.(*apiError)
+	if !ok || apiErr.Parameters == nil || apiErr.Parameters.MigrateToChatID == nil {
+		return 0, false
+	}
+	return *apiErr.Parameters.MigrateToChatID, true
+}
+
 const (
 	maxRetry   = 3
 	retryDelay = 5 * time.Second
 )
 
-// reqWithRetry makes an HTTP request to the URL, retrying on a 500 response.
-func reqWithRetry(url, method string, req interface{}) (*http.Response, error) {
-	var err error
-	var data []byte
-	if req != nil {
-		if data, err = json.Marshal(req); err != nil {
-			return nil, err
-		}
-	}
-
+// reqWithRetry makes an HTTP request for a Bot API method,
+// retrying on a 500 or 429 (Too Many Requests) response.
+// The request is made by calling newReq, once for each try.
+//
+// Sends to the chat with the given ID are rate limited.
+// On a 429 response, the request is retried
+// after the delay given by the retry_after response parameter.
+func reqWithRetry(ctx context.Context, c *Client, method string, chatID int64, newReq func() (*http.Request, error)) (*http.Response, error) {
 	var i int
-	var httpResp *http.Response
 	for {
-		if data != nil {
-			httpResp, err = http.Post(url, "application/json", bytes.NewBuffer(data))
-		} else {
-			httpResp, err = http.Get(url)
+		if isSend(method) {
+			if err := c.limiter.wait(ctx, chatID); err != nil {
+				return nil, err
+			}
+		}
+		req, err := newReq()
+		if err != nil {
+			return nil, err
 		}
+		httpResp, err := c.httpClient.Do(req.WithContext(ctx))
 		if err != nil {
 			return nil, err
 		}
-		if c := httpResp.StatusCode; c < 500 || c >= 600 {
+		delay := retryDelay
+		switch code := httpResp.StatusCode; {
+		case code == http.StatusTooManyRequests:
+			data, err := ioutil.ReadAll(httpResp.Body)
+			httpResp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			httpResp.Body = ioutil.NopCloser(bytes.NewReader(data))
+			var result struct {
+				Parameters *ResponseParameters `json:"parameters"`
+			}
+			json.Unmarshal(data, &result)
+			if p := result.Parameters; p != nil && p.RetryAfter != nil {
+				delay = time.Duration(*p.RetryAfter) * time.Second
+			}
+			// Delay other sends to the chat too.
+			c.limiter.block(chatID, delay)
+
+		case code >= 500 && code < 600:
+			// We got a 500 response, so try to close that persistent connection.
+			// Sometimes Telegram consistently returns 502 (Bad Gateway)
+			// even across retries. We've found that restarting the app fixes it.
+			// Best guess: the persistent connection is in some bad state,
+			// and getting a new connection will resolve it.
+			// Possibly, we are actually connected to a reverse proxy
+			// where the machine on the far end has gone away,
+			// and now the proxy is rejecting requests on our connection
+			// that used to map to a machine now gone.
+			c.transport.CloseIdleConnections()
+
+		default:
 			return httpResp, nil
 		}
-		// We got a 500 response, so try to close that persistent connection.
-		// Sometimes Telegram consistently returns 502 (Bad Gateway)
-		// even across retries. We've found that restarting the app fixes it.
-		// Best guess: the persistent connection is in some bad state,
-		// and getting a new connection will resolve it.
-		// Possibly, we are actually connected to a reverse proxy
-		// where the machine on the far end has gone away,
-		// and now the proxy is rejecting requests on our connection
-		// that used to map to a machine now gone.
-		http.DefaultTransport.(*http.Transport).CloseIdleConnections()
 		i++
 		if i == maxRetry {
 			log.Printf("Method %s got %s response, giving up", method, httpResp.Status)
 			return httpResp, nil
 		}
-		log.Printf("Method %s got %s response, retrying", method, httpResp.Status)
-		time.Sleep(retryDelay)
+		log.Printf("Method %s got %s response, retrying in %s", method, httpResp.Status, delay)
+		httpResp.Body.Close()
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
 	}
 }
@@ -0,0 +1,961 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/velour/chat"
+)
+
+// fakeBotAPI is a fake Telegram Bot API server.
+type fakeBotAPI struct {
+	*httptest.Server
+
+	sync.Mutex
+	// methods handles Bot API methods by name.
+	// The default methods are getMe, getChat, and getUserProfilePhotos.
+	methods map[string]http.HandlerFunc
+	// calls is the list of methods called, in order.
+	calls []string
+}
+
+const testToken = "123:abc"
+
+func newFakeBotAPI() *fakeBotAPI {
+	api := &fakeBotAPI{
+		methods: map[string]http.HandlerFunc{
+			"getMe": func(w http.ResponseWriter, _ *http.Request) {
+				writeResult(w, User{ID: 1, FirstName: "bot", Username: "bot"})
+			},
+			"getChat": func(w http.ResponseWriter, req *http.Request) {
+				id := int64(jsonRequest(req)["chat_id"].(float64))
+				title := "group"
+				writeResult(w, Chat{ID: id, Type: "supergroup", Title: &title})
+			},
+			"getUserProfilePhotos": func(w http.ResponseWriter, _ *http.Request) {
+				writeResult(w, map[string]interface{}{"photos": []interface{}{}})
+			},
+		},
+	}
+	api.Server = httptest.NewServer(http.HandlerFunc(api.serve))
+	return api
+}
+
+// handle sets the handler for a method.
+func (api *fakeBotAPI) handle(method string, h http.HandlerFunc) {
+	api.Lock()
+	api.methods[method] = h
+	api.Unlock()
+}
+
+// called returns the methods called so far, in order.
+func (api *fakeBotAPI) called() []string {
+	api.Lock()
+	defer api.Unlock()
+	return append([]string{}, api.calls...)
+}
+
+func (api *fakeBotAPI) serve(w http.ResponseWriter, req *http.Request) {
+	prefix := "/bot" + testToken + "/"
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		http.NotFound(w, req)
+		return
+	}
+	method := strings.TrimPrefix(req.URL.Path, prefix)
+	api.Lock()
+	api.calls = append(api.calls, method)
+	h, ok := api.methods[method]
+	api.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found: method not found")
+		return
+	}
+	h(w, req)
+}
+
+// dialFake returns a new Client for the fake Bot API in webhook mode,
+// with rate limiting disabled.
+func dialFake(ctx context.Context, t *testing.T, api *fakeBotAPI) *Client {
+	t.Helper()
+	c, err := DialOptions(ctx, testToken, Options{APIURL: api.URL, Webhook: true})
+	if err != nil {
+		t.Fatalf("DialOptions(…)=_,%v", err)
+	}
+	c.limiter.chatInterval = 0
+	c.limiter.globalInterval = 0
+	return c
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":          false,
+		"error_code":  code,
+		"description": description,
+	})
+}
+
+// jsonRequest returns the JSON request body as a map.
+func jsonRequest(req *http.Request) map[string]interface{} {
+	var m map[string]interface{}
+	json.NewDecoder(req.Body).Decode(&m)
+	return m
+}
+
+// testUpdate returns an Update with a new text message
+// from user ID 2 to the chat with the given ID.
+func testUpdate(id uint64, chatID int64, text string) Update {
+	title := "group"
+	return Update{
+		UpdateID: id,
+		Message: &Message{
+			MessageID: id,
+			From:      &User{ID: 2, FirstName: "Alice"},
+			Date:      time.Now().Add(time.Minute).Unix(),
+			Chat:      Chat{ID: chatID, Type: "supergroup", Title: &title},
+			Text:      &text,
+		},
+	}
+}
+
+func postUpdate(h http.Handler, secret string, u Update) *httptest.ResponseRecorder {
+	data, err := json.Marshal(u)
+	if err != nil {
+		panic(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(data))
+	if secret != "" {
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func expectText(ctx context.Context, t *testing.T, ch chat.Channel, text string) chat.Message {
+	t.Helper()
+	ev, err := ch.Receive(ctx)
+	if err != nil {
+		t.Fatalf("ch.Receive(_)=_,%v, want message %q", err, text)
+	}
+	msg, ok := ev.(chat.Message)
+	if !ok || msg.Text != text {
+		t.Fatalf("ch.Receive(_)=%#v, want message %q", ev, text)
+	}
+	return msg
+}
+
+func TestWebhook(t *testing.T) {
+	api := newFakeBotAPI()
+	defer api.Close()
+	var setWebhook map[string]interface{}
+	api.handle("setWebhook", func(w http.ResponseWriter, req *http.Request) {
+		setWebhook = jsonRequest(req)
+		writeResult(w, true)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := DialOptions(ctx, testToken, Options{
+		APIURL:        api.URL,
+		Webhook:       true,
+		WebhookSecret: "s3cret",
+	})
+	if err != nil {
+		t.Fatalf("DialOptions(…)=_,%v", err)
+	}
+	defer c.Close(ctx)
+
+	if err := c.SetWebhook(ctx, "https://example.com/webhook"); err != nil {
+		t.Fatalf("c.SetWebhook(…)=%v", err)
+	}
+	if setWebhook["url"] != "https://example.com/webhook" || setWebhook["secret_token"] != "s3cret" {
+		t.Errorf("setWebhook request=%v, want url and secret_token", setWebhook)
+	}
+
+	ch, err := c.Join(ctx, "-100")
+	if err != nil {
+		t.Fatalf("c.Join(_, -100)=_,%v", err)
+	}
+
+	h := c.WebhookHandler()
+	if w := postUpdate(h, "", testUpdate(1, -100, "no secret")); w.Code != http.StatusForbidden {
+		t.Errorf("POST without secret: %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := postUpdate(h, "wrong", testUpdate(2, -100, "wrong secret")); w.Code != http.StatusForbidden {
+		t.Errorf("POST with wrong secret: %d, want %d", w.Code, http.StatusForbidden)
+	}
+	get := httptest.NewRecorder()
+	h.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/webhook", nil))
+	if get.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: %d, want %d", get.Code, http.StatusMethodNotAllowed)
+	}
+	if w := postUpdate(h, "s3cret", testUpdate(3, -100, "hello")); w.Code != http.StatusOK {
+		t.Errorf("POST with secret: %d, want %d", w.Code, http.StatusOK)
+	}
+	expectText(ctx, t, ch, "hello")
+
+	for _, m := range api.called() {
+		if m == "getUpdates" {
+			t.Errorf("getUpdates called in webhook mode")
+		}
+	}
+}
+
+func TestPollAPIURL(t *testing.T) {
+	api := newFakeBotAPI()
+	defer api.Close()
+	updates := make(chan []Update, 1)
+	updates <- []Update{testUpdate(1, -100, "polled")}
+	api.handle("getUpdates", func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case us := <-updates:
+			writeResult(w, us)
+		case <-req.Context().Done():
+		case <-time.After(100 * time.Millisecond):
+			writeResult(w, []Update{})
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := DialOptions(ctx, testToken, Options{APIURL: api.URL + "/"})
+	if err != nil {
+		t.Fatalf("DialOptions(…)=_,%v", err)
+	}
+	defer c.Close(ctx)
+	ch, err := c.Join(ctx, "-100")
+	if err != nil {
+		t.Fatalf("c.Join(_, -100)=_,%v", err)
+	}
+	expectText(ctx, t, ch, "polled")
+}
+
+func TestDelete(t *testing.T) {
+	api := newFakeBotAPI()
+	defer api.Close()
+	deleted := make(chan map[string]interface{}, 10)
+	edited := make(chan map[string]interface{}, 10)
+	api.handle("deleteMessage", func(w http.ResponseWriter, req *http.Request) {
+		r := jsonRequest(req)
+		switch r["message_id"] {
+		case float64(2):
+			writeError(w, http.StatusBadRequest, "Bad Request: message to delete not found")
+		case float64(3):
+			writeError(w, http.StatusBadRequest, "Bad Request: message can't be deleted")
+		default:
+			deleted <- r
+			writeResult(w, true)
+		}
+	})
+	api.handle("editMessageText", func(w http.ResponseWriter, req *http.Request) {
+		edited <- jsonRequest(req)
+		writeResult(w, true)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := dialFake(ctx, t, api)
+	defer c.Close(ctx)
+	ch, err := c.Join(ctx, "-100")
+	if err != nil {
+		t.Fatalf("c.Join(_, -100)=_,%v", err)
+	}
+
+	if err := ch.Delete(ctx, chat.Message{ID: "1"}); err != nil {
+		t.Errorf("ch.Delete(_, 1)=%v", err)
+	}
+	if r := <-deleted; r["message_id"] != float64(1) || r["chat_id"] != float64(-100) {
+		t.Errorf("deleteMessage request=%v, want message_id 1, chat_id -100", r)
+	}
+
+	// Not found is not an error.
+	if err := ch.Delete(ctx, chat.Message{ID: "2"}); err != nil {
+		t.Errorf("ch.Delete(_, 2)=%v", err)
+	}
+
+	// Too old to delete is edited instead.
+	if err := ch.Delete(ctx, chat.Message{ID: "3"}); err != nil {
+		t.Errorf("ch.Delete(_, 3)=%v", err)
+	}
+	if r := <-edited; r["message_id"] != float64(3) || r["text"] != deletedText {
+		t.Errorf("editMessageText request=%v, want message_id 3, text %q", r, deletedText)
+	}
+
+	if err := ch.Delete(ctx, chat.Message{ID: "x"}); err == nil {
+		t.Errorf("ch.Delete(_, x)=nil, want error")
+	}
+}
+
+func TestBatchDeletes(t *testing.T) {
+	api := newFakeBotAPI()
+	defer api.Close()
+	batches := make(chan []interface{}, 10)
+	api.handle("deleteMessages", func(w http.ResponseWriter, req *http.Request) {
+		batches <- jsonRequest(req)["message_ids"].([]interface{})
+		writeResult(w, true)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := dialFake(ctx, t, api)
+	defer c.Close(ctx)
+	ch, err := c.Join(ctx, "-100")
+	if err != nil {
+		t.Fatalf("c.Join(_, -100)=_,%v", err)
+	}
+	ch.(interface{ BatchDeletes(time.Duration) }).BatchDeletes(50 * time.Millisecond)
+
+	for i := 1; i <= 3; i++ {
+		if err := ch.Delete(ctx, chat.Message{ID: chat.MessageID(strconv.Itoa(i))}); err != nil {
+			t.Errorf("ch.Delete(_, %d)=%v", i, err)
+		}
+	}
+	if ids := <-batches; len(ids) != 3 {
+		t.Errorf("deleteMessages message_ids=%v, want [1 2 3]", ids)
+	}
+
+	for i := 0; i < maxDeleteBatch+1; i++ {
+		if err := ch.Delete(ctx, chat.Message{ID: chat.MessageID(strconv.Itoa(i))}); err != nil {
+			t.Errorf("ch.Delete(_, %d)=%v", i, err)
+		}
+	}
+	if ids := <-batches; len(ids) != maxDeleteBatch {
+		t.Errorf("deleteMessages got %d message_ids, want %d", len(ids), maxDeleteBatch)
+	}
+	if ids := <-batches; len(ids) != 1 {
+		t.Errorf("deleteMessages got %d message_ids, want 1", len(ids))
+	}
+	for _, m := range api.called() {
+		if m == "deleteMessage" {
+			t.Errorf("deleteMessage called in batch mode")
+		}
+	}
+
+	// Pending deletes are deleted on Close.
+	ch.(interface{ BatchDeletes(time.Duration) }).BatchDeletes(time.Hour)
+	for i := 1; i <= 2; i++ {
+		if err := ch.Delete(ctx, chat.Message{ID: chat.MessageID(strconv.Itoa(i))}); err != nil {
+			t.Errorf("ch.Delete(_, %d)=%v", i, err)
+		}
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Errorf("c.Close(_)=%v", err)
+	}
+	select {
+	case ids := <-batches:
+		if len(ids) != 2 {
+			t.Errorf("deleteMessages message_ids=%v, want [1 2]", ids)
+		}
+	default:
+		t.Errorf("deleteMessages not called by Close")
+	}
+}
+
+// multipartRequest returns the fields and files of a multipart request.
+func multipartRequest(req *http.Request) (map[string]string, map[string]string) {
+	fields := make(map[string]string)
+	files := make(map[string]string)
+	if err := req.ParseMultipartForm(megabyte); err != nil {
+		return fields, files
+	}
+	for k, vs := range req.MultipartForm.Value {
+		fields[k] = vs[0]
+	}
+	for k, fhs := range req.MultipartForm.File {
+		f, err := fhs[0].Open()
+		if err != nil {
+			continue
+		}
+		data, _ := ioutil.ReadAll(f)
+		f.Close()
+		files[k] = fhs[0].Filename + ":" + string(data)
+	}
+	return fields, files
+}
+
+func TestSendMedia(t *testing.T) {
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch path.Ext(req.URL.Path) {
+		case ".png":
+			w.Header().Set("Content-Type", "image/png")
+		case ".gif":
+			w.Header().Set("Content-Type", "image/gif")
+		case ".mp4":
+			w.Header().Set("Content-Type", "video/mp4")
+		case ".html":
+			w.Header().Set("Content-Type", "text/html")
+		default:
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		io.WriteString(w, path.Base(req.URL.Path))
+	}))
+	defer files.Close()
+
+	type call struct {
+		method        string
+		fields, files map[string]string
+	}
+	calls := make(chan call, 10)
+	api := newFakeBotAPI()
+	defer api.Close()
+	var id uint64
+	for _, m := range []string{"sendPhoto", "sendAnimation", "sendVideo", "sendDocument", "sendMediaGroup", "sendMessage"} {
+		m := m
+		api.handle(m, func(w http.ResponseWriter, req *http.Request) {
+			c := call{method: m}
+			if m == "sendMessage" {
+				c.fields = make(map[string]string)
+				for k, v := range jsonRequest(req) {
+					c.fields[k] = fmt.Sprint(v)
+				}
+			} else {
+				c.fields, c.files = multipartRequest(req)
+			}
+			calls <- c
+			id++
+			if m == "sendMediaGroup" {
+				writeResult(w, []Message{{MessageID: id}, {MessageID: id + 1}})
+				return
+			}
+			writeResult(w, Message{MessageID: id})
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := dialFake(ctx, t, api)
+	defer c.Close(ctx)
+	ch, err := c.Join(ctx, "-100")
+	if err != nil {
+		t.Fatalf("c.Join(_, -100)=_,%v", err)
+	}
+	from := &chat.User{Nick: "alice"}
+
+	tests := []struct {
+		name string
+		msg  chat.Message
+		want call
+	}{
+		{
+			name: "attachment",
+			msg: chat.Message{
+				From:        from,
+				Text:        "/me shared a file: " + files.URL + "/abc",
+				Attachments: []chat.Attachment{{URL: files.URL + "/abc", Name: "cat.png", MimeType: "image/png"}},
+			},
+			want: call{
+				method: "sendPhoto",
+				fields: map[string]string{"chat_id": "-100", "caption": "<b>alice</b> <em>shared a file</em>", "parse_mode": "HTML"},
+				files:  map[string]string{"photo": "cat.png:abc"},
+			},
+		},
+		{
+			name: "probed gif link",
+			msg:  chat.Message{From: from, Text: "look " + files.URL + "/x.gif"},
+			want: call{
+				method: "sendAnimation",
+				fields: map[string]string{"chat_id": "-100", "caption": "<b>alice:</b> look", "parse_mode": "HTML"},
+				files:  map[string]string{"animation": "x.gif:x.gif"},
+			},
+		},
+		{
+			name: "probed video link",
+			msg:  chat.Message{From: from, Text: files.URL + "/x.mp4"},
+			want: call{
+				method: "sendVideo",
+				fields: map[string]string{"chat_id": "-100", "caption": "<b>alice:</b> ", "parse_mode": "HTML"},
+				files:  map[string]string{"video": "x.mp4:x.mp4"},
+			},
+		},
+		{
+			name: "document attachment",
+			msg: chat.Message{
+				From:        from,
+				Text:        files.URL + "/doc",
+				Attachments: []chat.Attachment{{URL: files.URL + "/doc", Name: "a.pdf"}},
+			},
+			want: call{
+				method: "sendDocument",
+				fields: map[string]string{"chat_id": "-100", "caption": "<b>alice:</b> ", "parse_mode": "HTML"},
+				files:  map[string]string{"document": "a.pdf:doc"},
+			},
+		},
+		{
+			name: "media group",
+			msg: chat.Message{
+				From: from,
+				Text: "two",
+				Attachments: []chat.Attachment{
+					{URL: files.URL + "/1.png"},
+					{URL: files.URL + "/2.mp4"},
+				},
+			},
+			want: call{
+				method: "sendMediaGroup",
+				fields: map[string]string{
+					"chat_id": "-100",
+					"media":   `[{"caption":"\u003cb\u003ealice:\u003c/b\u003e two","media":"attach://file0","parse_mode":"HTML","type":"photo"},{"media":"attach://file1","type":"video"}]`,
+				},
+				files: map[string]string{"file0": "1.png:1.png", "file1": "2.mp4:2.mp4"},
+			},
+		},
+		{
+			name: "web page link",
+			msg:  chat.Message{From: from, Text: files.URL + "/x.html"},
+			want: call{
+				method: "sendMessage",
+				fields: map[string]string{
+					"chat_id":                  "-100",
+					"text":                     "<b>alice:</b> " + files.URL + "/x.html",
+					"parse_mode":               "HTML",
+					"disable_web_page_preview": "false",
+				},
+			},
+		},
+		{
+			name: "too large",
+			msg: chat.Message{
+				From:        from,
+				Text:        files.URL + "/big.png",
+				Attachments: []chat.Attachment{{URL: files.URL + "/big.png", Size: maxUploadSize + 1}},
+			},
+			want: call{
+				method: "sendMessage",
+				fields: map[string]string{
+					"chat_id":                  "-100",
+					"text":                     "<b>alice:</b> " + files.URL + "/big.png",
+					"parse_mode":               "HTML",
+					"disable_web_page_preview": "false",
+				},
+			},
+		},
+	}
+	for _, test := range tests {
+		if _, err := ch.Send(ctx, test.msg); err != nil {
+			t.Errorf("%s: ch.Send(…)=_,%v", test.name, err)
+			continue
+		}
+		got := <-calls
+		if got.files == nil {
+			got.files = map[string]string{}
+		}
+		if test.want.files == nil {
+			test.want.files = map[string]string{}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEditMediaCaption(t *testing.T) {
+	api := newFakeBotAPI()
+	defer api.Close()
+	captions := make(chan map[string]interface{}, 1)
+	api.handle("editMessageText", func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusBadRequest, "Bad Request: there is no text in the message to edit")
+	})
+	api.handle("editMessageCaption", func(w http.ResponseWriter, req *http.Request) {
+		captions <- jsonRequest(req)
+		writeResult(w, Message{MessageID: 5})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := dialFake(ctx, t, api)
+	defer c.Close(ctx)
+	ch, err := c.Join(ctx, "-100")
+	if err != nil {
+		t.Fatalf("c.Join(_, -100)=_,%v", err)
+	}
+	tests := []struct {
+		name string
+		msg  chat.Message
+		want string
+	}{
+		{
+			name: "Attachment",
+			msg: chat.Message{
+				Text:        "new caption https://a.com/cat.png",
+				Attachments: []chat.Attachment{{URL: "https://a.com/cat.png"}},
+			},
+			want: "<b>alice:</b> new caption",
+		},
+		{
+			name: "Linked media",
+			msg:  chat.Message{Text: "new caption: https://a.com/cat.png"},
+			want: "<b>alice:</b> new caption",
+		},
+		{
+			name: "Colon",
+			msg: chat.Message{
+				Text:        "Agenda:\nhttps://a.com/cat.png",
+				Attachments: []chat.Attachment{{URL: "https://a.com/cat.png"}},
+			},
+			want: "<b>alice:</b> Agenda:",
+		},
+	}
+	for _, test := range tests {
+		msg := test.msg
+		msg.ID = "5"
+		msg.From = &chat.User{Nick: "alice"}
+		if _, err := ch.Edit(ctx, msg); err != nil {
+			t.Fatalf("%s: ch.Edit(…)=_,%v", test.name, err)
+		}
+		if r := <-captions; r["caption"] != test.want {
+			t.Errorf("%s: editMessageCaption caption=%q, want %q", test.name, r["caption"], test.want)
+		}
+	}
+}
+
+func TestMediaKind(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		size     int
+		want     string
+	}{
+		{"image/png", 100, "photo"},
+		{"image/jpeg; charset=binary", 100, "photo"},
+		{"image/png", maxPhotoSize + 1, "document"},
+		{"image/gif", 100, "animation"},
+		{"video/mp4", 100, "video"},
+		{"video/quicktime", 100, "document"},
+		{"application/pdf", 100, "document"},
+		{"", 100, "document"},
+	}
+	for _, test := range tests {
+		if got := mediaKind(test.mimeType, test.size); got != test.want {
+			t.Errorf("mediaKind(%q, %d)=%q, want %q", test.mimeType, test.size, got, test.want)
+		}
+	}
+}
+
+func TestTopics(t *testing.T) {
+	api := newFakeBotAPI()
+	defer api.Close()
+	sent := make(chan map[string]interface{}, 1)
+	api.handle("sendMessage", func(w http.ResponseWriter, req *http.Request) {
+		sent <- jsonRequest(req)
+		writeResult(w, Message{MessageID: 100})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := dialFake(ctx, t, api)
+	defer c.Close(ctx)
+	group, err := c.Join(ctx, "-100")
+	if err != nil {
+		t.Fatalf("c.Join(_, -100)=_,%v", err)
+	}
+	topic, err := c.Join(ctx, "-100/5")
+	if err != nil {
+		t.Fatalf("c.Join(_, -100/5)=_,%v", err)
+	}
+	if _, err := c.Join(ctx, "-100/x"); err == nil {
+		t.Errorf("c.Join(_, -100/x)=_,nil, want error")
+	}
+
+	inTopic := func(u Update, threadID uint64) Update {
+		u.Message.MessageThreadID = &threadID
+		isTopic := true
+		u.Message.IsTopicMessage = &isTopic
+		return u
+	}
+	h := c.WebhookHandler()
+	created := inTopic(testUpdate(5, -100, ""), 5)
+	created.Message.Text = nil
+	created.Message.ForumTopicCreated = &ForumTopicCreated{Name: "Lunch"}
+	postUpdate(h, "", created)
+	postUpdate(h, "", inTopic(testUpdate(6, -100, "in topic 5"), 5))
+	postUpdate(h, "", inTopic(testUpdate(7, -100, "in topic 7"), 7))
+	postUpdate(h, "", testUpdate(8, -100, "in general"))
+
+	expectText(ctx, t, topic, "/me created the topic Lunch")
+	expectText(ctx, t, topic, "in topic 5")
+	expectText(ctx, t, group, "in topic 7")
+	expectText(ctx, t, group, "in general")
+
+	if name := topic.Name(); name != "group/Lunch" {
+		t.Errorf("topic.Name()=%q, want group/Lunch", name)
+	}
+	if name := group.Name(); name != "group" {
+		t.Errorf("group.Name()=%q, want group", name)
+	}
+
+	if _, err := topic.Send(ctx, chat.Message{Text: "hello"}); err != nil {
+		t.Fatalf("topic.Send(…)=_,%v", err)
+	}
+	if r := <-sent; r["message_thread_id"] != float64(5) {
+		t.Errorf("sendMessage message_thread_id=%v, want 5", r["message_thread_id"])
+	}
+	if _, err := group.Send(ctx, chat.Message{Text: "hello"}); err != nil {
+		t.Fatalf("group.Send(…)=_,%v", err)
+	}
+	if r := <-sent; r["message_thread_id"] != nil {
+		t.Errorf("sendMessage message_thread_id=%v, want none", r["message_thread_id"])
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	api := newFakeBotAPI()
+	defer api.Close()
+	sent := make(chan map[string]interface{}, 2)
+	api.handle("sendMessage", func(w http.ResponseWriter, req *http.Request) {
+		r := jsonRequest(req)
+		sent <- r
+		if r["chat_id"] == float64(-1) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"ok":          false,
+				"error_code":  400,
+				"description": "Bad Request: group chat was upgraded to a supergroup chat",
+				"parameters":  map[string]interface{}{"migrate_to_chat_id": -1002},
+			})
+			return
+		}
+		writeResult(w, Message{MessageID: 1})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := dialFake(ctx, t, api)
+	defer c.Close(ctx)
+
+	// Migration by service message.
+	ch, err := c.Join(ctx, "-3")
+	if err != nil {
+		t.Fatalf("c.Join(_, -3)=_,%v", err)
+	}
+	u := testUpdate(1, -3, "")
+	u.Message.Text = nil
+	to := int64(-1003)
+	u.Message.MigrateToChatID = &to
+	postUpdate(c.WebhookHandler(), "", u)
+	ev, err := ch.Receive(ctx)
+	if err != nil {
+		t.Fatalf("ch.Receive(_)=_,%v", err)
+	}
+	if want := (Migrate{Channel: ch, FromID: -3, ToID: -1003}); ev != want {
+		t.Errorf("ch.Receive(_)=%#v, want %#v", ev, want)
+	}
+	// The migrate_from_chat_id message in the supergroup is ignored.
+	u = testUpdate(2, -1003, "")
+	u.Message.Text = nil
+	from := int64(-3)
+	u.Message.MigrateFromChatID = &from
+	postUpdate(c.WebhookHandler(), "", u)
+	postUpdate(c.WebhookHandler(), "", testUpdate(3, -1003, "in the supergroup"))
+	expectText(ctx, t, ch, "in the supergroup")
+
+	// Migration by send error.
+	ch, err = c.Join(ctx, "-1")
+	if err != nil {
+		t.Fatalf("c.Join(_, -1)=_,%v", err)
+	}
+	if _, err := ch.Send(ctx, chat.Message{Text: "hello"}); err != nil {
+		t.Fatalf("ch.Send(…)=_,%v", err)
+	}
+	if r := <-sent; r["chat_id"] != float64(-1) {
+		t.Errorf("first sendMessage chat_id=%v, want -1", r["chat_id"])
+	}
+	if r := <-sent; r["chat_id"] != float64(-1002) {
+		t.Errorf("second sendMessage chat_id=%v, want -1002", r["chat_id"])
+	}
+	ev, err = ch.Receive(ctx)
+	if err != nil {
+		t.Fatalf("ch.Receive(_)=_,%v", err)
+	}
+	if want := (Migrate{Channel: ch, FromID: -1, ToID: -1002}); ev != want {
+		t.Errorf("ch.Receive(_)=%#v, want %#v", ev, want)
+	}
+	if ch2, err := c.Join(ctx, "-1002"); err != nil || ch2 != ch {
+		t.Errorf("c.Join(_, -1002)=%v,%v, want the migrated channel", ch2, err)
+	}
+}
+
+func TestCallback(t *testing.T) {
+	api := newFakeBotAPI()
+	defer api.Close()
+	sent := make(chan map[string]interface{}, 1)
+	api.handle("sendMessage", func(w http.ResponseWriter, req *http.Request) {
+		sent <- jsonRequest(req)
+		writeResult(w, Message{MessageID: 10})
+	})
+	answers := make(chan map[string]interface{}, 1)
+	api.handle("answerCallbackQuery", func(w http.ResponseWriter, req *http.Request) {
+		answers <- jsonRequest(req)
+		writeResult(w, true)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := dialFake(ctx, t, api)
+	defer c.Close(ctx)
+	ch, err := c.Join(ctx, "-100")
+	if err != nil {
+		t.Fatalf("c.Join(_, -100)=_,%v", err)
+	}
+
+	who := "who"
+	keyboard := InlineKeyboardMarkup{
+		InlineKeyboard: [][]InlineKeyboardButton{{{Text: "Who's online?", CallbackData: &who}}},
+	}
+	msg, err := ch.(interface {
+		SendKeyboard(context.Context, chat.Message, InlineKeyboardMarkup) (chat.Message, error)
+	}).SendKeyboard(ctx, chat.Message{Text: "Menu"}, keyboard)
+	if err != nil {
+		t.Fatalf("ch.SendKeyboard(…)=_,%v", err)
+	}
+	want := map[string]interface{}{
+		"inline_keyboard": []interface{}{
+			[]interface{}{
+				map[string]interface{}{"text": "Who's online?", "callback_data": "who"},
+			},
+		},
+	}
+	if r := <-sent; !reflect.DeepEqual(r["reply_markup"], want) {
+		t.Errorf("sendMessage reply_markup=%v, want %v", r["reply_markup"], want)
+	}
+
+	u := testUpdate(1, -100, "Menu")
+	u.Message.MessageID = 10
+	u.CallbackQuery = &CallbackQuery{
+		ID:      "q1",
+		From:    User{ID: 3, FirstName: "Bob"},
+		Message: u.Message,
+		Data:    &who,
+	}
+	u.Message = nil
+	postUpdate(c.WebhookHandler(), "", u)
+
+	ev, err := ch.Receive(ctx)
+	if err != nil {
+		t.Fatalf("ch.Receive(_)=_,%v", err)
+	}
+	cb, ok := ev.(Callback)
+	if !ok {
+		t.Fatalf("ch.Receive(_)=%#v, want Callback", ev)
+	}
+	if cb.ID != "q1" || cb.Data != "who" || cb.MessageID != msg.ID || cb.From.Nick != "Bob" || cb.Origin() != ch {
+		t.Errorf("got %#v, want ID q1, data who, message ID %s, from Bob", cb, msg.ID)
+	}
+	if err := cb.Answer(ctx, "Alice is online", true); err != nil {
+		t.Fatalf("cb.Answer(…)=%v", err)
+	}
+	r := <-answers
+	if r["callback_query_id"] != "q1" || r["text"] != "Alice is online" || r["show_alert"] != true {
+		t.Errorf("answerCallbackQuery request=%v", r)
+	}
+}
+
+func TestTooManyRequests(t *testing.T) {
+	api := newFakeBotAPI()
+	defer api.Close()
+	var tries int
+	api.handle("sendMessage", func(w http.ResponseWriter, req *http.Request) {
+		if tries++; tries == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"ok":          false,
+				"error_code":  429,
+				"description": "Too Many Requests: retry after 1",
+				"parameters":  map[string]interface{}{"retry_after": 1},
+			})
+			return
+		}
+		writeResult(w, Message{MessageID: 1})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := dialFake(ctx, t, api)
+	defer c.Close(ctx)
+	ch, err := c.Join(ctx, "-100")
+	if err != nil {
+		t.Fatalf("c.Join(_, -100)=_,%v", err)
+	}
+
+	start := time.Now()
+	if _, err := ch.Send(ctx, chat.Message{Text: "hello"}); err != nil {
+		t.Fatalf("ch.Send(…)=_,%v", err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Errorf("ch.Send(…) returned after %s, want at least 1s", d)
+	}
+	if tries != 2 {
+		t.Errorf("sendMessage tried %d times, want 2", tries)
+	}
+
+	// The chat is blocked; a cancelled context returns immediately.
+	c.limiter.block(-100, time.Hour)
+	cctx, ccancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer ccancel()
+	if _, err := ch.Send(cctx, chat.Message{Text: "hello"}); err != context.DeadlineExceeded {
+		t.Errorf("ch.Send(…)=_,%v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// TestGetMediaURL tests that concurrent lookups of the same file
+// share a single getFile call, and that file URLs are cached.
+func TestGetMediaURL(t *testing.T) {
+	api := newFakeBotAPI()
+	defer api.Close()
+	release := make(chan struct{})
+	api.handle("getFile", func(w http.ResponseWriter, req *http.Request) {
+		<-release
+		id := jsonRequest(req)["file_id"].(string)
+		filePath := "photos/" + id + ".jpg"
+		writeResult(w, File{FileID: id, FilePath: &filePath})
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c := dialFake(ctx, t, api)
+	defer c.Close(ctx)
+
+	want := api.URL + "/file/bot" + testToken + "/photos/abc.jpg"
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if url, err := getMediaURL(ctx, c, "abc"); err != nil || url != want {
+				t.Errorf("getMediaURL(_, _, abc)=%q,%v, want %q,nil", url, err, want)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if url, err := getMediaURL(ctx, c, "abc"); err != nil || url != want {
+		t.Errorf("getMediaURL(_, _, abc)=%q,%v, want %q,nil", url, err, want)
+	}
+
+	var n int
+	for _, m := range api.called() {
+		if m == "getFile" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Errorf("getFile called %d times, want 1", n)
+	}
+}
@@ -0,0 +1,107 @@
+package telegram
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// chatSendInterval is the minimum time between sends to a single chat.
+	chatSendInterval = time.Second
+	// globalSendInterval is the minimum time between any two sends.
+	globalSendInterval = time.Second / 30
+)
+
+// A limiter schedules sends to stay within Telegram's rate limits:
+// about one message per second to a single chat,
+// and about 30 messages per second overall.
+//
+// Each send reserves the next free slot for its chat,
+// so concurrent sends are spaced out in the order that they wait.
+type limiter struct {
+	chatInterval, globalInterval time.Duration
+
+	sync.Mutex
+	// next is the time of the next free global slot.
+	next time.Time
+	// chats is the time of the next free slot for each chat.
+	chats map[int64]time.Time
+}
+
+func newLimiter() *limiter {
+	return &limiter{
+		chatInterval:   chatSendInterval,
+		globalInterval: globalSendInterval,
+		chats:          make(map[int64]time.Time),
+	}
+}
+
+// wait waits for the next free slot to send to the chat.
+// It returns an error if the context is done before the slot.
+func (l *limiter) wait(ctx context.Context, chatID int64) error {
+	l.Lock()
+	now := time.Now()
+	// The global slot is reserved at the next free time,
+	// even if the send must wait longer for its chat's slot.
+	// Otherwise, a single blocked chat would block all others.
+	t := now
+	if l.next.After(t) {
+		t = l.next
+	}
+	l.next = t.Add(l.globalInterval)
+	if next := l.chats[chatID]; next.After(t) {
+		t = next
+	}
+	l.chats[chatID] = t.Add(l.chatInterval)
+	// Forget chats with no pending slots, so the map doesn't grow forever.
+	for id, next := range l.chats {
+		if next.Before(now) {
+			delete(l.chats, id)
+		}
+	}
+	l.Unlock()
+	return sleep(ctx, t.Sub(now))
+}
+
+// block delays all sends to the chat for at least d.
+// If chatID is 0, all sends to all chats are delayed.
+func (l *limiter) block(chatID int64, d time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	t := time.Now().Add(d)
+	if chatID == 0 {
+		if t.After(l.next) {
+			l.next = t
+		}
+		return
+	}
+	if t.After(l.chats[chatID]) {
+		l.chats[chatID] = t
+	}
+}
+
+// sleep sleeps for d or until the context is done.
+// It returns the context error if the context is done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isSend returns whether a method sends, edits, or deletes messages,
+// and so is subject to rate limiting.
+func isSend(method string) bool {
+	return strings.HasPrefix(method, "send") ||
+		strings.HasPrefix(method, "edit") ||
+		strings.HasPrefix(method, "deleteMessage")
+}
@@ -0,0 +1,50 @@
+package telegram
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	const (
+		chatInterval   = 50 * time.Millisecond
+		globalInterval = 10 * time.Millisecond
+		slop           = 5 * time.Millisecond
+	)
+	l := newLimiter()
+	l.chatInterval = chatInterval
+	l.globalInterval = globalInterval
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.wait(ctx, 1); err != nil {
+			t.Fatalf("l.wait(_, 1)=%v", err)
+		}
+	}
+	if d := time.Since(start); d < 2*chatInterval-slop {
+		t.Errorf("3 sends to one chat took %s, want at least %s", d, 2*chatInterval)
+	}
+
+	start = time.Now()
+	for i := int64(10); i < 13; i++ {
+		if err := l.wait(ctx, i); err != nil {
+			t.Fatalf("l.wait(_, %d)=%v", i, err)
+		}
+	}
+	if d := time.Since(start); d < 2*globalInterval-slop || d >= chatInterval {
+		t.Errorf("3 sends to different chats took %s, want about %s", d, 2*globalInterval)
+	}
+
+	l.block(20, time.Hour)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx, 20); err != context.DeadlineExceeded {
+		t.Errorf("l.wait(_, 20)=%v, want %v", err, context.DeadlineExceeded)
+	}
+	// Other chats are not blocked.
+	if err := l.wait(context.Background(), 21); err != nil {
+		t.Errorf("l.wait(_, 21)=%v", err)
+	}
+}
@@ -27,22 +27,103 @@ type Update struct {
 	EditedChannelPost *Message `json:"edited_channel_post"`
 
 	// InlineQuery is a new inline query.
-	InlineQuery *map[string]interface{} `json:"inline_query"`
+	InlineQuery *InlineQuery `json:"inline_query"`
 
 	// ChosenInlineResult is the result of an inline query that was chosen by the user and sent to their chat partner.
-	ChosenInlineResult *map[string]interface{} `json:"chosen_inline_result"`
+	ChosenInlineResult *ChosenInlineResult `json:"chosen_inline_result"`
 
 	// CallbackQuery is a new incoming callback query.
-	CallbackQuery *map[string]interface{} `json:"callback_query"`
+	CallbackQuery *CallbackQuery `json:"callback_query"`
+}
+
+// An InlineQuery is an incoming inline query.
+// When the user sends an empty query, the bot could return some default or trending results.
+type InlineQuery struct {
+	// ID is the unique identifier for this query.
+	ID string `json:"id"`
+
+	// From is the sender.
+	From User `json:"from"`
+
+	// Query is the text of the query, up to 256 characters.
+	Query string `json:"query"`
+
+	// Offset is the offset of the results to be returned, controlled by the bot.
+	Offset string `json:"offset"`
+}
+
+// A ChosenInlineResult is the result of an inline query
+// that was chosen by a user and sent to their chat partner.
+type ChosenInlineResult struct {
+	// ResultID is the unique identifier for the result that was chosen.
+	ResultID string `json:"result_id"`
+
+	// From is the user that chose the result.
+	From User `json:"from"`
+
+	// Query is the query that was used to obtain the result.
+	Query string `json:"query"`
+}
+
+// A CallbackQuery is an incoming callback query
+// from a callback button in an inline keyboard.
+type CallbackQuery struct {
+	// ID is the unique identifier for this query.
+	ID string `json:"id"`
+
+	// From is the sender.
+	From User `json:"from"`
+
+	// Message is the message with the callback button that originated the query.
+	// It is nil if the message is too old.
+	Message *Message `json:"message"`
+
+	// InlineMessageID is the identifier of the message sent via the bot in inline mode,
+	// that originated the query.
+	InlineMessageID *string `json:"inline_message_id"`
+
+	// ChatInstance is a global identifier,
+	// uniquely corresponding to the chat to which
+	// the message with the callback button was sent.
+	ChatInstance string `json:"chat_instance"`
+
+	// Data is the data associated with the callback button.
+	Data *string `json:"data"`
+}
+
+// An InlineKeyboardMarkup is an inline keyboard
+// that appears right next to the message it belongs to.
+type InlineKeyboardMarkup struct {
+	// InlineKeyboard is the rows of buttons.
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// An InlineKeyboardButton is one button of an inline keyboard.
+// Exactly one of the optional fields must be used.
+type InlineKeyboardButton struct {
+	// Text is the label text on the button.
+	Text string `json:"text"`
+
+	// URL is the URL to be opened when the button is pressed.
+	URL *string `json:"url,omitempty"`
+
+	// CallbackData is the data sent to the bot in a callback query
+	// when the button is pressed, 1-64 bytes.
+	CallbackData *string `json:"callback_data,omitempty"`
 }
 
 // A Message represents a message sent with telegram.
-//
-// TODO: Map all fields to a custom struct type instead of map[string]interface{}.
 type Message struct {
 	// MessageID is a unique identifier for the message within a chat.
 	MessageID uint64 `json:"message_id"`
 
+	// MessageThreadID is the unique identifier of the message thread
+	// or forum topic to which the message belongs.
+	MessageThreadID *uint64 `json:"message_thread_id"`
+
+	// IsTopicMessage is true if the message is sent to a forum topic.
+	IsTopicMessage *bool `json:"is_topic_message"`
+
 	// From is the sender of the message. It is nil for messages sent to channels.
 	From *User `json:"from"`
 
@@ -52,6 +133,17 @@ type Message struct {
 	// Chat is the chat to which the message was sent.
 	Chat Chat `json:"chat"`
 
+	// SenderChat is the sender of the message,
+	// for messages sent on behalf of a chat.
+	// For example, the channel itself for channel posts,
+	// or the group for messages from anonymous group administrators.
+	SenderChat *Chat `json:"sender_chat"`
+
+	// AuthorSignature is the signature of the post author
+	// for messages in channels,
+	// or the custom title of an anonymous group administrator.
+	AuthorSignature *string `json:"author_signature"`
+
 	// ForwardFrom is the sender of the original message, for a forwarded message.
 	ForwardFrom *User `json:"forward_from"`
 
@@ -64,6 +156,9 @@ type Message struct {
 	// ForwardDate is the date of the original message, for a forwarded message.
 	ForwardDate uint64 `json:"forward_date"`
 
+	// ReplyMarkup is the inline keyboard attached to the message.
+	ReplyMarkup *InlineKeyboardMarkup `json:"reply_markup"`
+
 	// ReplyToMessage is the message to which this message is replying.
 	// If this message is not a reply, ReplyToMessage is nil.
 	ReplyToMessage *Message `json:"reply_to_message"`
@@ -74,13 +169,24 @@ type Message struct {
 	// Text is the text of the message, 0-4096 characters.
 	Text *string `json:"text"`
 
-	Entities *[]map[string]interface{} `json:"entities"`
-	Audio    *map[string]interface{}   `json:"audio"`
+	// Entities are special entities in the Text,
+	// such as usernames, URLs, and formatting.
+	Entities *[]MessageEntity `json:"entities"`
+
+	// Audio indicates that the Message is a shared audio file.
+	Audio *Audio `json:"audio"`
 
 	// Document indicates that the Message is a shared file.
 	Document *Document `json:"document"`
 
-	Game *map[string]interface{} `json:"game"`
+	// Animation indicates that the Message is an animation,
+	// such as a GIF or an H.264/MPEG-4 AVC video without sound.
+	// For backwards compatibility, when Animation is set,
+	// Document is also set.
+	Animation *Animation `json:"animation"`
+
+	// Game indicates that the Message is a game.
+	Game *Game `json:"game"`
 
 	// Photo indicates that the Message is a shared photo.
 	Photo *[]PhotoSize `json:"photo"`
@@ -88,12 +194,35 @@ type Message struct {
 	// Sticker indicates that the Message is a sticker.
 	Sticker *Sticker `json:"sticker"`
 
-	Video    *map[string]interface{} `json:"video"`
-	Voice    *map[string]interface{} `json:"voice"`
-	Caption  *string                 `json:"caption"`
-	Contact  *map[string]interface{} `json:"contact"`
-	Location *map[string]interface{} `json:"location"`
-	Venue    *map[string]interface{} `json:"venue"`
+	// Video indicates that the Message is a shared video.
+	Video *Video `json:"video"`
+
+	// Voice indicates that the Message is a voice message.
+	Voice *Voice `json:"voice"`
+
+	// Caption is the caption for an animation, audio, document,
+	// photo, video, or voice message, 0-1024 characters.
+	Caption *string `json:"caption"`
+
+	// CaptionEntities are special entities in the Caption.
+	CaptionEntities *[]MessageEntity `json:"caption_entities"`
+
+	// Contact indicates that the Message is a shared contact.
+	Contact *Contact `json:"contact"`
+
+	// Dice indicates that the Message is a dice with a random value.
+	Dice *Dice `json:"dice"`
+
+	// Poll indicates that the Message is a poll.
+	Poll *Poll `json:"poll"`
+
+	// Venue indicates that the Message is a venue.
+	// For backwards compatibility, when Venue is set,
+	// Location is also set.
+	Venue *Venue `json:"venue"`
+
+	// Location indicates that the Message is a shared location.
+	Location *Location `json:"location"`
 
 	// NewChatMember is the User information of a new chat member, added to the group.
 	NewChatMember *User `json:"new_chat_member"`
@@ -101,20 +230,65 @@ type Message struct {
 	// LeftChatMember is the User information of a chat member who just left the group.
 	LeftChatMember *User `json:"left_chat_member"`
 
-	NewChatTitle          *string                 `json:"new_chat_title"`
-	NewChatPhoto          *map[string]interface{} `json:"new_chat_photo"`
-	DeleteChatPhoto       *bool                   `json:"delete_chat_photo"`
-	GroupChatCreated      *bool                   `json:"group_chat_created"`
-	SupergroupChatCreated *bool                   `json:"supergroup_chat_created"`
-	ChannelChatCreated    *bool                   `json:"channel_chat_created"`
-	MigrateToChatID       *uint64                 `json:"migrate_to_chat_id"`
-	MigrateFromChatID     *uint64                 `json:"migrate_from_chat_id"`
-	PinnedMessage         *Message                `json:"pinned_message"`
+	NewChatTitle          *string      `json:"new_chat_title"`
+	NewChatPhoto          *[]PhotoSize `json:"new_chat_photo"`
+	DeleteChatPhoto       *bool        `json:"delete_chat_photo"`
+	GroupChatCreated      *bool        `json:"group_chat_created"`
+	SupergroupChatCreated *bool        `json:"supergroup_chat_created"`
+	ChannelChatCreated    *bool        `json:"channel_chat_created"`
+	PinnedMessage         *Message     `json:"pinned_message"`
+
+	// MigrateToChatID is a service message:
+	// the group was migrated to the supergroup with this ID.
+	MigrateToChatID *int64 `json:"migrate_to_chat_id"`
+
+	// MigrateFromChatID is a service message:
+	// the supergroup was migrated from the group with this ID.
+	MigrateFromChatID *int64 `json:"migrate_from_chat_id"`
+
+	// ForumTopicCreated is a service message: a forum topic was created.
+	ForumTopicCreated *ForumTopicCreated `json:"forum_topic_created"`
+
+	// ForumTopicEdited is a service message: a forum topic was edited.
+	ForumTopicEdited *ForumTopicEdited `json:"forum_topic_edited"`
+
+	// ForumTopicClosed is a service message: a forum topic was closed.
+	ForumTopicClosed *ForumTopicClosed `json:"forum_topic_closed"`
+
+	// ForumTopicReopened is a service message: a forum topic was reopened.
+	ForumTopicReopened *ForumTopicReopened `json:"forum_topic_reopened"`
 }
 
 // Time returns the time.Time represented by the Message Date field.
 func (m *Message) Time() time.Time { return time.Unix(m.Date, 0) }
 
+// A MessageEntity is a special entity in the text of a message,
+// such as a hashtag, username, URL, or formatting.
+type MessageEntity struct {
+	// Type is the type of the entity. It is one of
+	// “mention”, “hashtag”, “cashtag”, “bot_command”, “url”, “email”,
+	// “phone_number”, “bold”, “italic”, “underline”, “strikethrough”,
+	// “spoiler”, “blockquote”, “expandable_blockquote”, “code”, “pre”,
+	// “text_link”, “text_mention”, or “custom_emoji”.
+	Type string `json:"type"`
+
+	// Offset is the offset of the entity in the text,
+	// in UTF-16 code units.
+	Offset int `json:"offset"`
+
+	// Length is the length of the entity in UTF-16 code units.
+	Length int `json:"length"`
+
+	// URL is the URL opened when the user taps a “text_link”.
+	URL *string `json:"url"`
+
+	// User is the mentioned user of a “text_mention”.
+	User *User `json:"user"`
+
+	// Language is the programming language of the text of a “pre”.
+	Language *string `json:"language"`
+}
+
 // A User is a user connected to telegram.
 type User struct {
 	// ID is a unique identifier of this user or bot.
@@ -152,6 +326,9 @@ type Chat struct {
 
 	// AllMembersAreAdministrators is true if a group has 'all members are administrators' enabled.
 	AllMembersAreAdministrators *bool `json:"all_members_are_administrators"`
+
+	// IsForum is true if the supergroup chat is a forum, with topics enabled.
+	IsForum *bool `json:"is_forum"`
 }
 
 // PhotoSize represents a single size of a photo, file, or sticker thumbnail.
@@ -202,6 +379,187 @@ type File struct {
 type Document struct {
 	// FileID is the unique identifier of this file.
 	FileID string `json:"file_id"`
+
+	// FileName is the original file name, if known.
+	FileName *string `json:"file_name"`
+
+	// MimeType is the MIME type of the file, if known.
+	MimeType *string `json:"mime_type"`
+
+	// FileSize is the file size in bytes, if known.
+	FileSize *int `json:"file_size"`
+}
+
+// An Animation is an animation file,
+// such as a GIF or an H.264/MPEG-4 AVC video without sound.
+type Animation struct {
+	// FileID is the unique identifier of this file.
+	FileID string `json:"file_id"`
+
+	// Width is the video width.
+	Width int `json:"width"`
+
+	// Height is the video height.
+	Height int `json:"height"`
+
+	// Duration is the duration of the video in seconds.
+	Duration int `json:"duration"`
+
+	// FileName is the original file name, if known.
+	FileName *string `json:"file_name"`
+
+	// MimeType is the MIME type of the file, if known.
+	MimeType *string `json:"mime_type"`
+
+	// FileSize is the file size in bytes, if known.
+	FileSize *int `json:"file_size"`
+}
+
+// An Audio is an audio file to be treated as music.
+type Audio struct {
+	// FileID is the unique identifier of this file.
+	FileID string `json:"file_id"`
+
+	// Duration is the duration of the audio in seconds.
+	Duration int `json:"duration"`
+
+	// Performer is the performer of the audio, if known.
+	Performer *string `json:"performer"`
+
+	// Title is the title of the audio, if known.
+	Title *string `json:"title"`
+
+	// FileName is the original file name, if known.
+	FileName *string `json:"file_name"`
+
+	// MimeType is the MIME type of the file, if known.
+	MimeType *string `json:"mime_type"`
+
+	// FileSize is the file size in bytes, if known.
+	FileSize *int `json:"file_size"`
+}
+
+// A Video is a video file.
+type Video struct {
+	// FileID is the unique identifier of this file.
+	FileID string `json:"file_id"`
+
+	// Width is the video width.
+	Width int `json:"width"`
+
+	// Height is the video height.
+	Height int `json:"height"`
+
+	// Duration is the duration of the video in seconds.
+	Duration int `json:"duration"`
+
+	// FileName is the original file name, if known.
+	FileName *string `json:"file_name"`
+
+	// MimeType is the MIME type of the file, if known.
+	MimeType *string `json:"mime_type"`
+
+	// FileSize is the file size in bytes, if known.
+	FileSize *int `json:"file_size"`
+}
+
+// A Voice is a voice note.
+type Voice struct {
+	// FileID is the unique identifier of this file.
+	FileID string `json:"file_id"`
+
+	// Duration is the duration of the audio in seconds.
+	Duration int `json:"duration"`
+
+	// MimeType is the MIME type of the file, if known.
+	MimeType *string `json:"mime_type"`
+
+	// FileSize is the file size in bytes, if known.
+	FileSize *int `json:"file_size"`
+}
+
+// A Contact is a phone contact.
+type Contact struct {
+	// PhoneNumber is the contact's phone number.
+	PhoneNumber string `json:"phone_number"`
+
+	// FirstName is the contact's first name.
+	FirstName string `json:"first_name"`
+
+	// LastName is the contact's last name.
+	LastName *string `json:"last_name"`
+
+	// UserID is the contact's user ID, if the contact is a Telegram user.
+	UserID *int64 `json:"user_id"`
+}
+
+// A Dice is an animated emoji that displays a random value.
+type Dice struct {
+	// Emoji is the emoji on which the dice animation is based.
+	Emoji string `json:"emoji"`
+
+	// Value is the value of the dice:
+	// 1-6 for “🎲”, “🎯” and “🎳”,
+	// 1-5 for “🏀” and “⚽”,
+	// and 1-64 for “🎰”.
+	Value int `json:"value"`
+}
+
+// A Poll contains information about a poll.
+type Poll struct {
+	// ID is the unique identifier of the poll.
+	ID string `json:"id"`
+
+	// Question is the poll question, 1-300 characters.
+	Question string `json:"question"`
+
+	// Options is the list of poll options.
+	Options []PollOption `json:"options"`
+
+	// IsClosed is whether the poll is closed.
+	IsClosed bool `json:"is_closed"`
+
+	// Type is the poll type, either “regular” or “quiz”.
+	Type string `json:"type"`
+}
+
+// A PollOption is an answer option in a poll.
+type PollOption struct {
+	// Text is the option text, 1-100 characters.
+	Text string `json:"text"`
+
+	// VoterCount is the number of users that voted for this option.
+	VoterCount int `json:"voter_count"`
+}
+
+// A Location is a point on the map.
+type Location struct {
+	// Longitude is the longitude as defined by the sender.
+	Longitude float64 `json:"longitude"`
+
+	// Latitude is the latitude as defined by the sender.
+	Latitude float64 `json:"latitude"`
+}
+
+// A Venue is a named location.
+type Venue struct {
+	// Location is the location of the venue.
+	Location Location `json:"location"`
+
+	// Title is the name of the venue.
+	Title string `json:"title"`
+
+	// Address is the address of the venue.
+	Address string `json:"address"`
+}
+
+// A Game is a game.
+type Game struct {
+	// Title is the title of the game.
+	Title string `json:"title"`
+
+	// Description is the description of the game.
+	Description string `json:"description"`
 }
 
 // A Sticker represents a sticker sent in a Message.
@@ -216,6 +574,18 @@ type Sticker struct {
 	Emoji *string `json:"emoji"`
 }
 
+// ResponseParameters describe why a request was unsuccessful.
+type ResponseParameters struct {
+	// MigrateToChatID is the ID of the supergroup
+	// to which the group has been migrated.
+	MigrateToChatID *int64 `json:"migrate_to_chat_id"`
+
+	// RetryAfter is the number of seconds to wait
+	// before the request can be repeated,
+	// if the flood control limit was exceeded.
+	RetryAfter *int `json:"retry_after"`
+}
+
 // A ChatMember is a User who is a member of a chat.
 type ChatMember struct {
 	// User is the user's information.
@@ -224,3 +594,24 @@ type ChatMember struct {
 	// Status is one of “creator”, “administrator”, “member”, “left” or “kicked”.
 	Status string `json:"status"`
 }
+
+// ForumTopicCreated is a service message about a new forum topic.
+type ForumTopicCreated struct {
+	// Name is the name of the topic.
+	Name string `json:"name"`
+
+	// IconColor is the color of the topic icon in RGB format.
+	IconColor int `json:"icon_color"`
+}
+
+// ForumTopicEdited is a service message about an edited forum topic.
+type ForumTopicEdited struct {
+	// Name is the new name of the topic, if it was edited.
+	Name *string `json:"name"`
+}
+
+// ForumTopicClosed is a service message about a forum topic closed in the chat.
+type ForumTopicClosed struct{}
+
+// ForumTopicReopened is a service message about a forum topic reopened in the chat.
+type ForumTopicReopened struct{}
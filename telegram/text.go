@@ -3,8 +3,10 @@ package telegram
 import (
 	"html"
 	"net/url"
+	"sort"
 	"strings"
 	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
 
 	"github.com/velour/chat"
@@ -110,3 +112,87 @@ func linkIndex(text string) (int, string) {
 		text = text[i+1:]
 	}
 }
+
+// entityText returns the text of a Telegram message,
+// with its formatting entities rendered as plain text.
+//
+// Entities are rendered as follows:
+// • text_link is rendered as the text followed by the URL in parentheses,
+// • code is wrapped in `,
+// • pre is wrapped in ``` on their own lines,
+// • bold is wrapped in *,
+// • italic is wrapped in _,
+// • strikethrough is wrapped in ~,
+// • each line of a blockquote is prefixed with "> ".
+// Other entities are rendered as their text.
+func entityText(text string, entities []MessageEntity) string {
+	if len(entities) == 0 {
+		return text
+	}
+	sorted := make([]MessageEntity, len(entities))
+	copy(sorted, entities)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Offset == sorted[j].Offset {
+			// Outer entities first.
+			return sorted[i].Length > sorted[j].Length
+		}
+		return sorted[i].Offset < sorted[j].Offset
+	})
+	return renderEntities(utf16.Encode([]rune(text)), 0, sorted)
+}
+
+// renderEntities returns the text of UTF-16 code units
+// beginning at the offset offs of the message text,
+// with the entities, sorted by offset, rendered.
+func renderEntities(units []uint16, offs int, entities []MessageEntity) string {
+	var s strings.Builder
+	var i int
+	for len(entities) > 0 {
+		e := entities[0]
+		start, end := e.Offset-offs, e.Offset-offs+e.Length
+		if start < i || end > len(units) || start > end {
+			// Overlapping or out of bounds; ignore it.
+			entities = entities[1:]
+			continue
+		}
+		// Entities nested inside e.
+		n := 1
+		for n < len(entities) && entities[n].Offset < e.Offset+e.Length {
+			n++
+		}
+		s.WriteString(string(utf16.Decode(units[i:start])))
+		inner := renderEntities(units[start:end], e.Offset, entities[1:n])
+		s.WriteString(renderEntity(e, string(utf16.Decode(units[start:end])), inner))
+		i = end
+		entities = entities[n:]
+	}
+	s.WriteString(string(utf16.Decode(units[i:])))
+	return s.String()
+}
+
+// renderEntity returns the rendering of an entity,
+// given its raw text and its text with nested entities rendered.
+func renderEntity(e MessageEntity, raw, inner string) string {
+	switch e.Type {
+	case "text_link":
+		if e.URL == nil || *e.URL == raw {
+			return inner
+		}
+		return inner + " (" + *e.URL + ")"
+	case "code":
+		return "`" + raw + "`"
+	case "pre":
+		return "```\n" + strings.Trim(raw, "\n") + "\n```"
+	case "bold":
+		return "*" + inner + "*"
+	case "italic":
+		return "_" + inner + "_"
+	case "strikethrough":
+		return "~" + inner + "~"
+	case "blockquote", "expandable_blockquote":
+		lines := strings.Split(strings.TrimRight(inner, "\n"), "\n")
+		return "> " + strings.Join(lines, "\n> ")
+	default:
+		return inner
+	}
+}
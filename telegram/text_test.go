@@ -72,3 +72,81 @@ func TestFormatText(t *testing.T) {
 		t.Errorf("formatText(%+v)=%q, want %q", msg, got, test.want)
 	}
 }
+
+func TestEntityText(t *testing.T) {
+	url := "https://a.com"
+	lang := "go"
+	tests := []struct {
+		text     string
+		entities []MessageEntity
+		want     string
+	}{
+		{text: "hello", want: "hello"},
+		{
+			text:     "see here",
+			entities: []MessageEntity{{Type: "text_link", Offset: 4, Length: 4, URL: &url}},
+			want:     "see here (https://a.com)",
+		},
+		{
+			text:     "https://a.com",
+			entities: []MessageEntity{{Type: "text_link", Offset: 0, Length: 13, URL: &url}},
+			want:     "https://a.com",
+		},
+		{
+			text:     "run go vet now",
+			entities: []MessageEntity{{Type: "code", Offset: 4, Length: 6}},
+			want:     "run `go vet` now",
+		},
+		{
+			text:     "code:\nx := 1\n",
+			entities: []MessageEntity{{Type: "pre", Offset: 6, Length: 7, Language: &lang}},
+			want:     "code:\n```\nx := 1\n```",
+		},
+		{
+			text: "bold italic struck",
+			entities: []MessageEntity{
+				{Type: "italic", Offset: 5, Length: 6},
+				{Type: "bold", Offset: 0, Length: 4},
+				{Type: "strikethrough", Offset: 12, Length: 6},
+			},
+			want: "*bold* _italic_ ~struck~",
+		},
+		{
+			// Offsets are in UTF-16 code units.
+			// 😀 is two code units; α is one.
+			text:     "😀α link",
+			entities: []MessageEntity{{Type: "text_link", Offset: 4, Length: 4, URL: &url}},
+			want:     "😀α link (https://a.com)",
+		},
+		{
+			// Nested entities.
+			text: "a bold link",
+			entities: []MessageEntity{
+				{Type: "bold", Offset: 2, Length: 9},
+				{Type: "text_link", Offset: 7, Length: 4, URL: &url},
+			},
+			want: "a *bold link (https://a.com)*",
+		},
+		{
+			text:     "quote:\none\ntwo",
+			entities: []MessageEntity{{Type: "blockquote", Offset: 7, Length: 7}},
+			want:     "quote:\n> one\n> two",
+		},
+		{
+			text:     "@alice hi",
+			entities: []MessageEntity{{Type: "mention", Offset: 0, Length: 6}},
+			want:     "@alice hi",
+		},
+		{
+			// Out of bounds entities are ignored.
+			text:     "short",
+			entities: []MessageEntity{{Type: "bold", Offset: 3, Length: 10}},
+			want:     "short",
+		},
+	}
+	for _, test := range tests {
+		if got := entityText(test.text, test.entities); got != test.want {
+			t.Errorf("entityText(%q, %+v)=%q, want %q", test.text, test.entities, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/velour/chat"
+)
+
+// maxUploadSize is the largest file, in bytes, that a bot may upload.
+// Larger media are sent as links.
+var maxUploadSize int64 = 50 * megabyte
+
+const (
+	// maxPhotoSize is the largest photo, in bytes, that a bot may send.
+	// Larger images are sent as documents.
+	maxPhotoSize = 10 * megabyte
+	// maxCaptionLength is the maximum number of characters in a caption.
+	maxCaptionLength = 1024
+	// maxMediaGroup is the maximum number of media in a media group.
+	maxMediaGroup = 10
+	// probeTimeout is the timeout for probing the content type of a link.
+	probeTimeout = 5 * time.Second
+)
+
+var (
+	errTooLarge       = errors.New("file too large")
+	errCaptionTooLong = errors.New("caption too long")
+)
+
+// An upload is a file to upload with a send method.
+type upload struct {
+	name string
+	data []byte
+	// kind is the Telegram media type:
+	// photo, animation, video, or document.
+	kind string
+}
+
+// mediaAttachments returns the media to send for a message.
+//
+// If the message has Attachments, they are returned.
+// Otherwise, if the message text contains exactly one link,
+// and the link's content type is an image or video,
+// an Attachment for the link is returned.
+// Otherwise nil is returned.
+func mediaAttachments(ctx context.Context, c *Client, msg chat.Message) []chat.Attachment {
+	if len(msg.Attachments) > 0 {
+		return msg.Attachments
+	}
+	link, ok := soleLink(msg.Text)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodHead, link, nil)
+	if err != nil {
+		return nil
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil
+	}
+	resp.Body.Close()
+	mimeType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode != http.StatusOK ||
+		!strings.HasPrefix(mimeType, "image/") && !strings.HasPrefix(mimeType, "video/") {
+		return nil
+	}
+	var size int64
+	if resp.ContentLength > 0 {
+		size = resp.ContentLength
+	}
+	return []chat.Attachment{{URL: link, MimeType: mimeType, Size: size}}
+}
+
+// soleLink returns the link in the text,
+// if the text contains exactly one link.
+func soleLink(text string) (string, bool) {
+	i, link := linkIndex(text)
+	if i < 0 {
+		return "", false
+	}
+	if j, _ := linkIndex(text[i+len(link):]); j >= 0 {
+		return "", false
+	}
+	return link, true
+}
+
+// sendMedia sends a message with media attachments,
+// uploading the attachments and using the message text as the caption.
+func sendMedia(ctx context.Context, ch *channel, msg chat.Message, attachments []chat.Attachment) (chat.Message, error) {
+	caption := mediaCaption(msg, attachments)
+	if utf8.RuneCountInString(html.UnescapeString(stripTags(caption))) > maxCaptionLength {
+		return chat.Message{}, errCaptionTooLong
+	}
+	var uploads []upload
+	for _, a := range attachments {
+		u, err := download(ctx, ch.client, a)
+		if err != nil {
+			return chat.Message{}, err
+		}
+		uploads = append(uploads, u)
+	}
+
+	fields := make(map[string]string)
+	for k, v := range ch.sendRequest() {
+		fields[k] = fmt.Sprint(v)
+	}
+	if msg.ReplyTo != nil {
+		fields["reply_to_message_id"] = string(msg.ReplyTo.ID)
+	}
+
+	if len(uploads) == 1 {
+		u := uploads[0]
+		fields["caption"] = caption
+		fields["parse_mode"] = "HTML"
+		var resp Message
+		method := "send" + strings.ToUpper(u.kind[:1]) + u.kind[1:]
+		files := map[string]upload{u.kind: u}
+		if err := rpcMultipart(ctx, ch.client, method, fields, files, &resp); err != nil {
+			return chat.Message{}, err
+		}
+		msg.ID = chatMessageID(&resp)
+		return msg, nil
+	}
+
+	// Media groups can mix photos and videos,
+	// but other media must be grouped as documents.
+	for _, u := range uploads {
+		if u.kind != "photo" && u.kind != "video" {
+			for i := range uploads {
+				uploads[i].kind = "document"
+			}
+			break
+		}
+	}
+	var first *Message
+	for len(uploads) > 0 {
+		n := len(uploads)
+		if n > maxMediaGroup {
+			n = maxMediaGroup
+		}
+		var media []map[string]interface{}
+		files := make(map[string]upload)
+		for i, u := range uploads[:n] {
+			name := "file" + strconv.Itoa(i)
+			files[name] = u
+			m := map[string]interface{}{
+				"type":  u.kind,
+				"media": "attach://" + name,
+			}
+			if first == nil && i == 0 {
+				m["caption"] = caption
+				m["parse_mode"] = "HTML"
+			}
+			media = append(media, m)
+		}
+		data, err := json.Marshal(media)
+		if err != nil {
+			return chat.Message{}, err
+		}
+		fields["media"] = string(data)
+		var resp []Message
+		if err := rpcMultipart(ctx, ch.client, "sendMediaGroup", fields, files, &resp); err != nil {
+			return chat.Message{}, err
+		}
+		if first == nil && len(resp) > 0 {
+			first = &resp[0]
+		}
+		// Only the first group is a reply.
+		delete(fields, "reply_to_message_id")
+		uploads = uploads[n:]
+	}
+	if first == nil {
+		return chat.Message{}, errors.New("empty sendMediaGroup response")
+	}
+	msg.ID = chatMessageID(first)
+	return msg, nil
+}
+
+// mediaCaption returns the HTML-formatted caption for a message with media.
+// It is the formatted text of the message, with the attachment URLs removed,
+// along with the ": " before them in text like "/me shared a file: <url>".
+func mediaCaption(msg chat.Message, attachments []chat.Attachment) string {
+	for _, a := range attachments {
+		msg.Text = strings.Replace(msg.Text, ": "+a.URL, "", -1)
+		msg.Text = strings.Replace(msg.Text, a.URL, "", -1)
+	}
+	msg.Text = strings.TrimSpace(msg.Text)
+	return formatText(msg)
+}
+
+// stripTags removes HTML tags from formatted text.
+func stripTags(s string) string {
+	var b strings.Builder
+	var inTag bool
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>':
+			inTag = false
+		case !inTag:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// download downloads an attachment.
+// If the attachment is larger than maxUploadSize, errTooLarge is returned.
+func download(ctx context.Context, c *Client, a chat.Attachment) (upload, error) {
+	if a.Size > maxUploadSize {
+		return upload{}, errTooLarge
+	}
+	req, err := http.NewRequest(http.MethodGet, a.URL, nil)
+	if err != nil {
+		return upload{}, err
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return upload{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return upload{}, errors.New(resp.Status)
+	}
+	if resp.ContentLength > maxUploadSize {
+		return upload{}, errTooLarge
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxUploadSize+1))
+	if err != nil {
+		return upload{}, err
+	}
+	if int64(len(data)) > maxUploadSize {
+		return upload{}, errTooLarge
+	}
+
+	name := a.Name
+	if name == "" {
+		if u, err := url.Parse(a.URL); err == nil {
+			name = path.Base(u.Path)
+		}
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+	mimeType := a.MimeType
+	if mimeType == "" {
+		mimeType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	}
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = mime.TypeByExtension(path.Ext(name))
+	}
+	return upload{name: name, data: data, kind: mediaKind(mimeType, len(data))}, nil
+}
+
+// mediaKind returns the Telegram media type for a file
+// with the given MIME type and size in bytes.
+func mediaKind(mimeType string, size int) string {
+	switch mimeType, _, _ = mime.ParseMediaType(mimeType); {
+	case mimeType == "image/gif":
+		return "animation"
+	case (mimeType == "image/jpeg" || mimeType == "image/png" || mimeType == "image/webp") &&
+		size <= maxPhotoSize:
+		return "photo"
+	case mimeType == "video/mp4":
+		return "video"
+	default:
+		return "document"
+	}
+}
+
+// rpcMultipart calls a Bot API method with a multipart/form-data request,
+// uploading the files, keyed by their form field name.
+func rpcMultipart(ctx context.Context, c *Client, method string, fields map[string]string, files map[string]upload, resp interface{}) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for k, v := range fields {
+		if err := w.WriteField(k, v); err != nil {
+			return err
+		}
+	}
+	for k, u := range files {
+		fw, err := w.CreateFormFile(k, u.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(u.data); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	url := c.apiURL + "/bot" + c.token + "/" + method
+	newReq := func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		return req, nil
+	}
+	chatID, _ := strconv.ParseInt(fields["chat_id"], 10, 64)
+	httpResp, err := reqWithRetry(ctx, c, method, chatID, newReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if err := decodeResult(httpResp, resp); err != nil {
+		log.Printf("Telegram RPC %s %+v failed: %s\n", method, fields, err)
+		return err
+	}
+	return nil
+}
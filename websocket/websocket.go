@@ -93,14 +93,16 @@ func newConn(conn *websocket.Conn) *Conn {
 }
 
 // Close sends a close message to the peer and closes the websocket.
-// If the context has a deadline, that deadline is used to wait for the close to send,
-// otherwise it uses a 1 second deadline.
+// If the context has a deadline, that deadline is used to wait for the close to send
+// and for the peer to reply, otherwise it uses a 1 second deadline.
 func (c *Conn) Close(ctx context.Context) error {
 	dl := time.Now().Add(1 * time.Second)
 	if d, ok := ctx.Deadline(); ok {
 		dl = d
 	}
 	c.conn.WriteControl(websocket.CloseMessage, nil, dl)
+	// Don't wait forever for a peer that doesn't reply to the close.
+	c.conn.SetReadDeadline(dl)
 
 	close(c.send)
 	close(c.closed)
@@ -110,15 +112,36 @@ func (c *Conn) Close(ctx context.Context) error {
 	return c.conn.Close()
 }
 
+// A Message is a text or binary websocket data message.
+type Message struct {
+	// Binary is whether the message is a binary message.
+	// If false, it is a text message.
+	Binary bool
+	// Data is the message payload.
+	Data []byte
+}
+
 // Send sends a JSON-encoded message.
 //
 // Send must not be called on a closed connection.
 func (c *Conn) Send(ctx context.Context, msg interface{}) error {
+	return c.sendReq(ctx, sendReq{msg: msg})
+}
+
+// SendMessage sends a text or binary message.
+//
+// SendMessage must not be called on a closed connection.
+func (c *Conn) SendMessage(ctx context.Context, msg Message) error {
+	return c.sendReq(ctx, sendReq{raw: &msg})
+}
+
+func (c *Conn) sendReq(ctx context.Context, req sendReq) error {
 	result := make(chan error)
+	req.result = result
 	select {
 	case <-ctx.Done():
 		return ctx.Err()
-	case c.send <- sendReq{msg: msg, result: result}:
+	case c.send <- req:
 	}
 	select {
 	case <-ctx.Done():
@@ -129,44 +152,72 @@ func (c *Conn) Send(ctx context.Context, msg interface{}) error {
 }
 
 type sendReq struct {
-	msg    interface{}
+	msg interface{}
+	// raw, if non-nil, is sent instead of the JSON-encoded msg.
+	raw    *Message
 	result chan<- error
 }
 
 func (c *Conn) goSend() {
 	for req := range c.send {
-		req.result <- c.conn.WriteJSON(req.msg)
+		switch {
+		case req.raw == nil:
+			req.result <- c.conn.WriteJSON(req.msg)
+		case req.raw.Binary:
+			req.result <- c.conn.WriteMessage(websocket.BinaryMessage, req.raw.Data)
+		default:
+			req.result <- c.conn.WriteMessage(websocket.TextMessage, req.raw.Data)
+		}
 	}
 }
 
-// Recv receives the next JSON-encoded message into msg.
+// Recv receives the next JSON-encoded text message into msg.
 // If msg is nill, the received message is discarded.
+// Binary messages are skipped.
 //
 // This function must be called continually until Close() is called,
 // otherwise the connection will not respond to ping/pong messages.
 //
 // Calling Recv on a closed connection returns io.EOF.
 func (c *Conn) Recv(ctx context.Context, msg interface{}) error {
+	for {
+		m, err := c.RecvMessage(ctx)
+		if err != nil {
+			return err
+		}
+		if m.Binary {
+			continue
+		}
+		if msg == nil {
+			return nil
+		}
+		return json.Unmarshal(m.Data, msg)
+	}
+}
+
+// RecvMessage receives the next text or binary message.
+//
+// Like Recv, RecvMessage must be called continually until Close() is called,
+// and calling RecvMessage on a closed connection returns io.EOF.
+func (c *Conn) RecvMessage(ctx context.Context) (Message, error) {
 	select {
 	case <-ctx.Done():
-		return ctx.Err()
+		return Message{}, ctx.Err()
 	case r, ok := <-c.recv:
 		if !ok {
-			return io.EOF
+			return Message{}, io.EOF
 		}
 		if r.err != nil {
-			return r.err
-		}
-		if msg == nil {
-			return nil
+			return Message{}, r.err
 		}
-		return json.Unmarshal(r.p, msg)
+		return Message{Binary: r.binary, Data: r.p}, nil
 	}
 }
 
 type recvMsg struct {
-	p   []byte
-	err error
+	binary bool
+	p      []byte
+	err    error
 }
 
 func (c *Conn) goRecv() {
@@ -180,9 +231,10 @@ func (c *Conn) goRecv() {
 			// We will reply to the close when the caller calls Close().
 			return
 		}
-		if m.err == nil && t != websocket.TextMessage {
+		if m.err == nil && t != websocket.TextMessage && t != websocket.BinaryMessage {
 			continue
 		}
+		m.binary = t == websocket.BinaryMessage
 		// Send the bytes or the error to the next receiver,
 		// but don't wait in the case that the connection was closed.
 		select {
@@ -3,6 +3,7 @@
 package websocket
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"net/http"
@@ -11,6 +12,9 @@ import (
 	"path"
 	"strconv"
 	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 var testCTX = context.Background()
@@ -71,6 +75,54 @@ func TestEcho(t *testing.T) {
 	}
 }
 
+func TestEchoMessage(t *testing.T) {
+	handler := http.HandlerFunc(echoMessagesUntilClose(t))
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	URL, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%q)=_,%v", s.URL, err)
+	}
+	URL.Scheme = "ws"
+	conn, err := Dial(testCTX, URL)
+	if err != nil {
+		t.Fatalf("Dial(%s)=_,%v", URL, err)
+	}
+
+	msgs := []Message{
+		{Binary: true, Data: []byte{0, 1, 2}},
+		{Binary: false, Data: []byte(`"text"`)},
+	}
+	for _, sent := range msgs {
+		if err := conn.SendMessage(testCTX, sent); err != nil {
+			t.Fatalf("client conn.SendMessage(%v)=%v", sent, err)
+		}
+		recvd, err := conn.RecvMessage(testCTX)
+		if err != nil {
+			t.Fatalf("client conn.RecvMessage()=_,%v", err)
+		}
+		if recvd.Binary != sent.Binary || !bytes.Equal(recvd.Data, sent.Data) {
+			t.Errorf("recvd=%v, want %v", recvd, sent)
+		}
+	}
+
+	// Recv skips binary messages.
+	if err := conn.SendMessage(testCTX, msgs[0]); err != nil {
+		t.Fatalf("client conn.SendMessage(%v)=%v", msgs[0], err)
+	}
+	if err := conn.Send(testCTX, "json"); err != nil {
+		t.Fatalf("client conn.Send(json)=%v", err)
+	}
+	var recvd string
+	if err := conn.Recv(testCTX, &recvd); err != nil || recvd != "json" {
+		t.Errorf("client conn.Recv(&recvd)=%v, recvd=%q, want nil, json", err, recvd)
+	}
+	if err := conn.Close(testCTX); err != nil {
+		t.Errorf("client conn.Close()=%v", err)
+	}
+}
+
 func TestRecvOnClosedConn(t *testing.T) {
 	handler := http.HandlerFunc(recvUntilClose(t))
 	s := httptest.NewServer(handler)
@@ -96,6 +148,46 @@ func TestRecvOnClosedConn(t *testing.T) {
 	}
 }
 
+func TestCloseUnresponsivePeer(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Upgrade, but never read, so the close is never replied to.
+		var upgrader websocket.Upgrader
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrader.Upgrade(…)=_,%v", err)
+			return
+		}
+		defer conn.Close()
+		<-done
+	})
+	s := httptest.NewServer(handler)
+	defer s.Close()
+
+	URL, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("url.Parse(%q)=_,%v", s.URL, err)
+	}
+	URL.Scheme = "ws"
+	conn, err := Dial(testCTX, URL)
+	if err != nil {
+		t.Fatalf("Dial(%s)=_,%v", URL, err)
+	}
+	ctx, cancel := context.WithTimeout(testCTX, 100*time.Millisecond)
+	defer cancel()
+	closed := make(chan struct{})
+	go func() {
+		conn.Close(ctx)
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("conn.Close(…) did not return")
+	}
+}
+
 func TestRecvNill(t *testing.T) {
 	handler := http.HandlerFunc(echoUntilClose(t))
 	s := httptest.NewServer(handler)
@@ -146,6 +238,30 @@ func echoUntilClose(t *testing.T) http.HandlerFunc {
 	})
 }
 
+func echoMessagesUntilClose(t *testing.T) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(testCTX, w, r)
+		if err != nil {
+			t.Fatalf("Upgrade(w, r)=%v", err)
+		}
+		for {
+			switch m, err := conn.RecvMessage(testCTX); {
+			case err == io.EOF:
+				if err := conn.Close(testCTX); err != nil {
+					t.Errorf("server conn.Close()=%v", err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("server conn.RecvMessage()=_,%v", err)
+			default:
+				if err := conn.SendMessage(testCTX, m); err != nil {
+					t.Fatalf("server conn.SendMessage(%v)=%v", m, err)
+				}
+			}
+		}
+	})
+}
+
 func recvUntilClose(t *testing.T) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		conn, err := Upgrade(testCTX, w, r)